@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type AccountType string
+
+const (
+	AccountChecking  AccountType = "checking"
+	AccountCredit    AccountType = "credit"
+	AccountBrokerage AccountType = "brokerage"
+	Account401k      AccountType = "401k"
+	AccountIRA       AccountType = "ira"
+	AccountHSA       AccountType = "hsa"
+)
+
+var accountTypes = []AccountType{AccountChecking, AccountCredit, AccountBrokerage, Account401k, AccountIRA, AccountHSA}
+
+func ParseAccountType(s string) (AccountType, error) {
+	for _, t := range accountTypes {
+		if string(t) == strings.ToLower(s) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("invalid account type %s (expecting one of checking, credit, brokerage, 401k, ira, hsa)", s)
+}
+
+var taxTreatments = []string{"taxable", "tax-deferred", "tax-free"}
+
+func ParseTaxTreatment(s string) (string, error) {
+	if contains(strings.ToLower(s), taxTreatments) {
+		return strings.ToLower(s), nil
+	}
+	return "", fmt.Errorf("invalid tax treatment %s (expecting one of taxable, tax-deferred, tax-free)", s)
+}
+
+// An Account is identified by the transaction source (e.g. "dcu2") or the
+// brokerage account number that its imported rows are tagged with
+type Account struct {
+	Id           string
+	Name         string
+	Institution  string
+	Type         AccountType
+	Owner        string
+	TaxTreatment string
+	Currency     string
+	Closed       bool
+}
+
+func (account *Account) Status() string {
+	if account.Closed {
+		return "closed"
+	}
+	return "open"
+}
+
+func (pdb *PennyDb) Accounts() []*Account {
+	pdb.mutex.RLock()
+	defer pdb.mutex.RUnlock()
+	return pdb.accountCache
+}
+
+// Returns nil if there's no account registered with this ID
+func (pdb *PennyDb) Account(id string) *Account {
+	return findAccount(pdb.Accounts(), id)
+}
+
+func findAccount(accounts []*Account, id string) *Account {
+	for _, account := range accounts {
+		if account.Id == id {
+			return account
+		}
+	}
+	return nil
+}
+
+// Currency of the transactions imported into an account
+func (pdb *PennyDb) AccountCurrency(id string) string {
+	account := pdb.Account(id)
+	if account == nil || len(account.Currency) == 0 {
+		return DefaultCurrency
+	}
+	return account.Currency
+}
+
+// Display name of an account, falling back to the ID for unregistered accounts
+func (pdb *PennyDb) AccountName(id string) string {
+	account := pdb.Account(id)
+	if account == nil || len(account.Name) == 0 {
+		return id
+	}
+	return account.Name
+}
+
+func (pdb *PennyDb) SaveAccount(account *Account) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	_, err = handle.Exec(
+		`REPLACE INTO account (id, name, institution, type, owner, tax_treatment, currency, closed) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		account.Id,
+		account.Name,
+		account.Institution,
+		string(account.Type),
+		account.Owner,
+		account.TaxTreatment,
+		account.Currency,
+		account.Closed)
+
+	if err != nil {
+		return err
+	}
+
+	pdb.accountCache, err = handle.AllAccounts()
+	return err
+}
+
+func (handle *PennyDbHandle) AllAccounts() ([]*Account, error) {
+	rows, err := handle.Query("SELECT id, name, institution, type, owner, tax_treatment, currency, closed FROM account ORDER BY closed, name, id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		var account Account
+		var accountType string
+		err = rows.Scan(
+			&account.Id,
+			&account.Name,
+			&account.Institution,
+			&accountType,
+			&account.Owner,
+			&account.TaxTreatment,
+			&account.Currency,
+			&account.Closed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		account.Type = AccountType(accountType)
+		accounts = append(accounts, &account)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
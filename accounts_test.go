@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAccountsMigratedFromCache(t *testing.T) {
+	dbPath := tempFilePath()
+	defer os.Remove(dbPath)
+
+	pdb, err := NewPennyDb(dbPath, NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+
+	handle, err := pdb.OpenReadWrite()
+	fail(t, err)
+	_, err = handle.Exec(`DROP TABLE account`)
+	fail(t, err)
+	_, err = handle.Exec(`CREATE TABLE accounts_cache (key TEXT PRIMARY KEY, value TEXT, date DATETIME)`)
+	fail(t, err)
+	_, err = handle.Exec(`INSERT INTO accounts_cache (key, value) VALUES ('123', 'Roth'), ('456', '')`)
+	fail(t, err)
+	fail(t, handle.Close())
+
+	err = pdb.LoadCaches()
+	fail(t, err)
+
+	if len(pdb.Accounts()) != 1 || pdb.AccountName("123") != "Roth" || pdb.AccountName("456") != "456" {
+		t.Fatalf("expecting account 123 to be migrated from accounts_cache, got %v", pdb.Accounts())
+	}
+
+	err = pdb.SaveAccount(&Account{"dcu", "Checking", "DCU", AccountChecking, "", "taxable", "USD", true})
+	fail(t, err)
+
+	account := pdb.Account("dcu")
+	if account == nil || account.Name != "Checking" || account.Status() != "closed" {
+		t.Fatalf("expecting closed account dcu, got %v", account)
+	}
+}
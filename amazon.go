@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An item of an Amazon order, from the order history exports
+type AmazonItem struct {
+	Title    string
+	Category string // Amazon's category, if the export has one
+	Quantity int
+	Total    float64 // including tax
+}
+
+// An AmazonOrder is charged to the card as one transaction for its total
+type AmazonOrder struct {
+	Id       string
+	Date     time.Time
+	Currency string
+	Items    []*AmazonItem
+}
+
+func (order *AmazonOrder) Total() float64 {
+	var total float64
+	for _, item := range order.Items {
+		total += item.Total
+	}
+	return math.Round(total*100) / 100
+}
+
+// Amazon's order history exports come in two layouts: the items report
+// ("Order Date", "Title", "Category", "Item Total") and the order history of
+// the account data export ("Order Date", "Product Name", "Total Owed")
+func ParseAmazonOrders(contents []byte) ([]*AmazonOrder, error) {
+	header, rows, err := readCsvRows(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	titleColumn, totalColumn := "Title", "Item Total"
+	if contains("Product Name", header) {
+		titleColumn, totalColumn = "Product Name", "Total Owed"
+	}
+	for _, column := range []string{"Order ID", "Order Date", titleColumn, totalColumn} {
+		if !contains(column, header) {
+			return nil, fmt.Errorf("not an Amazon order history export (no %s column)", column)
+		}
+	}
+
+	var orders []*AmazonOrder
+	byId := make(map[string]*AmazonOrder)
+	for index, row := range rows {
+		order, ok := byId[row["Order ID"]]
+		if !ok {
+			date := row["Order Date"]
+			if len(date) > 10 && date[4] == '-' {
+				date = date[:10]
+			}
+			orderDate, err := parseDateFormats(date, "01/02/06", "01/02/2006", "2006-01-02")
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %v", index+2, err)
+			}
+			order = &AmazonOrder{row["Order ID"], orderDate, defaultString(row["Currency"], DefaultCurrency), nil}
+			byId[order.Id] = order
+			orders = append(orders, order)
+		}
+
+		total, err := parseMoney(row[totalColumn])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", index+2, err)
+		}
+		quantity := 1
+		fmt.Sscanf(row["Quantity"], "%d", &quantity)
+
+		order.Items = append(order.Items, &AmazonItem{row[titleColumn], row["Category"], quantity, total})
+	}
+	return orders, nil
+}
+
+var amazonMemo = regexp.MustCompile(`(?i)amazon|amzn`)
+
+// An AmazonMatch is an order and the card transaction it was charged as, if
+// one was found
+type AmazonMatch struct {
+	Order *AmazonOrder
+	Tx    *Transaction
+}
+
+// Matches each order to an Amazon charge for its total made within window
+// days after the order was placed, preferring the earliest.  A transaction is
+// only matched to one order.
+func MatchAmazonOrders(orders []*AmazonOrder, transactions []*Transaction, window int) []*AmazonMatch {
+	sorted := make([]*AmazonOrder, len(orders))
+	copy(sorted, orders)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+
+	var candidates []*Transaction
+	for _, tx := range transactions {
+		if tx.Amount < 0 && amazonMemo.MatchString(tx.Memo) {
+			candidates = append(candidates, tx)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Date.Before(candidates[j].Date) })
+
+	used := make(map[string]bool)
+	var matches []*AmazonMatch
+	for _, order := range sorted {
+		match := &AmazonMatch{order, nil}
+		last := order.Date.AddDate(0, 0, window)
+		for _, tx := range candidates {
+			if used[tx.Id()] || tx.Date.Before(order.Date) || tx.Date.After(last) {
+				continue
+			}
+			if math.Abs(-tx.Amount-order.Total()) < 0.005 {
+				match.Tx = tx
+				used[tx.Id()] = true
+				break
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// The item titles, as the note on the matched transaction
+func (match *AmazonMatch) Note() string {
+	var titles []string
+	for _, item := range match.Order.Items {
+		title := item.Title
+		if item.Quantity > 1 {
+			title = fmt.Sprintf("%dx %s", item.Quantity, title)
+		}
+		titles = append(titles, title)
+	}
+	return strings.Join(titles, "; ")
+}
+
+// Items are categorized by mapping Amazon's category through the import
+// rename table
+func (item *AmazonItem) ProposedCategory() string {
+	if len(item.Category) == 0 {
+		return ""
+	}
+	return importRenames.Category(item.Category)
+}
+
+// The category of the order when all of its items agree on one
+func (match *AmazonMatch) ProposedCategory() string {
+	var category string
+	for index, item := range match.Order.Items {
+		proposed := item.ProposedCategory()
+		if len(proposed) == 0 || (index > 0 && proposed != category) {
+			return ""
+		}
+		category = proposed
+	}
+	return category
+}
+
+// Reads Amazon order history exports and matches them against the card
+// transactions
+func PlanAmazonEnrichment(pdb *PennyDb, patterns []string, window int) ([]*AmazonMatch, error) {
+	paths, err := expandImportPaths(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exports can overlap or split an order across files, so an order is
+	// merged by its ID and an item already read from another file is read once
+	var orders []*AmazonOrder
+	byId := make(map[string]*AmazonOrder)
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileOrders, err := ParseAmazonOrders(contents)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, fileOrder := range fileOrders {
+			order, ok := byId[fileOrder.Id]
+			if !ok {
+				byId[fileOrder.Id] = fileOrder
+				orders = append(orders, fileOrder)
+				continue
+			}
+			seen := make(map[AmazonItem]int)
+			for _, item := range order.Items {
+				seen[*item]++
+			}
+			for _, item := range fileOrder.Items {
+				if seen[*item] > 0 {
+					seen[*item]--
+					continue
+				}
+				order.Items = append(order.Items, item)
+			}
+		}
+	}
+	return MatchAmazonOrders(orders, pdb.AllTransactions(), window), nil
+}
+
+// Notes the item titles on matched transactions and, with categorize, sets
+// the proposed category of uncategorized ones.  The titles are added after a
+// note the transaction already has, unless it already lists them.
+func (pdb *PennyDb) EnrichAmazon(matches []*AmazonMatch, categorize bool) error {
+	notes := make(map[string]string)
+	var categorized []*Transaction
+	for _, match := range matches {
+		if match.Tx == nil {
+			continue
+		}
+
+		id, note := match.Tx.Id(), match.Note()
+		existing, ok := notes[id]
+		if !ok {
+			existing = pdb.Note(id)
+		}
+		switch {
+		case strings.Contains(existing, note):
+		case len(existing) > 0:
+			notes[id] = existing + "; " + note
+		default:
+			notes[id] = note
+		}
+
+		if category := match.ProposedCategory(); categorize && len(category) > 0 && len(match.Tx.Category) == 0 {
+			tx := *match.Tx
+			tx.Category = category
+			categorized = append(categorized, &tx)
+		}
+	}
+	if err := pdb.SaveNotes(notes); err != nil {
+		return err
+	}
+	if len(categorized) == 0 {
+		return nil
+	}
+	return pdb.Update(categorized)
+}
+
+// One row per item, with the order and its transaction on the first
+func AmazonMatchTable(matches []*AmazonMatch) *Table {
+	table := &Table{Name: "amazon", Columns: []Column{
+		{"Order", "order"},
+		{"Date", "date"},
+		{"Total", "total"},
+		{"Transaction", "tx_id"},
+		{"Item", "item"},
+		{"Amount", "amount"},
+		{"Proposed Category", "category"},
+	}}
+
+	matched := 0
+	for _, match := range matches {
+		var txId interface{} = "unmatched"
+		if match.Tx != nil {
+			txId = match.Tx.Id()
+			matched++
+		}
+		for index, item := range match.Order.Items {
+			if index == 0 {
+				table.Append(match.Order.Id, Date(match.Order.Date), Money{-match.Order.Total(), match.Order.Currency}, txId, item.Title, Money{-item.Total, match.Order.Currency}, item.ProposedCategory())
+			} else {
+				table.Append(nil, nil, nil, nil, item.Title, Money{-item.Total, match.Order.Currency}, item.ProposedCategory())
+			}
+		}
+	}
+	table.Footer = []interface{}{fmt.Sprintf("%d/%d matched", matched, len(matches)), nil, nil, nil, nil, nil, nil}
+	return table
+}
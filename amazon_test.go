@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const amazonItemsSample = `Order Date,Order ID,Title,Category,ASIN/ISBN,Quantity,Item Total
+01/03/21,111-1,Pampers Size 3,Baby Product,B001,2,$45.98
+01/03/21,111-1,Baby Wipes,Baby Product,B002,1,$10.02
+01/05/21,222-2,USB-C Cable,Electronics,B003,1,$12.99
+01/20/21,333-3,Desk Lamp,Home,B004,1,$30.00
+`
+
+const amazonOrderHistorySample = `Website,Order ID,Order Date,Currency,Unit Price,Total Owed,Quantity,Product Name
+Amazon.com,444-4,2021-02-01T18:22:11Z,USD,9.99,10.79,1,Paperback Novel
+`
+
+func TestParseAmazonOrders(t *testing.T) {
+	orders, err := ParseAmazonOrders([]byte(amazonItemsSample))
+	fail(t, err)
+	if len(orders) != 3 {
+		t.Fatalf("expected items to be grouped into 3 orders, got %d", len(orders))
+	}
+	if orders[0].Total() != 56 || len(orders[0].Items) != 2 || orders[0].Items[0].Quantity != 2 || orders[0].Date.Format("2006-01-02") != "2021-01-03" {
+		t.Fatalf("unexpected order %+v", orders[0])
+	}
+
+	orders, err = ParseAmazonOrders([]byte(amazonOrderHistorySample))
+	fail(t, err)
+	if len(orders) != 1 || orders[0].Total() != 10.79 || orders[0].Items[0].Title != "Paperback Novel" || orders[0].Date.Format("2006-01-02") != "2021-02-01" {
+		t.Fatalf("unexpected order %+v", orders[0])
+	}
+
+	if _, err := ParseAmazonOrders([]byte("DATE,DESCRIPTION,AMOUNT\n")); err == nil {
+		t.Fatalf("expected an error for a file that isn't an order export")
+	}
+}
+
+func TestMatchAmazonOrders(t *testing.T) {
+	orders, err := ParseAmazonOrders([]byte(amazonItemsSample))
+	fail(t, err)
+
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	txs := []*Transaction{
+		{"chase", date("2021-01-04"), "AMAZON MKTPLACE PMTS", -56, "", "USD", "", false},
+		{"chase", date("2021-01-05"), "AMZN Mktp US", -12.99, "", "USD", "", false},
+		{"chase", date("2021-01-05"), "BEST BUY", -12.99, "", "USD", "", false},
+		// Charged too long after the order
+		{"chase", date("2021-01-30"), "AMAZON MKTPLACE PMTS", -30, "", "USD", "", false},
+	}
+
+	matches := MatchAmazonOrders(orders, txs, 3)
+	if len(matches) != 3 {
+		t.Fatalf("expected a match per order, got %d", len(matches))
+	}
+	if matches[0].Tx != txs[0] || matches[0].Note() != "2x Pampers Size 3; Baby Wipes" {
+		t.Fatalf("unexpected match %+v (%s)", matches[0].Tx, matches[0].Note())
+	}
+	if matches[1].Tx != txs[1] {
+		t.Fatalf("expected the Amazon charge to be matched, got %v", matches[1].Tx)
+	}
+	if matches[2].Tx != nil {
+		t.Fatalf("expected a charge outside the window not to match, got %v", matches[2].Tx)
+	}
+}
+
+func TestEnrichAmazon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "amazon")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { importRenames = &ImportRenames{} }()
+	importRenames = &ImportRenames{Categories: map[string]string{"Baby Product": "kids", "Electronics": "gadgets"}}
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	fail(t, pdb.Insert([]*Transaction{
+		{"chase", date("2021-01-04"), "AMAZON MKTPLACE PMTS", -56, "", "USD", "", false},
+		{"chase", date("2021-01-06"), "AMZN Mktp US", -12.99, "", "USD", "shopping", false},
+	}))
+	fail(t, pdb.LoadCaches())
+
+	path := filepath.Join(dir, "items.csv")
+	fail(t, ioutil.WriteFile(path, []byte(amazonItemsSample), 0600))
+
+	matches, err := PlanAmazonEnrichment(pdb, []string{path}, 3)
+	fail(t, err)
+	if category := matches[0].ProposedCategory(); category != "kids" {
+		t.Fatalf("expected the items' category to be proposed, got %q", category)
+	}
+	if rows := len(AmazonMatchTable(matches).Rows); rows != 4 {
+		t.Fatalf("expected a row per item, got %d", rows)
+	}
+
+	// A note of the user's own is kept, and enriching twice notes the items once
+	for _, tx := range pdb.AllTransactions() {
+		if tx.Memo == "AMZN Mktp US" {
+			fail(t, pdb.SaveNote(tx.Id(), "for the car"))
+		}
+	}
+	fail(t, pdb.EnrichAmazon(matches, true))
+	fail(t, pdb.EnrichAmazon(matches, true))
+	fail(t, pdb.LoadCaches())
+	for _, tx := range pdb.AllTransactions() {
+		switch tx.Memo {
+		case "AMAZON MKTPLACE PMTS":
+			if tx.Category != "kids" || pdb.Note(tx.Id()) != "2x Pampers Size 3; Baby Wipes" {
+				t.Fatalf("unexpected enrichment %v (%s)", tx, pdb.Note(tx.Id()))
+			}
+		case "AMZN Mktp US":
+			if tx.Category != "shopping" || pdb.Note(tx.Id()) != "for the car; USB-C Cable" {
+				t.Fatalf("expected the existing category to be kept, got %v (%s)", tx, pdb.Note(tx.Id()))
+			}
+		}
+	}
+}
+
+func TestPlanAmazonEnrichmentMergesOrders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "amazon")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	date, _ := time.Parse("2006-01-02", "2021-01-04")
+	fail(t, pdb.Insert([]*Transaction{{"chase", date, "AMAZON MKTPLACE PMTS", -56, "", "USD", "", false}}))
+	fail(t, pdb.LoadCaches())
+
+	// The second export overlaps the first and has the rest of the order
+	december := filepath.Join(dir, "december.csv")
+	fail(t, ioutil.WriteFile(december, []byte(`Order Date,Order ID,Title,Category,ASIN/ISBN,Quantity,Item Total
+01/03/21,111-1,Pampers Size 3,Baby Product,B001,2,$45.98
+`), 0600))
+	january := filepath.Join(dir, "january.csv")
+	fail(t, ioutil.WriteFile(january, []byte(amazonItemsSample), 0600))
+
+	matches, err := PlanAmazonEnrichment(pdb, []string{december, january}, 3)
+	fail(t, err)
+	if len(matches) != 3 {
+		t.Fatalf("expected a match per order, got %d", len(matches))
+	}
+	if matches[0].Order.Total() != 56 || matches[0].Tx == nil || matches[0].Note() != "2x Pampers Size 3; Baby Wipes" {
+		t.Fatalf("expected the order to be merged across files, got %+v (%s)", matches[0].Order, matches[0].Note())
+	}
+}
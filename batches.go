@@ -0,0 +1,445 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// An ImportBatch records one imported file so that its rows can be traced
+// back to it and rolled back if the import was wrong
+type ImportBatch struct {
+	Id       int64
+	File     string
+	Hash     string // SHA-256 of the file contents
+	Importer string
+	Imported time.Time
+	Rows     int // transactions and investments inserted
+}
+
+// Inserts the new rows of every imported file as a batch per file, all
+// through one handle on the database
+func (pdb *PennyDb) InsertBatches(summaries []*ImportSummary, imported time.Time) error {
+	var pending []*ImportSummary
+	for _, summary := range summaries {
+		if summary.Err == nil && summary.New > 0 {
+			pending = append(pending, summary)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	for _, summary := range pending {
+		path, err := filepath.Abs(summary.File)
+		if err != nil {
+			return err
+		}
+		batch := &ImportBatch{0, path, summary.hash, summary.Importer, imported, 0}
+		err = handle.insertBatch(batch, summary.transactions, summary.investments, summary.rows)
+		if err != nil {
+			return err
+		}
+		if batch.Id != 0 {
+			summary.Batch = batch
+		}
+	}
+	return nil
+}
+
+// Inserts new transactions and investments as one batch, along with the
+// external IDs, tags, notes and statement balances the importer found for
+// them, in one SQL transaction.  The batch is only recorded when at least one
+// row is new.
+func (handle *PennyDbHandle) insertBatch(batch *ImportBatch, transactions []*Transaction, investments []*Investment, importer *TransactionImporter) error {
+	pdb := handle.pdb
+	return handle.atomic(func() error {
+		res, err := handle.Exec(
+			`INSERT INTO import_batch (file, hash, importer, imported_at, rows) VALUES (?, ?, ?, ?, ?)`,
+			batch.File,
+			batch.Hash,
+			batch.Importer,
+			batch.Imported.Format(time.RFC3339),
+			0)
+
+		if err != nil {
+			return err
+		}
+
+		batch.Id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		// Rows already in the database are skipped, so only what was
+		// inserted counts
+		insertedTxs, err := handle.insertTransactions(transactions, batch.Id, importer.txExternalIds)
+		if err != nil {
+			return err
+		}
+
+		insertedInvestments, err := handle.insertInvestments(investments, batch.Id, importer.investmentExternalIds)
+		if err != nil {
+			return err
+		}
+
+		batch.Rows = len(insertedTxs) + len(insertedInvestments)
+		if batch.Rows == 0 {
+			_, err = handle.Exec(`DELETE FROM import_batch WHERE id = ?`, batch.Id)
+			batch.Id = 0
+			return err
+		}
+		_, err = handle.Exec(`UPDATE import_batch SET rows = ? WHERE id = ?`, batch.Rows, batch.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range insertedTxs {
+			for _, tag := range importer.tags[tx.Id()] {
+				_, err = handle.Exec(`INSERT OR IGNORE INTO tx_tag (tx_id, tag, batch_id) VALUES (?, ?, ?)`, tx.Id(), tag, batch.Id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		pdb.tagCache, err = handle.AllTags()
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range insertedTxs {
+			if note := importer.notes[tx.Id()]; len(note) > 0 {
+				_, err = handle.Exec(`REPLACE INTO tx_note (tx_id, note, batch_id) VALUES (?, ?, ?)`, tx.Id(), note, batch.Id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		pdb.noteCache, err = handle.AllNotes()
+		if err != nil {
+			return err
+		}
+
+		for _, balance := range importer.balances {
+			err = handle.saveBalance(balance, batch.Id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (pdb *PennyDb) ImportBatches() ([]*ImportBatch, error) {
+	handle, err := pdb.OpenReadOnly()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	rows, err := handle.Query("SELECT id, file, hash, importer, imported_at, rows FROM import_batch ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []*ImportBatch
+	for rows.Next() {
+		var batch ImportBatch
+		var imported string
+		err = rows.Scan(&batch.Id, &batch.File, &batch.Hash, &batch.Importer, &imported, &batch.Rows)
+		if err != nil {
+			return nil, err
+		}
+		batch.Imported, err = time.Parse(time.RFC3339, imported)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, &batch)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return batches, nil
+}
+
+// What a rollback removed, and the edited transactions it had to keep
+type Rollback struct {
+	Transactions int
+	Investments  int
+	Kept         []*Transaction
+}
+
+// A transaction has been edited since it was imported if its category,
+// source or ignored flag changed, it has been tagged (beyond the tags it was
+// imported with), its note was changed, or it was linked (other than to its
+// funding transaction, which importing links again) or marked reimbursable
+func (pdb *PennyDb) editedSinceImport(tx *Transaction, modified bool, importedTags map[string]bool, importedNote bool) bool {
+	id := tx.Id()
+	if modified {
+		return true
+	}
+	if _, ok := pdb.noteCache[id]; ok && !importedNote {
+		return true
+	}
+	for _, tag := range pdb.tagCache[id] {
+		if !importedTags[tag] {
+			return true
+		}
+	}
+	if _, ok := pdb.reimbursableCache[id]; ok {
+		return true
+	}
+	for _, link := range pdb.linkCache {
+		if link.Kind == LinkFundedBy {
+			continue
+		}
+		if link.From == id || link.To == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Deletes the rows of an import batch that haven't been edited since.  The
+// batch itself is removed once none of its rows are left.
+func (pdb *PennyDb) RollbackBatch(id int64) (*Rollback, error) {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	var file string
+	err = handle.db.QueryRow("SELECT file FROM import_batch WHERE id=?", id).Scan(&file)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no import batch %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := handle.Query("SELECT source, date, amount, memo, disambiguation, currency, category, ignored, modified FROM tx WHERE batch_id=?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []*Transaction
+	var modified []bool
+	for rows.Next() {
+		var tx Transaction
+		var date string
+		var edited bool
+		err = rows.Scan(&tx.Source, &date, &tx.Amount, &tx.Memo, &tx.Disambiguation, &tx.Currency, &tx.Category, &tx.Ignored, &edited)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tx.Date, err = time.Parse("2006-01-02", date)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		transactions = append(transactions, &tx)
+		modified = append(modified, edited)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// Tags added by the importer don't count as edits
+	importedTags := make(map[string]map[string]bool)
+	rows, err = handle.Query("SELECT tx_id, tag FROM tx_tag WHERE batch_id=?", id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var txId, tag string
+		err = rows.Scan(&txId, &tag)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if importedTags[txId] == nil {
+			importedTags[txId] = make(map[string]bool)
+		}
+		importedTags[txId][tag] = true
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// Notes saved since the import no longer belong to the batch
+	importedNotes := make(map[string]bool)
+	rows, err = handle.Query("SELECT tx_id FROM tx_note WHERE batch_id=?", id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var txId string
+		err = rows.Scan(&txId)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		importedNotes[txId] = true
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing is deleted unless everything is
+	rollback := &Rollback{}
+	err = handle.atomic(func() error {
+		for index, tx := range transactions {
+			if pdb.editedSinceImport(tx, modified[index], importedTags[tx.Id()], importedNotes[tx.Id()]) {
+				rollback.Kept = append(rollback.Kept, tx)
+				continue
+			}
+
+			_, err = handle.Exec(
+				`DELETE FROM tx WHERE date=? AND amount=? AND memo=? AND disambiguation=? AND batch_id=?`,
+				tx.Date.Format("2006-01-02"),
+				tx.Amount,
+				tx.Memo,
+				tx.Disambiguation,
+				id)
+
+			if err != nil {
+				return err
+			}
+
+			_, err = handle.Exec(`DELETE FROM tx_tag WHERE tx_id=? AND batch_id=?`, tx.Id(), id)
+			if err != nil {
+				return err
+			}
+
+			_, err = handle.Exec(`DELETE FROM tx_note WHERE tx_id=? AND batch_id=?`, tx.Id(), id)
+			if err != nil {
+				return err
+			}
+
+			_, err = handle.Exec(`DELETE FROM tx_link WHERE kind=? AND (from_id=? OR to_id=?)`, string(LinkFundedBy), tx.Id(), tx.Id())
+			if err != nil {
+				return err
+			}
+			rollback.Transactions++
+		}
+
+		res, err := handle.Exec(`DELETE FROM investment WHERE batch_id=?`, id)
+		if err != nil {
+			return err
+		}
+		investments, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		rollback.Investments = int(investments)
+
+		if len(rollback.Kept) == 0 {
+			_, err = handle.Exec(`DELETE FROM balance WHERE batch_id=?`, id)
+			if err != nil {
+				return err
+			}
+
+			_, err = handle.Exec(`DELETE FROM import_batch WHERE id=?`, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pdb.txCache, err = handle.AllTransactions()
+	if err != nil {
+		return nil, err
+	}
+
+	pdb.investmentCache, err = handle.AllInvestments()
+	if err != nil {
+		return nil, err
+	}
+
+	pdb.tagCache, err = handle.AllTags()
+	if err != nil {
+		return nil, err
+	}
+
+	pdb.noteCache, err = handle.AllNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	pdb.linkCache, err = handle.AllLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	return rollback, nil
+}
+
+// What a rollback deleted, and the edited transactions it kept
+func RollbackTables(id int64, rollback *Rollback) []*Table {
+	summary := NewKeyValueTable("rollback", "rollback")
+	summary.Append("Batch", int(id))
+	summary.Append("Deleted Transactions", rollback.Transactions)
+	summary.Append("Deleted Investments", rollback.Investments)
+
+	kept := &Table{Name: "kept", Title: "kept (edited since the import)", Columns: []Column{
+		{"ID", "id"},
+		{"Date", "date"},
+		{"Amount", "amount"},
+		{"Memo", "memo"},
+	}}
+	for _, tx := range rollback.Kept {
+		kept.Append(tx.Id(), Date(tx.Date), Money{tx.Amount, tx.Currency}, tx.Memo)
+	}
+	return []*Table{summary, kept}
+}
+
+func ImportBatchTable(batches []*ImportBatch) *Table {
+	table := &Table{Name: "batches", Columns: []Column{
+		{"Batch", "id"},
+		{"Imported", "imported"},
+		{"File", "file"},
+		{"Importer", "importer"},
+		{"Hash", "hash"},
+		{"Rows", "rows"},
+	}}
+	for _, batch := range batches {
+		hash := batch.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		table.Append(int(batch.Id), batch.Imported.Format("2006-01-02 15:04"), batch.File, batch.Importer, hash, batch.Rows)
+	}
+	return table
+}
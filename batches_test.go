@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportBatchRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batches")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	first := filepath.Join(dir, "first.csv")
+	fail(t, ioutil.WriteFile(first, []byte("DATE,DESCRIPTION,AMOUNT\n01/01/2018,rent,-1000\n01/02/2018,coffee,-3\n01/03/2018,books,-20\n01/04/2018,salary,2000\n"), 0600))
+	second := filepath.Join(dir, "second.csv")
+	fail(t, ioutil.WriteFile(second, []byte("DATE,DESCRIPTION,AMOUNT\n02/01/2018,rent,-1000\n"), 0600))
+
+	summaries, err := ImportFiles(pdb, []string{first, second}, "checking", "")
+	fail(t, err)
+	if summaries[0].Batch == nil || summaries[1].Batch == nil || summaries[0].Batch.Id == summaries[1].Batch.Id {
+		t.Fatalf("expected a batch per file, got %+v %+v", summaries[0].Batch, summaries[1].Batch)
+	}
+
+	batches, err := pdb.ImportBatches()
+	fail(t, err)
+	if len(batches) != 2 || batches[0].Rows != 4 || batches[0].Importer != "dcu" || len(batches[0].Hash) != 64 {
+		t.Fatalf("unexpected batches %+v", batches)
+	}
+
+	// Re-importing a file writes nothing, so no batch is recorded
+	summaries, err = ImportFiles(pdb, []string{first}, "checking", "")
+	fail(t, err)
+	if summaries[0].Batch != nil {
+		t.Fatalf("expected no batch for a file without new rows")
+	}
+
+	var rent, coffee, books, salary *Transaction
+	for _, tx := range pdb.AllTransactions() {
+		switch {
+		case tx.Memo == "rent" && tx.Date.Month() == 1:
+			rent = tx
+		case tx.Memo == "coffee":
+			coffee = tx
+		case tx.Memo == "books":
+			books = tx
+		case tx.Memo == "salary":
+			salary = tx
+		}
+	}
+
+	// Saving a transaction unchanged doesn't count as an edit
+	fail(t, pdb.Update([]*Transaction{salary}))
+	recategorized := *rent
+	recategorized.Category = "housing"
+	fail(t, pdb.Update([]*Transaction{&recategorized}))
+	fail(t, pdb.SaveTags(coffee.Id(), []string{"treat"}, nil))
+	fail(t, pdb.LoadCaches())
+
+	rollback, err := pdb.RollbackBatch(batches[0].Id)
+	fail(t, err)
+	if rollback.Transactions != 2 || len(rollback.Kept) != 2 {
+		t.Fatalf("expected 2 deleted and 2 kept, got %d deleted and %d kept", rollback.Transactions, len(rollback.Kept))
+	}
+
+	remaining := make(map[string]bool)
+	for _, tx := range pdb.AllTransactions() {
+		remaining[tx.Id()] = true
+	}
+	if len(remaining) != 3 || !remaining[rent.Id()] || !remaining[coffee.Id()] || remaining[books.Id()] || remaining[salary.Id()] {
+		t.Fatalf("unexpected transactions after rollback %v", pdb.AllTransactions())
+	}
+
+	// The batch stays while edited rows are left, the other one goes entirely
+	rollback, err = pdb.RollbackBatch(batches[1].Id)
+	fail(t, err)
+	if rollback.Transactions != 1 || len(rollback.Kept) != 0 {
+		t.Fatalf("unexpected rollback %+v", rollback)
+	}
+	batches, err = pdb.ImportBatches()
+	fail(t, err)
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 batch left, got %d", len(batches))
+	}
+
+	if _, err := pdb.RollbackBatch(42); err == nil {
+		t.Fatalf("expected an error for an unknown batch")
+	}
+}
+
+func TestImportBatchCountsInsertedRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batches")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	// A row written after the plan was made is skipped, and not counted
+	path := filepath.Join(dir, "export.csv")
+	fail(t, ioutil.WriteFile(path, []byte("DATE,DESCRIPTION,AMOUNT\n03/01/2018,rent,-1000\n03/02/2018,coffee,-3\n"), 0600))
+	plan, err := PlanImport(pdb, []string{path}, "checking", "")
+	fail(t, err)
+	fail(t, pdb.Insert([]*Transaction{plan.Rows[0].Transaction}))
+	fail(t, plan.Apply(pdb))
+
+	batches, err := pdb.ImportBatches()
+	fail(t, err)
+	if batch := plan.Summaries[0].Batch; batch == nil || batch.Rows != 1 || len(batches) != 1 || batches[0].Rows != 1 {
+		t.Fatalf("expected a batch of the 1 row inserted, got %+v", batches)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The parts of an ISO 20022 camt.053 (bank to customer statement) document
+// that are imported.  Elements are matched by local name so that any version
+// of the schema namespace is accepted.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtStatement struct {
+	Id       string        `xml:"Id"`
+	IBAN     string        `xml:"Acct>Id>IBAN"`
+	Other    string        `xml:"Acct>Id>Othr>Id"`
+	Currency string        `xml:"Acct>Ccy"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+// Versions before camt.053.001.08 give the status as text, later ones as a code
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"`
+}
+
+type camtBankCode struct {
+	Domain      string `xml:"Domn>Cd"`
+	Family      string `xml:"Domn>Fmly>Cd"`
+	SubFamily   string `xml:"Domn>Fmly>SubFmlyCd"`
+	Proprietary string `xml:"Prtry>Cd"`
+}
+
+type camtBalance struct {
+	Type        string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount      camtAmount `xml:"Amt"`
+	CreditDebit string     `xml:"CdtDbtInd"`
+	Date        camtDate   `xml:"Dt"`
+}
+
+type camtEntry struct {
+	Ref         string        `xml:"NtryRef"`
+	Amount      camtAmount    `xml:"Amt"`
+	CreditDebit string        `xml:"CdtDbtInd"`
+	Status      camtStatus    `xml:"Sts"`
+	BookingDate camtDate      `xml:"BookgDt"`
+	ValueDate   camtDate      `xml:"ValDt"`
+	ServicerRef string        `xml:"AcctSvcrRef"`
+	Code        camtBankCode  `xml:"BkTxCd"`
+	Info        string        `xml:"AddtlNtryInf"`
+	Details     []camtDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camtDetails struct {
+	Amount        camtAmount   `xml:"Amt"`
+	TxAmount      camtAmount   `xml:"AmtDtls>TxAmt>Amt"`
+	CreditDebit   string       `xml:"CdtDbtInd"`
+	ServicerRef   string       `xml:"Refs>AcctSvcrRef"`
+	Code          camtBankCode `xml:"BkTxCd"`
+	Creditor      string       `xml:"RltdPties>Cdtr>Nm"`
+	CreditorParty string       `xml:"RltdPties>Cdtr>Pty>Nm"`
+	Debtor        string       `xml:"RltdPties>Dbtr>Nm"`
+	DebtorParty   string       `xml:"RltdPties>Dbtr>Pty>Nm"`
+	Unstructured  []string     `xml:"RmtInf>Ustrd"`
+	Reference     string       `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+	Info          string       `xml:"AddtlTxInf"`
+}
+
+func (date camtDate) Parse() (time.Time, error) {
+	value := strings.TrimSpace(date.Date)
+	if len(value) == 0 {
+		value = strings.TrimSpace(date.DateTime)
+	}
+	if len(value) < 10 {
+		return time.Time{}, fmt.Errorf("invalid camt.053 date %q", value)
+	}
+	return time.Parse("2006-01-02", value[:10])
+}
+
+func (status camtStatus) String() string {
+	return strings.TrimSpace(defaultString(status.Code, status.Value))
+}
+
+// Bank transaction codes become tags like btc:pmnt-rcdt-esct
+func (code camtBankCode) Tag() string {
+	var parts []string
+	for _, part := range []string{code.Domain, code.Family, code.SubFamily} {
+		if part = strings.TrimSpace(part); len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		parts = strings.Fields(code.Proprietary)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "btc:" + strings.ToLower(strings.Join(parts, "-"))
+}
+
+// Amounts are always positive, with the direction given by the credit/debit
+// indicator
+func parseCamtAmount(amount camtAmount, creditDebit string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(amount.Value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid camt.053 amount %q", amount.Value)
+	}
+	if strings.TrimSpace(creditDebit) == "DBIT" {
+		value = -value
+	}
+	return value, nil
+}
+
+// CamtImporter reads ISO 20022 camt.053 statements, as exported by European
+// banks.  Debits are negative, reversals included (a reversed debit is booked
+// as a credit), and pending entries are skipped.  The memo is the counterparty
+// followed by the remittance information, and bank transaction codes are
+// added as tags.  The statement's opening and closing balances are recorded
+// so that the imported rows can be reconciled against them.
+type CamtImporter struct {
+	// Date transactions by value date instead of booking date
+	ValueDate bool
+}
+
+func (camt CamtImporter) Name() string {
+	if camt.ValueDate {
+		return "camt053-value"
+	}
+	return "camt053"
+}
+
+// Only the booking date importer is detected, the value date one has to be
+// asked for with --format
+func (camt CamtImporter) Detect(filename string, contents []byte) int {
+	if camt.ValueDate || strings.ToLower(filepath.Ext(filename)) != ".xml" {
+		return 0
+	}
+	if bytes.Contains(contents, []byte("camt.053")) || bytes.Contains(contents, []byte("BkToCstmrStmt")) {
+		return 10
+	}
+	return 0
+}
+
+func (camt CamtImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	var document camtDocument
+	err := xml.Unmarshal(contents, &document)
+	if err != nil {
+		return err
+	}
+	if len(document.Statements) == 0 {
+		return fmt.Errorf("no camt.053 statements found")
+	}
+
+	for _, statement := range document.Statements {
+		account := strings.TrimSpace(defaultString(statement.IBAN, statement.Other))
+		statementSource := defaultString(source, account)
+
+		err = camt.importBalances(statement, statementSource, importer)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range statement.Entries {
+			err = camt.importEntry(entry, statementSource, account, statement.Currency, importer)
+			if err != nil {
+				if importer.SkipInvalid {
+					importer.Rejected = append(importer.Rejected, fmt.Errorf("%s entry %s: %v", account, defaultString(entry.ServicerRef, entry.Ref), err))
+					continue
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The opening balance is the balance at the start of its date (OPBD) or the
+// previous closing balance at the end of its date (PRCD), and the closing
+// balance (CLBD) is the balance at the end of its date
+func (CamtImporter) importBalances(statement camtStatement, source string, importer *TransactionImporter) error {
+	var opening *Balance
+	for _, balance := range statement.Balances {
+		date, err := balance.Date.Parse()
+		if err != nil {
+			return err
+		}
+		amount, err := parseCamtAmount(balance.Amount, balance.CreditDebit)
+		if err != nil {
+			return err
+		}
+
+		switch strings.TrimSpace(balance.Type) {
+		case "OPBD":
+			opening = &Balance{source, date, amount, BalanceOpening}
+		case "PRCD":
+			if opening == nil {
+				opening = &Balance{source, date.AddDate(0, 0, 1), amount, BalanceOpening}
+			}
+		case "CLBD":
+			importer.AddBalance(&Balance{source, date, amount, BalanceAssertion})
+		}
+	}
+	if opening != nil {
+		importer.AddBalance(opening)
+	}
+	return nil
+}
+
+// Batch bookings with several transaction details are split into one
+// transaction per detail
+func (camt CamtImporter) importEntry(entry camtEntry, source, account, currency string, importer *TransactionImporter) error {
+	if status := entry.Status.String(); status == "PDNG" || status == "INFO" {
+		return nil
+	}
+
+	dates := []camtDate{entry.BookingDate, entry.ValueDate}
+	if camt.ValueDate {
+		dates[0], dates[1] = dates[1], dates[0]
+	}
+	date, err := dates[0].Parse()
+	if err != nil {
+		date, err = dates[1].Parse()
+		if err != nil {
+			return err
+		}
+	}
+
+	details := entry.Details
+	if len(details) < 2 {
+		var detail camtDetails
+		if len(details) == 1 {
+			detail = details[0]
+		}
+		detail.Amount, detail.TxAmount, detail.CreditDebit = entry.Amount, camtAmount{}, entry.CreditDebit
+		details = []camtDetails{detail}
+	}
+
+	for index, detail := range details {
+		amount := detail.Amount
+		if len(strings.TrimSpace(amount.Value)) == 0 {
+			amount = detail.TxAmount
+		}
+		creditDebit := defaultString(detail.CreditDebit, entry.CreditDebit)
+		value, err := parseCamtAmount(amount, creditDebit)
+		if err != nil {
+			return err
+		}
+
+		counterparty := defaultString(detail.Creditor, detail.CreditorParty)
+		if creditDebit == "CRDT" {
+			counterparty = defaultString(detail.Debtor, detail.DebtorParty)
+		}
+		remittance := strings.Join(detail.Unstructured, " ")
+		for _, fallback := range []string{detail.Reference, detail.Info, entry.Info} {
+			if len(strings.TrimSpace(remittance)) == 0 {
+				remittance = fallback
+			}
+		}
+		memo := strings.Join(strings.Fields(counterparty+" "+remittance), " ")
+
+		code := detail.Code.Tag()
+		if len(code) == 0 {
+			code = entry.Code.Tag()
+		}
+		if len(memo) == 0 {
+			memo = code
+		}
+
+		var disambiguation string
+		if len(details) > 1 {
+			disambiguation = fmt.Sprintf("d%d", index+1)
+		}
+
+		tx := &Transaction{source, date, memo, value, disambiguation, defaultString(amount.Currency, currency), "", false}
+		ref := strings.TrimSpace(defaultString(entry.ServicerRef, entry.Ref))
+		if len(details) > 1 {
+			if len(ref) > 0 {
+				ref = fmt.Sprintf("%s/%d", ref, index+1)
+			}
+			ref = strings.TrimSpace(defaultString(detail.ServicerRef, ref))
+		}
+		if len(ref) > 0 && len(account) > 0 {
+			importer.AddExternal(tx, account+":"+ref)
+		} else {
+			importer.Add(tx)
+		}
+		if len(code) > 0 {
+			importer.AddTags(tx, code)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterImporter(CamtImporter{})
+	RegisterImporter(CamtImporter{ValueDate: true})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+const camtSample = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <GrpHdr><MsgId>MSG1</MsgId><CreDtTm>2021-02-01T06:00:00</CreDtTm></GrpHdr>
+    <Stmt>
+      <Id>STMT-2021-01</Id>
+      <Acct><Id><IBAN>DE89370400440532013000</IBAN></Id><Ccy>EUR</Ccy></Acct>
+      <Bal>
+        <Tp><CdOrPrtry><Cd>OPBD</Cd></CdOrPrtry></Tp>
+        <Amt Ccy="EUR">1000.00</Amt><CdtDbtInd>CRDT</CdtDbtInd>
+        <Dt><Dt>2021-01-01</Dt></Dt>
+      </Bal>
+      <Bal>
+        <Tp><CdOrPrtry><Cd>CLBD</Cd></CdOrPrtry></Tp>
+        <Amt Ccy="EUR">3474.50</Amt><CdtDbtInd>CRDT</CdtDbtInd>
+        <Dt><Dt>2021-01-31</Dt></Dt>
+      </Bal>
+      <Ntry>
+        <Amt Ccy="EUR">2500.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><Sts>BOOK</Sts>
+        <BookgDt><Dt>2021-01-04</Dt></BookgDt><ValDt><Dt>2021-01-02</Dt></ValDt>
+        <AcctSvcrRef>REF-1</AcctSvcrRef>
+        <BkTxCd><Domn><Cd>PMNT</Cd><Fmly><Cd>RCDT</Cd><SubFmlyCd>ESCT</SubFmlyCd></Fmly></Domn></BkTxCd>
+        <NtryDtls><TxDtls>
+          <RltdPties><Dbtr><Nm>ACME GmbH</Nm></Dbtr></RltdPties>
+          <RmtInf><Ustrd>Gehalt Januar</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">45.50</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts>BOOK</Sts>
+        <BookgDt><Dt>2021-01-10</Dt></BookgDt><ValDt><Dt>2021-01-09</Dt></ValDt>
+        <AcctSvcrRef>REF-2</AcctSvcrRef>
+        <BkTxCd><Prtry><Cd>CARD PAYMENT</Cd></Prtry></BkTxCd>
+        <NtryDtls><TxDtls>
+          <RltdPties><Cdtr><Nm>Supermarkt</Nm></Cdtr></RltdPties>
+          <RmtInf><Strd><CdtrRefInf><Ref>RF18 5390 0754 7034</Ref></CdtrRefInf></Strd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">20.00</Amt><CdtDbtInd>CRDT</CdtDbtInd><RvslInd>true</RvslInd><Sts>BOOK</Sts>
+        <BookgDt><Dt>2021-01-12</Dt></BookgDt>
+        <AcctSvcrRef>REF-3</AcctSvcrRef>
+        <AddtlNtryInf>Returned direct debit</AddtlNtryInf>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">99.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><Sts>PDNG</Sts>
+        <BookgDt><Dt>2021-01-30</Dt></BookgDt>
+        <AcctSvcrRef>REF-4</AcctSvcrRef>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>
+`
+
+func TestCamtImporter(t *testing.T) {
+	if (CamtImporter{}).Detect("statement.xml", []byte(camtSample)) == 0 {
+		t.Fatalf("expected a camt.053 statement to be detected")
+	}
+	if (CamtImporter{ValueDate: true}).Detect("statement.xml", []byte(camtSample)) != 0 {
+		t.Fatalf("expected the value date importer to only be used when asked for")
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, CamtImporter{}.Import("", []byte(camtSample), importer))
+
+	txs := importer.All()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Date.Before(txs[j].Date) })
+	if len(txs) != 3 {
+		t.Fatalf("expected the pending entry to be skipped, got %v", txs)
+	}
+
+	salary, groceries, reversal := txs[0], txs[1], txs[2]
+	if salary.Source != "DE89370400440532013000" || salary.Amount != 2500 || salary.Memo != "ACME GmbH Gehalt Januar" || salary.Currency != "EUR" {
+		t.Fatalf("unexpected credit %v", salary)
+	}
+	if salary.Date.Format("2006-01-02") != "2021-01-04" {
+		t.Fatalf("expected the booking date, got %s", salary.Date.Format("2006-01-02"))
+	}
+	if groceries.Amount != -45.50 || groceries.Memo != "Supermarkt RF18 5390 0754 7034" {
+		t.Fatalf("unexpected debit %v", groceries)
+	}
+	if reversal.Amount != 20 || reversal.Memo != "Returned direct debit" {
+		t.Fatalf("unexpected reversal %v", reversal)
+	}
+
+	if tags := importer.tags[salary.Id()]; len(tags) != 1 || tags[0] != "btc:pmnt-rcdt-esct" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	if tags := importer.tags[groceries.Id()]; len(tags) != 1 || tags[0] != "btc:card-payment" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	if id := importer.txExternalIds[groceries.Id()]; id != "DE89370400440532013000:REF-2" {
+		t.Fatalf("unexpected external ID %q", id)
+	}
+
+	if len(importer.balances) != 2 {
+		t.Fatalf("expected an opening and a closing balance, got %v", importer.balances)
+	}
+	for _, balance := range importer.balances {
+		switch balance.Kind {
+		case BalanceOpening:
+			if balance.Balance != 1000 || balance.Date.Format("2006-01-02") != "2021-01-01" {
+				t.Fatalf("unexpected opening balance %v", balance)
+			}
+		case BalanceAssertion:
+			if balance.Balance != 3474.50 || balance.Date.Format("2006-01-02") != "2021-01-31" {
+				t.Fatalf("unexpected closing balance %v", balance)
+			}
+		}
+	}
+
+	importer = NewTransactionImporter()
+	fail(t, CamtImporter{ValueDate: true}.Import("giro", []byte(camtSample), importer))
+	for _, tx := range importer.All() {
+		if tx.Amount == 2500 && tx.Date.Format("2006-01-02") != "2021-01-02" {
+			t.Fatalf("expected the value date, got %s", tx.Date.Format("2006-01-02"))
+		}
+		if tx.Amount == 20 && tx.Date.Format("2006-01-02") != "2021-01-12" {
+			t.Fatalf("expected the booking date without a value date, got %s", tx.Date.Format("2006-01-02"))
+		}
+		if tx.Source != "giro" {
+			t.Fatalf("expected the given source, got %s", tx.Source)
+		}
+	}
+}
+
+func TestCamtImportReconciles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camt")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	path := filepath.Join(dir, "statement.xml")
+	fail(t, ioutil.WriteFile(path, []byte(camtSample), 0600))
+
+	summaries, err := ImportFiles(pdb, []string{path}, "giro", "")
+	fail(t, err)
+	if summaries[0].Importer != "camt053" || summaries[0].New != 3 {
+		t.Fatalf("unexpected summary %+v", summaries[0])
+	}
+	fail(t, pdb.LoadCaches())
+
+	balances, err := pdb.Balances()
+	fail(t, err)
+	if len(balances) != 2 {
+		t.Fatalf("expected the statement balances to be saved, got %v", balances)
+	}
+	for _, balance := range balances {
+		if balance.Kind != BalanceAssertion {
+			continue
+		}
+		r := Reconcile("giro", balance.Date, balance.Balance, pdb.AllTransactions(), balances)
+		if math.Abs(r.Discrepancy()) > 0.001 {
+			t.Fatalf("expected the imported rows to match the closing balance, off by %.2f", r.Discrepancy())
+		}
+	}
+	if tags := pdb.tagCache[pdb.AllTransactions()[0].Id()]; len(tags) != 1 {
+		t.Fatalf("expected the bank transaction code to be tagged, got %v", tags)
+	}
+
+	// Rolling back removes the statement balances and imported tags too
+	rollback, err := pdb.RollbackBatch(summaries[0].Batch.Id)
+	fail(t, err)
+	if rollback.Transactions != 3 || len(rollback.Kept) != 0 {
+		t.Fatalf("unexpected rollback %+v", rollback)
+	}
+	balances, err = pdb.Balances()
+	fail(t, err)
+	if len(balances) != 0 || len(pdb.tagCache) != 0 {
+		t.Fatalf("expected no balances or tags left, got %v %v", balances, pdb.tagCache)
+	}
+}
+
+func TestCamtImportRenamesBalances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camt")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { importRenames = &ImportRenames{} }()
+	importRenames = &ImportRenames{Accounts: map[string]string{"DE89370400440532013000": "giro"}}
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	path := filepath.Join(dir, "statement.xml")
+	fail(t, ioutil.WriteFile(path, []byte(camtSample), 0600))
+	_, err = ImportFiles(pdb, []string{path}, "", "")
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	balances, err := pdb.Balances()
+	fail(t, err)
+	if len(balances) != 2 {
+		t.Fatalf("expected the statement balances to be saved, got %v", balances)
+	}
+	for _, balance := range balances {
+		if balance.Source != "giro" {
+			t.Fatalf("expected the balance to be renamed like its transactions, got %v", balance)
+		}
+	}
+	for _, tx := range pdb.AllTransactions() {
+		if tx.Source != "giro" {
+			t.Fatalf("expected the transaction to be renamed, got %v", tx)
+		}
+	}
+}
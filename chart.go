@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChartStyle holds the characters charts are drawn with
+type ChartStyle struct {
+	Blocks   []string // partial bar widths from 1/n to a full cell
+	Spark    []string // sparkline levels, lowest first
+	Segments []string // fill of each stacked bar segment
+}
+
+var UnicodeChartStyle = ChartStyle{
+	Blocks:   []string{"▏", "▎", "▍", "▌", "▋", "▊", "▉", "█"},
+	Spark:    []string{"▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"},
+	Segments: []string{"█", "▓", "▒", "░"},
+}
+
+var ASCIIChartStyle = ChartStyle{
+	Blocks:   []string{"#"},
+	Spark:    []string{"_", ".", "-", "~", "=", "*", "#"},
+	Segments: []string{"#", "=", "*", "+", "%", "o", "@", "x"},
+}
+
+// Unicode is assumed when the locale says UTF-8
+func unicodeSupported() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := os.Getenv(name); len(value) > 0 {
+			value = strings.ToLower(value)
+			return strings.Contains(value, "utf-8") || strings.Contains(value, "utf8")
+		}
+	}
+	return false
+}
+
+var chartColors = []int{4, 2, 3, 5, 6, 1, 12, 10, 11, 13}
+
+func chartColor(index int, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return fmt.Sprintf("\033[38;5;%dm%s\033[0m", chartColors[index%len(chartColors)], s)
+}
+
+// A horizontal bar `width` cells long at max.  Negative values are drawn by
+// their size.
+func (style ChartStyle) Bar(value, max float64, width int) string {
+	if max <= 0 || value == 0 {
+		return ""
+	}
+	steps := len(style.Blocks)
+	units := int(math.Round(math.Abs(value) / max * float64(width*steps)))
+	bar := strings.Repeat(style.Blocks[steps-1], units/steps)
+	if units%steps > 0 {
+		bar += style.Blocks[units%steps-1]
+	}
+	return bar
+}
+
+func (style ChartStyle) Sparkline(values []float64) string {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, value := range values {
+		min, max = math.Min(min, value), math.Max(max, value)
+	}
+
+	var spark strings.Builder
+	for _, value := range values {
+		level := 0
+		if max > min {
+			level = int(math.Round((value - min) / (max - min) * float64(len(style.Spark)-1)))
+		}
+		spark.WriteString(style.Spark[level])
+	}
+	return spark.String()
+}
+
+// One bar made of a segment per value, each in its own fill and color
+func (style ChartStyle) StackedBar(values []float64, max float64, width int) string {
+	var bar strings.Builder
+	drawn, cumulative := 0, 0.0
+	for index, value := range values {
+		cumulative += math.Abs(value)
+		end := int(math.Round(cumulative / max * float64(width)))
+		if end > drawn {
+			fill := style.Segments[index%len(style.Segments)]
+			bar.WriteString(chartColor(index, strings.Repeat(fill, end-drawn)))
+			drawn = end
+		}
+	}
+	return bar.String()
+}
+
+type BarChart struct {
+	Title  string
+	Labels []string
+	Values []float64
+	Format func(float64) string
+	Trend  bool // draw a sparkline under the bars
+}
+
+func (chart *BarChart) Write(writer io.Writer, style ChartStyle, width int) {
+	fmt.Fprintf(writer, "%s\n\n", strings.ToUpper(chart.Title))
+
+	labelWidth, max := 0, 0.0
+	for index, label := range chart.Labels {
+		labelWidth = int(math.Max(float64(labelWidth), float64(utf8.RuneCountInString(label))))
+		max = math.Max(max, math.Abs(chart.Values[index]))
+	}
+
+	for index, label := range chart.Labels {
+		padding := strings.Repeat(" ", labelWidth-utf8.RuneCountInString(label))
+		bar := style.Bar(chart.Values[index], max, width)
+		fmt.Fprintf(writer, "%s%s %s %s\n", label, padding, chartColor(0, bar), chart.Format(chart.Values[index]))
+	}
+
+	if chart.Trend && len(chart.Values) > 1 {
+		fmt.Fprintf(writer, "\n%s %s\n", strings.Repeat(" ", labelWidth), style.Sparkline(chart.Values))
+	}
+}
+
+func (chart *BarChart) Table(name string) *Table {
+	table := &Table{Name: name, Columns: []Column{{"Label", "label"}, {"Value", "value"}}}
+	for index, label := range chart.Labels {
+		table.Append(label, chart.Values[index])
+	}
+	return table
+}
+
+// StackedBarChart draws one bar per label split into series, e.g. a month's
+// expenses split by category
+type StackedBarChart struct {
+	Title  string
+	Labels []string
+	Series []string
+	Values [][]float64 // one row per label, one column per series
+	Format func(float64) string
+}
+
+func (chart *StackedBarChart) Write(writer io.Writer, style ChartStyle, width int) {
+	fmt.Fprintf(writer, "%s\n\n", strings.ToUpper(chart.Title))
+
+	labelWidth, max := 0, 0.0
+	totals := make([]float64, len(chart.Labels))
+	for index, label := range chart.Labels {
+		labelWidth = int(math.Max(float64(labelWidth), float64(utf8.RuneCountInString(label))))
+		for _, value := range chart.Values[index] {
+			totals[index] += math.Abs(value)
+		}
+		max = math.Max(max, totals[index])
+	}
+
+	for index, label := range chart.Labels {
+		padding := strings.Repeat(" ", labelWidth-utf8.RuneCountInString(label))
+		bar := ""
+		if max > 0 {
+			bar = style.StackedBar(chart.Values[index], max, width)
+		}
+		fmt.Fprintf(writer, "%s%s %s %s\n", label, padding, bar, chart.Format(totals[index]))
+	}
+
+	io.WriteString(writer, "\n")
+	for index, series := range chart.Series {
+		fill := style.Segments[index%len(style.Segments)]
+		fmt.Fprintf(writer, "%s %s  ", chartColor(index, fill+fill), series)
+	}
+	io.WriteString(writer, "\n")
+}
+
+func (chart *StackedBarChart) Table(name string) *Table {
+	table := &Table{Name: name, Columns: []Column{{"Label", "label"}}}
+	for _, series := range chart.Series {
+		table.Columns = append(table.Columns, Column{series, jsonKey(series)})
+	}
+	for index, label := range chart.Labels {
+		row := []interface{}{label}
+		for _, value := range chart.Values[index] {
+			row = append(row, value)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// Monthly (or other period) expenses split into the largest expense
+// categories, with the rest combined into "other".  Categories the
+// classifier treats as income, transfers or investments are left out.
+func ExpenseChart(pivot *Pivot, classifier *Classifier, categories int, base string) *StackedBarChart {
+	chart := &StackedBarChart{Title: "expenses by category", Format: func(v float64) string { return moneyIn(v, base, false) }}
+
+	// Pivot categories are already ordered by the size of their total
+	var expenseCategories []string
+	for _, category := range pivot.Categories {
+		kind := classifier.Classify(&Transaction{Category: category, Amount: -1})
+		if kind == KindExpense && pivot.RowTotal(category) < 0 {
+			expenseCategories = append(expenseCategories, category)
+		}
+	}
+
+	shown := expenseCategories
+	if len(shown) > categories {
+		shown = shown[:categories]
+	}
+	for _, category := range shown {
+		chart.Series = append(chart.Series, categoryName(category))
+	}
+	if len(expenseCategories) > len(shown) {
+		chart.Series = append(chart.Series, "other")
+	}
+
+	for column, period := range pivot.Periods {
+		chart.Labels = append(chart.Labels, period.Label())
+		row := make([]float64, len(chart.Series))
+		for index, category := range expenseCategories {
+			amount := pivot.Totals[category][column]
+			if amount > 0 {
+				continue // a month with net refunds
+			}
+			if index < len(shown) {
+				row[index] = -amount
+			} else {
+				row[len(row)-1] -= amount
+			}
+		}
+		chart.Values = append(chart.Values, row)
+	}
+	return chart
+}
+
+func SavingsRateChart(periods Periods) *BarChart {
+	chart := &BarChart{Title: "savings rate", Format: func(v float64) string { return fmt.Sprintf("%.1f%%", v) }, Trend: true}
+	for _, period := range periods {
+		chart.Labels = append(chart.Labels, period.Label())
+		chart.Values = append(chart.Values, period.SavingsRate())
+	}
+	return chart
+}
+
+func CategoryChart(summaries []CategorySummary, base string) *BarChart {
+	chart := &BarChart{Title: "categories", Format: func(v float64) string { return moneyIn(v, base, false) }}
+	for _, summary := range summaries {
+		chart.Labels = append(chart.Labels, categoryName(summary.Category))
+		chart.Values = append(chart.Values, summary.Total)
+	}
+	return chart
+}
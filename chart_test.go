@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChartStyles(t *testing.T) {
+	if bar := UnicodeChartStyle.Bar(-50, 100, 10); bar != "█████" {
+		t.Fatalf("unexpected bar %q", bar)
+	}
+	if bar := UnicodeChartStyle.Bar(55, 100, 10); bar != "█████▌" {
+		t.Fatalf("unexpected partial bar %q", bar)
+	}
+	if bar := ASCIIChartStyle.Bar(55, 100, 10); bar != "######" {
+		t.Fatalf("unexpected ascii bar %q", bar)
+	}
+	if spark := UnicodeChartStyle.Sparkline([]float64{0, 50, 100}); spark != "▁▅█" {
+		t.Fatalf("unexpected sparkline %q", spark)
+	}
+	if spark := ASCIIChartStyle.Sparkline([]float64{1, 1}); spark != "__" {
+		t.Fatalf("unexpected flat sparkline %q", spark)
+	}
+}
+
+func TestExpenseChart(t *testing.T) {
+	defer func(enabled bool) { colorEnabled = enabled }(colorEnabled)
+	colorEnabled = false
+
+	slice := &TxSlice{[]*Transaction{
+		{"dcu", date("Jan 2 2018"), "PAYROLL", 1000, "", "USD", "income", false},
+		{"dcu", date("Jan 3 2018"), "GROCERY", -60, "", "USD", "food", false},
+		{"dcu", date("Jan 9 2018"), "RESTAURANT", -40, "", "USD", "dining", false},
+		{"dcu", date("Feb 3 2018"), "CARD PAYMENT", -500, "", "USD", "payoff", false},
+		{"dcu", date("Feb 9 2018"), "GYM", -20, "", "USD", "fitness", false},
+	}, nil}
+
+	chart := ExpenseChart(slice.Pivot(GranularityMonth, time.January), slice.Classifier(), 2, "USD")
+	if strings.Join(chart.Series, ",") != "food,dining,other" {
+		t.Fatalf("expecting income and payoff to be excluded, got %v", chart.Series)
+	}
+	if chart.Values[0][0] != 60 || chart.Values[1][2] != 20 {
+		t.Fatalf("unexpected values %v", chart.Values)
+	}
+
+	var buf bytes.Buffer
+	chart.Write(&buf, ASCIIChartStyle, 10)
+	if !strings.Contains(buf.String(), "2018-01 ######==== $100.00") {
+		t.Fatalf("unexpected chart:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind describes how a transaction contributes to income, expense and savings totals
+type Kind string
+
+const (
+	KindIncome     Kind = "income"
+	KindExpense    Kind = "expense"
+	KindTransfer   Kind = "transfer"
+	KindInvestment Kind = "investment"
+	KindExcluded   Kind = "excluded"
+)
+
+var kinds = []Kind{KindIncome, KindExpense, KindTransfer, KindInvestment, KindExcluded}
+
+func ParseKind(s string) (Kind, error) {
+	for _, kind := range kinds {
+		if string(kind) == strings.ToLower(s) {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("invalid kind %s (expecting one of income, expense, transfer, investment, excluded)", s)
+}
+
+// A ClassificationRule assigns a Kind to every transaction whose category
+// equals Pattern (Field == "category") or whose memo contains Pattern
+// (Field == "memo")
+type ClassificationRule struct {
+	Field   string
+	Pattern string
+	Kind    Kind
+}
+
+func (rule ClassificationRule) Matches(tx *Transaction) bool {
+	switch rule.Field {
+	case "category":
+		return tx.Category == rule.Pattern || (rule.Pattern == "uncategorized" && len(tx.Category) == 0)
+	case "memo":
+		return strings.Contains(tx.Memo, rule.Pattern)
+	}
+	return false
+}
+
+func DefaultClassificationRules() []ClassificationRule {
+	return []ClassificationRule{
+		{"memo", "VANGUARD BUY", KindInvestment},
+		{"category", "income", KindIncome},
+		{"category", "payoff", KindTransfer},
+	}
+}
+
+type Classifier struct {
+	rules []ClassificationRule
+}
+
+// Memo rules are more specific than category rules, so they're evaluated first
+func NewClassifier(rules []ClassificationRule) *Classifier {
+	var ordered []ClassificationRule
+	for _, field := range []string{"memo", "category"} {
+		for _, rule := range rules {
+			if rule.Field == field {
+				ordered = append(ordered, rule)
+			}
+		}
+	}
+	return &Classifier{ordered}
+}
+
+func (classifier *Classifier) Rules() []ClassificationRule {
+	return classifier.rules
+}
+
+// Ignored transactions are excluded from all totals, except for investment
+// contributions which are commonly ignored so they don't count as expenses
+func (classifier *Classifier) Classify(tx *Transaction) Kind {
+	for _, rule := range classifier.rules {
+		if rule.Matches(tx) {
+			if tx.Ignored && rule.Kind != KindInvestment {
+				return KindExcluded
+			}
+			return rule.Kind
+		}
+	}
+	if tx.Ignored {
+		return KindExcluded
+	}
+	return KindExpense
+}
+
+type Totals struct {
+	Income      float64
+	Expenses    float64
+	Investments float64
+	Transfers   float64
+}
+
+func (totals Totals) SavingsRate() float64 {
+	if totals.Income == 0 {
+		return 0
+	}
+	return (1 - (-(totals.Expenses / totals.Income))) * 100
+}
+
+func (classifier *Classifier) Totals(transactions []*Transaction) Totals {
+	var totals Totals
+	for _, tx := range transactions {
+		switch classifier.Classify(tx) {
+		case KindIncome:
+			totals.Income += tx.Amount
+		case KindExpense:
+			totals.Expenses += tx.Amount
+		case KindInvestment:
+			totals.Investments += -tx.Amount
+		case KindTransfer:
+			totals.Transfers += tx.Amount
+		}
+	}
+	return totals
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestClassifier(t *testing.T) {
+	classifier := NewClassifier(append(DefaultClassificationRules(), ClassificationRule{"category", "brokerage", KindInvestment}))
+
+	tests := []struct {
+		tx   Transaction
+		kind Kind
+	}{
+		{Transaction{"dcu", date("Jan 1 2018"), "PAYROLL", 1000, "", "USD", "income", false}, KindIncome},
+		{Transaction{"dcu", date("Jan 1 2018"), "GROCERY", -50, "", "USD", "food", false}, KindExpense},
+		{Transaction{"dcu", date("Jan 1 2018"), "GROCERY", -50, "", "USD", "", false}, KindExpense},
+		{Transaction{"dcu", date("Jan 1 2018"), "GROCERY", -50, "", "USD", "food", true}, KindExcluded},
+		{Transaction{"dcu", date("Jan 1 2018"), "CARD PAYMENT", -200, "", "USD", "payoff", false}, KindTransfer},
+		{Transaction{"dcu", date("Jan 1 2018"), "VANGUARD BUY", -500, "", "USD", "", true}, KindInvestment},
+		{Transaction{"dcu", date("Jan 1 2018"), "VANGUARD BUY", -500, "", "USD", "income", false}, KindInvestment},
+		{Transaction{"dcu", date("Jan 1 2018"), "FIDELITY", -500, "", "USD", "brokerage", false}, KindInvestment},
+	}
+
+	for _, tc := range tests {
+		if kind := classifier.Classify(&tc.tx); kind != tc.kind {
+			t.Errorf("expecting %v to be classified as %s, got %s", &tc.tx, tc.kind, kind)
+		}
+	}
+
+	var txs []*Transaction
+	for index := range tests {
+		txs = append(txs, &tests[index].tx)
+	}
+
+	totals := classifier.Totals(txs)
+	if totals.Income != 1000 || totals.Expenses != -100 || totals.Investments != 1500 || totals.Transfers != -200 {
+		t.Fatalf("unexpected totals %+v", totals)
+	}
+}
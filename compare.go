@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Moves a date range back (or forward) by whole years, keeping month ends
+// aligned, e.g. 02/29/2024 becomes 02/28/2023
+func ShiftYears(r DateRange, years int) DateRange {
+	return DateRange{
+		r.Start.AddDate(years, 0, 0),
+		r.End.AddDate(0, 0, 1).AddDate(years, 0, -1),
+	}
+}
+
+// A short name for a date range: 2021, Q1 2021 or 2021-03 when the range is
+// exactly that calendar period, otherwise the start and end dates
+func rangeLabel(r DateRange) string {
+	for _, granularity := range []Granularity{GranularityYear, GranularityQuarter, GranularityMonth} {
+		period := PeriodContaining(granularity, time.January, r.Start)
+		if period.Start.Equal(r.Start) && period.End.Equal(r.End) {
+			return period.Label()
+		}
+	}
+	if r.SingleDay() {
+		return r.Start.Format("01/02/2006")
+	}
+	return fmt.Sprintf("%s..%s", r.Start.Format("01/02/2006"), r.End.Format("01/02/2006"))
+}
+
+type CategoryComparison struct {
+	Category string
+	Totals   []float64 // one per period, scaled when normalized
+	Change   float64   // latest period minus the one before it
+}
+
+// Change relative to the previous period, or NaN if the category is new
+func (c CategoryComparison) PercentChange() float64 {
+	previous := c.Totals[len(c.Totals)-2]
+	if previous == 0 {
+		return math.NaN()
+	}
+	return c.Change / math.Abs(previous) * 100
+}
+
+// Comparison holds category totals for two or more periods in chronological
+// order.  When normalized, every period's totals are scaled by the ratio of
+// the latest period's ElapsedDays to its own so that a partial year can be
+// compared with a full one.
+type Comparison struct {
+	Labels     []string
+	Slices     []*TxSlice
+	Scales     []float64
+	Categories []CategoryComparison
+}
+
+func NewComparison(labels []string, slices []*TxSlice, normalize bool) *Comparison {
+	comparison := &Comparison{Labels: labels, Slices: slices}
+
+	latestDays := slices[len(slices)-1].ElapsedDays()
+	for _, slice := range slices {
+		scale := 1.0
+		if normalize && slice.ElapsedDays() > 0 && latestDays > 0 {
+			scale = latestDays / slice.ElapsedDays()
+		}
+		comparison.Scales = append(comparison.Scales, scale)
+	}
+
+	byCategory := make(map[string]*CategoryComparison)
+	var categories []string
+	for index, slice := range slices {
+		for _, summary := range slice.CategorySummaries() {
+			c, ok := byCategory[summary.Category]
+			if !ok {
+				c = &CategoryComparison{summary.Category, make([]float64, len(slices)), 0}
+				byCategory[summary.Category] = c
+				categories = append(categories, summary.Category)
+			}
+			c.Totals[index] = summary.Total * comparison.Scales[index]
+		}
+	}
+
+	for _, category := range categories {
+		c := byCategory[category]
+		c.Change = c.Totals[len(c.Totals)-1] - c.Totals[len(c.Totals)-2]
+		comparison.Categories = append(comparison.Categories, *c)
+	}
+
+	// Biggest movers first
+	sort.SliceStable(comparison.Categories, func(i, j int) bool {
+		return math.Abs(comparison.Categories[i].Change) > math.Abs(comparison.Categories[j].Change)
+	})
+
+	return comparison
+}
+
+func (comparison *Comparison) Tables(base string, movers int) []*Table {
+	periods := &Table{Name: "periods", Columns: []Column{
+		{"Period", "period"},
+		{"First Transaction", "first_transaction"},
+		{"Last Transaction", "last_transaction"},
+		{"Transactions", "transactions"},
+		{"Elapsed Days", "elapsed_days"},
+		{"Scale", "scale"},
+	}}
+	for index, slice := range comparison.Slices {
+		var first, last interface{}
+		if len(slice.transactions) > 0 {
+			first, last = Date(slice.Start()), Date(slice.End())
+		}
+		periods.Append(comparison.Labels[index], first, last, len(slice.transactions), int(slice.ElapsedDays()), comparison.Scales[index])
+	}
+
+	categories := &Table{Name: "categories", Title: "category comparison", Columns: []Column{{"Category", "category"}}}
+	for _, label := range comparison.Labels {
+		categories.Columns = append(categories.Columns, Column{label, jsonKey(label)})
+	}
+	categories.Columns = append(categories.Columns,
+		Column{"Change", "change"},
+		Column{"% Change", "percent_change"},
+		Column{"Mover", "mover"})
+
+	for index, c := range comparison.Categories {
+		row := []interface{}{categoryName(c.Category)}
+		for _, total := range c.Totals {
+			row = append(row, Money{total, base})
+		}
+
+		var percent interface{}
+		if p := c.PercentChange(); !math.IsNaN(p) {
+			percent = Percent(p)
+		}
+
+		// More or less refers to the size of the total, while the color
+		// shows whether the change helps (green) or hurts (red) cash flow
+		mover := ""
+		if index < movers && c.Change != 0 {
+			latest, previous := c.Totals[len(c.Totals)-1], c.Totals[len(c.Totals)-2]
+			mover = "▼ less"
+			if math.Abs(latest) > math.Abs(previous) {
+				mover = "▲ more"
+			}
+			if c.Change < 0 {
+				mover = red(mover)
+			} else {
+				mover = green(mover)
+			}
+		}
+
+		row = append(row, Money{c.Change, base}, percent, mover)
+		categories.Rows = append(categories.Rows, row)
+	}
+
+	return []*Table{periods, categories}
+}
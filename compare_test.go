@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComparison(t *testing.T) {
+	// 2021 runs for 360 days, 2022 so far for 90
+	last := &TxSlice{[]*Transaction{
+		{"dcu", date("Jan 1 2021"), "PAYROLL", 4000, "", "USD", "income", false},
+		{"dcu", date("Jun 1 2021"), "RESTAURANT", -400, "", "USD", "dining", false},
+		{"dcu", date("Dec 27 2021"), "GROCERY", -600, "", "USD", "food", false},
+	}, nil}
+	this := &TxSlice{[]*Transaction{
+		{"dcu", date("Jan 1 2022"), "PAYROLL", 1000, "", "USD", "income", false},
+		{"dcu", date("Feb 1 2022"), "RESTAURANT", -300, "", "USD", "dining", false},
+		{"dcu", date("Apr 1 2022"), "GYM", -50, "", "USD", "fitness", false},
+	}, nil}
+
+	comparison := NewComparison([]string{"2021", "ytd"}, []*TxSlice{last, this}, true)
+	if comparison.Scales[0] != 0.25 || comparison.Scales[1] != 1 {
+		t.Fatalf("unexpected scales %v", comparison.Scales)
+	}
+
+	byCategory := make(map[string]CategoryComparison)
+	for _, c := range comparison.Categories {
+		byCategory[c.Category] = c
+	}
+
+	if dining := byCategory["dining"]; dining.Totals[0] != -100 || dining.Change != -200 || dining.PercentChange() != -200 {
+		t.Fatalf("unexpected dining comparison %+v", dining)
+	}
+	if !math.IsNaN(byCategory["fitness"].PercentChange()) {
+		t.Fatalf("expecting no percentage change for a new category")
+	}
+	if comparison.Categories[0].Category != "dining" {
+		t.Fatalf("expecting dining to be the biggest mover, got %s", comparison.Categories[0].Category)
+	}
+
+	raw := NewComparison([]string{"2021", "ytd"}, []*TxSlice{last, this}, false)
+	if raw.Scales[0] != 1 {
+		t.Fatalf("expecting no scaling without normalization")
+	}
+}
+
+func TestShiftYears(t *testing.T) {
+	r := ShiftYears(DateRange{date("Jan 1 2024"), date("Feb 29 2024")}, -1)
+	if !r.Start.Equal(date("Jan 1 2023")) || !r.End.Equal(date("Feb 28 2023")) {
+		t.Fatalf("unexpected range %v", r)
+	}
+	if rangeLabel(r) != "01/01/2023..02/28/2023" || rangeLabel(ShiftYears(DateRange{date("Apr 1 2022"), date("Jun 30 2022")}, -1)) != "Q2 2021" {
+		t.Fatalf("unexpected labels")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateRange is the first and last day (inclusive) covered by a date
+// expression.  Days are midnight UTC, matching how transaction dates are
+// parsed.
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r DateRange) SingleDay() bool {
+	return r.Start.Equal(r.End)
+}
+
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func monthRange(year int, month time.Month) DateRange {
+	return DateRange{
+		time.Date(year, month, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func yearRange(year int) DateRange {
+	return DateRange{
+		time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func quarterRange(quarter, year int) DateRange {
+	start := time.Date(year, time.Month(quarter-1)*3+1, 1, 0, 0, 0, 0, time.UTC)
+	return PeriodContaining(GranularityQuarter, time.January, start).Range()
+}
+
+var (
+	dateExprYear       = regexp.MustCompile(`^(\d{4})$`)
+	dateExprMonth      = regexp.MustCompile(`^(\d{4})-(\d{1,2})$`)
+	dateExprQuarter    = regexp.MustCompile(`^q([1-4])-?(\d{4})$`)
+	dateExprYearFirstQ = regexp.MustCompile(`^(\d{4})-?q([1-4])$`)
+	dateExprLastN      = regexp.MustCompile(`^last-(\d+)-(day|week|month|year)s?$`)
+)
+
+const DateExpressionHelp = "MM/DD/YYYY, YYYY-MM-DD, YYYY, YYYY-MM, Q12021, today, yesterday, ytd, this-month, last-month, " +
+	"this-quarter, last-quarter, this-year, last-year, last-12-months, 'since 2022-03' or 'start..end'"
+
+// Parses a date expression.  Expressions relative to today (ytd,
+// last-month, ...) are resolved against the current date.
+func ParseDateRange(expr string) (DateRange, error) {
+	return parseDateRange(expr, today())
+}
+
+// The first day of a date expression, for commands that take a single day
+func ParseDate(expr string) (time.Time, error) {
+	r, err := ParseDateRange(expr)
+	return r.Start, err
+}
+
+func parseDateRange(expr string, today time.Time) (DateRange, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(expr)), "-")
+
+	if parts := strings.SplitN(expr, "..", 2); len(parts) == 2 {
+		from, err := parseDateRange(parts[0], today)
+		if err != nil {
+			return DateRange{}, err
+		}
+		to, err := parseDateRange(parts[1], today)
+		if err != nil {
+			return DateRange{}, err
+		}
+		if to.End.Before(from.Start) {
+			return DateRange{}, fmt.Errorf("invalid date range %s (end is before start)", expr)
+		}
+		return DateRange{from.Start, to.End}, nil
+	}
+
+	if strings.HasPrefix(normalized, "since-") {
+		from, err := parseDateRange(strings.TrimPrefix(normalized, "since-"), today)
+		if err != nil {
+			return DateRange{}, err
+		}
+		return DateRange{from.Start, today}, nil
+	}
+
+	year, month := today.Year(), today.Month()
+	quarter := (int(month)-1)/3 + 1
+
+	switch normalized {
+	case "today":
+		return DateRange{today, today}, nil
+	case "yesterday":
+		yesterday := today.AddDate(0, 0, -1)
+		return DateRange{yesterday, yesterday}, nil
+	case "ytd":
+		return DateRange{yearRange(year).Start, today}, nil
+	case "this-month":
+		return monthRange(year, month), nil
+	case "last-month":
+		return monthRange(year, month-1), nil
+	case "this-quarter":
+		return quarterRange(quarter, year), nil
+	case "last-quarter":
+		if quarter == 1 {
+			return quarterRange(4, year-1), nil
+		}
+		return quarterRange(quarter-1, year), nil
+	case "this-year":
+		return yearRange(year), nil
+	case "last-year":
+		return yearRange(year - 1), nil
+	}
+
+	if match := dateExprLastN.FindStringSubmatch(normalized); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		start := map[string]time.Time{
+			"day":   today.AddDate(0, 0, -n),
+			"week":  today.AddDate(0, 0, -7*n),
+			"month": today.AddDate(0, -n, 0),
+			"year":  today.AddDate(-n, 0, 0),
+		}[match[2]]
+		return DateRange{start, today}, nil
+	}
+
+	if match := dateExprYear.FindStringSubmatch(normalized); match != nil {
+		y, _ := strconv.Atoi(match[1])
+		return yearRange(y), nil
+	}
+
+	if match := dateExprMonth.FindStringSubmatch(normalized); match != nil {
+		y, _ := strconv.Atoi(match[1])
+		m, _ := strconv.Atoi(match[2])
+		if m < 1 || m > 12 {
+			return DateRange{}, fmt.Errorf("invalid month in %s", expr)
+		}
+		return monthRange(y, time.Month(m)), nil
+	}
+
+	if match := dateExprQuarter.FindStringSubmatch(normalized); match != nil {
+		q, _ := strconv.Atoi(match[1])
+		y, _ := strconv.Atoi(match[2])
+		return quarterRange(q, y), nil
+	}
+
+	if match := dateExprYearFirstQ.FindStringSubmatch(normalized); match != nil {
+		y, _ := strconv.Atoi(match[1])
+		q, _ := strconv.Atoi(match[2])
+		return quarterRange(q, y), nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "01/02/2006"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(expr)); err == nil {
+			return DateRange{t, t}, nil
+		}
+	}
+
+	return DateRange{}, fmt.Errorf("invalid date %s (expecting %s)", expr, DateExpressionHelp)
+}
+
+// Resolves the --period, --start and --end expressions of a filter.  The
+// period sets both ends, and --start and --end each override one end.  A
+// lone --start that names a single day runs until today while one that names
+// a longer range (2021, Q12021, ...) covers that range.  Without any of them
+// the last 12 months are selected.
+func resolveDateRange(period, start, end string, today time.Time) (DateRange, map[string]string) {
+	errors := make(map[string]string)
+	r := DateRange{today.AddDate(-1, 0, 0), today}
+
+	if len(period) > 0 {
+		parsed, err := parseDateRange(period, today)
+		if err != nil {
+			errors["period"] = err.Error()
+		}
+		r = parsed
+	}
+
+	if len(start) > 0 {
+		parsed, err := parseDateRange(start, today)
+		if err != nil {
+			errors["start"] = err.Error()
+		}
+		r.Start = parsed.Start
+		if len(period) == 0 && len(end) == 0 && !parsed.SingleDay() {
+			r.End = parsed.End
+		}
+	}
+
+	if len(end) > 0 {
+		parsed, err := parseDateRange(end, today)
+		if err != nil {
+			errors["end"] = err.Error()
+		}
+		r.End = parsed.End
+	}
+
+	return r, errors
+}
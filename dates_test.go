@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseDateRange(t *testing.T) {
+	now := date("May 15 2022")
+
+	for expr, expected := range map[string][2]string{
+		"03/05/2021":          {"Mar 5 2021", "Mar 5 2021"},
+		"2021-03-05":          {"Mar 5 2021", "Mar 5 2021"},
+		"2021":                {"Jan 1 2021", "Dec 31 2021"},
+		"2021-02":             {"Feb 1 2021", "Feb 28 2021"},
+		"Q12021":              {"Jan 1 2021", "Mar 31 2021"},
+		"2021-q4":             {"Oct 1 2021", "Dec 31 2021"},
+		"ytd":                 {"Jan 1 2022", "May 15 2022"},
+		"last-month":          {"Apr 1 2022", "Apr 30 2022"},
+		"this-quarter":        {"Apr 1 2022", "Jun 30 2022"},
+		"last-quarter":        {"Jan 1 2022", "Mar 31 2022"},
+		"last-year":           {"Jan 1 2021", "Dec 31 2021"},
+		"last-12-months":      {"May 15 2021", "May 15 2022"},
+		"last 30 days":        {"Apr 15 2022", "May 15 2022"},
+		"since 2022-03":       {"Mar 1 2022", "May 15 2022"},
+		"2021-11..2022-01":    {"Nov 1 2021", "Jan 31 2022"},
+		"01/01/2021..Q12021":  {"Jan 1 2021", "Mar 31 2021"},
+		"yesterday":           {"May 14 2022", "May 14 2022"},
+		"since Q12022":        {"Jan 1 2022", "May 15 2022"},
+		"2022-01..this-month": {"Jan 1 2022", "May 31 2022"},
+	} {
+		r, err := parseDateRange(expr, now)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if !r.Start.Equal(date(expected[0])) || !r.End.Equal(date(expected[1])) {
+			t.Errorf("%s: expecting %s to %s, got %s to %s", expr, expected[0], expected[1], r.Start, r.End)
+		}
+	}
+
+	for _, invalid := range []string{"", "2021-13", "Q52021", "next-month", "2022..2021", "since"} {
+		if _, err := parseDateRange(invalid, now); err == nil {
+			t.Errorf("expecting %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestResolveDateRange(t *testing.T) {
+	now := date("May 15 2022")
+
+	for _, c := range []struct {
+		period, start, end string
+		expected           [2]string
+	}{
+		{"", "", "", [2]string{"May 15 2021", "May 15 2022"}},
+		{"", "01/01/2022", "", [2]string{"Jan 1 2022", "May 15 2022"}},
+		{"", "Q12021", "", [2]string{"Jan 1 2021", "Mar 31 2021"}},
+		{"", "2021", "2022-02", [2]string{"Jan 1 2021", "Feb 28 2022"}},
+		{"2021", "", "", [2]string{"Jan 1 2021", "Dec 31 2021"}},
+		{"2021", "2021-06", "", [2]string{"Jun 1 2021", "Dec 31 2021"}},
+	} {
+		r, errors := resolveDateRange(c.period, c.start, c.end, now)
+		if len(errors) > 0 {
+			t.Errorf("%+v: %v", c, errors)
+			continue
+		}
+		if !r.Start.Equal(date(c.expected[0])) || !r.End.Equal(date(c.expected[1])) {
+			t.Errorf("%+v: got %s to %s", c, r.Start, r.End)
+		}
+	}
+
+	if _, errors := resolveDateRange("", "", "soon", now); errors["end"] == "" {
+		t.Errorf("expecting an error for end")
+	}
+}
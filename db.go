@@ -18,12 +18,20 @@ import (
 type dbExecFunc func(string, ...interface{}) (sql.Result, error)
 
 type PennyDb struct {
-	encryptedDbPath string
-	secretKey       []byte
-	mutex           *sync.RWMutex
-	txCache         []*Transaction
-	investmentCache []*Investment
-	log             *Logger
+	encryptedDbPath   string
+	secretKey         []byte
+	mutex             *sync.RWMutex
+	txCache           []*Transaction
+	investmentCache   []*Investment
+	linkCache         []*Link
+	accountCache      []*Account
+	tagCache          map[string][]string
+	reimbursableCache map[string]string
+	noteCache         map[string]string
+	classifier        *Classifier
+	fx                *FxConverter
+	log               *Logger
+	unconverted       map[string]error // why amounts in a currency were left out of totals
 }
 
 type PennyDbHandle struct {
@@ -31,6 +39,7 @@ type PennyDbHandle struct {
 	pdb             *PennyDb
 	readOnly        bool
 	decryptedDbPath string
+	tx              *sql.Tx // set while statements run in one SQL transaction
 }
 
 func NewPennyDb(encryptedDbPath string, log *Logger, secretKey []byte) (*PennyDb, error) {
@@ -38,7 +47,7 @@ func NewPennyDb(encryptedDbPath string, log *Logger, secretKey []byte) (*PennyDb
 		return nil, fmt.Errorf("expecting a secret key length of 32 bytes")
 	}
 	var mutex sync.RWMutex
-	return &PennyDb{encryptedDbPath, secretKey, &mutex, nil, nil, log}, nil
+	return &PennyDb{encryptedDbPath, secretKey, &mutex, nil, nil, nil, nil, nil, nil, nil, NewClassifier(DefaultClassificationRules()), nil, log, make(map[string]error)}, nil
 }
 
 func (pdb *PennyDb) LoadCaches() error {
@@ -59,6 +68,112 @@ func (pdb *PennyDb) LoadCaches() error {
 		return err
 	}
 
+	pdb.linkCache, err = handle.AllLinks()
+
+	if err != nil {
+		return err
+	}
+
+	pdb.reimbursableCache, err = handle.AllReimbursable()
+
+	if err != nil {
+		return err
+	}
+
+	pdb.accountCache, err = handle.AllAccounts()
+
+	if err != nil {
+		return err
+	}
+
+	pdb.tagCache, err = handle.AllTags()
+
+	if err != nil {
+		return err
+	}
+
+	pdb.noteCache, err = handle.AllNotes()
+
+	if err != nil {
+		return err
+	}
+
+	rules, err := handle.ClassificationRules()
+
+	if err != nil {
+		return err
+	}
+
+	pdb.classifier = NewClassifier(rules)
+
+	return nil
+}
+
+func (pdb *PennyDb) Classifier() *Classifier {
+	pdb.mutex.RLock()
+	defer pdb.mutex.RUnlock()
+	return pdb.classifier
+}
+
+func (pdb *PennyDb) SaveClassificationRule(rule ClassificationRule) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	_, err = handle.Exec(`DELETE FROM classification WHERE field=? AND pattern=?`, rule.Field, rule.Pattern)
+	if err != nil {
+		return err
+	}
+
+	_, err = handle.Exec(`INSERT INTO classification (field, pattern, kind) VALUES (?, ?, ?)`, rule.Field, rule.Pattern, string(rule.Kind))
+	if err != nil {
+		return err
+	}
+
+	rules, err := handle.ClassificationRules()
+	if err != nil {
+		return err
+	}
+
+	pdb.classifier = NewClassifier(rules)
+	return nil
+}
+
+func (pdb *PennyDb) DeleteClassificationRule(field, pattern string) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	res, err := handle.Exec(`DELETE FROM classification WHERE field=? AND pattern=?`, field, pattern)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("no classification rule for %s %s", field, pattern)
+	}
+
+	rules, err := handle.ClassificationRules()
+	if err != nil {
+		return err
+	}
+
+	pdb.classifier = NewClassifier(rules)
 	return nil
 }
 
@@ -248,11 +363,8 @@ func (pdb *PennyDb) WriteDecryptedDb(outfile string) error {
 }
 
 func (pdb *PennyDb) Slice(filter *Filter) *TxSlice {
-	pdb.mutex.RLock()
-	defer pdb.mutex.RUnlock()
-
 	var sliceTxs []*Transaction
-	for _, tx := range pdb.txCache {
+	for _, tx := range pdb.AllTransactions() {
 		if len(filter.Categories) > 0 {
 			found := false
 			for _, category := range filter.Categories {
@@ -269,6 +381,10 @@ func (pdb *PennyDb) Slice(filter *Filter) *TxSlice {
 			continue
 		}
 
+		if filter.Query != nil && !filter.Query.Match(tx, pdb) {
+			continue
+		}
+
 		if tx.Date.Equal(filter.Start) || tx.Date.Equal(filter.End) || (tx.Date.After(filter.Start) && tx.Date.Before(filter.End)) {
 			sliceTxs = append(sliceTxs, tx)
 		}
@@ -307,7 +423,11 @@ func (pdb *PennyDb) Update(transactions []*Transaction) error {
 
 	for _, tx := range transactions {
 		res, err := handle.Exec(
-			`UPDATE tx SET category=?, ignored=?, source=? WHERE date=? AND amount=? AND memo=? AND disambiguation=?`,
+			`UPDATE tx SET modified=CASE WHEN category IS ? AND ignored IS ? AND source IS ? THEN modified ELSE 1 END,
+				category=?, ignored=?, source=? WHERE date=? AND amount=? AND memo=? AND disambiguation=?`,
+			tx.Category,
+			tx.Ignored,
+			tx.Source,
 			tx.Category,
 			tx.Ignored,
 			tx.Source,
@@ -347,67 +467,96 @@ func (pdb *PennyDb) Insert(transactions []*Transaction) error {
 	}
 	defer handle.Close()
 
-	currentTransactions, err := handle.AllTransactions()
+	_, err = handle.insertTransactions(transactions, 0, nil)
+	return err
+}
+
+func (pdb *PennyDb) InsertInvestments(investments []*Investment) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
 	if err != nil {
 		return err
 	}
+	defer handle.Close()
+
+	_, err = handle.insertInvestments(investments, 0, nil)
+	return err
+}
+
+// Inserts the transactions that aren't already in the database, tagged with
+// the import batch they came from (0 for none) and the IDs their institution
+// gave them, and refreshes the cache.  Returns the transactions inserted.
+func (handle *PennyDbHandle) insertTransactions(transactions []*Transaction, batchId int64, externalIds map[string]string) ([]*Transaction, error) {
+	pdb := handle.pdb
+
+	currentTransactions, err := handle.AllTransactions()
+	if err != nil {
+		return nil, err
+	}
 
 	transactionFromId := make(map[string]*Transaction)
 	for _, tx := range currentTransactions {
 		transactionFromId[tx.Id()] = tx
 	}
 
+	var inserted []*Transaction
 	for _, tx := range transactions {
 		if _, ok := transactionFromId[tx.Id()]; ok {
 			pdb.log.Info("Transaction with ID %s already in database", tx.Id())
 			continue
 		}
 
+		if len(tx.Currency) == 0 {
+			tx.Currency = DefaultCurrency
+			if account := findAccount(pdb.accountCache, tx.Source); account != nil && len(account.Currency) > 0 {
+				tx.Currency = account.Currency
+			}
+		}
+
 		res, err := handle.Exec(
-			`INSERT INTO tx (source, date, amount, memo, disambiguation, category, ignored) values (?, ?, ?, ?, ?, ?, ?)`,
+			`INSERT INTO tx (source, date, amount, memo, disambiguation, currency, category, ignored, batch_id, external_id) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			tx.Source,
 			tx.Date.Format("2006-01-02"),
 			tx.Amount,
 			tx.Memo,
 			tx.Disambiguation,
+			tx.Currency,
 			tx.Category,
-			tx.Ignored)
+			tx.Ignored,
+			batchId,
+			externalIds[tx.Id()])
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		rows, err := res.RowsAffected()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if rows != 1 {
-			return errors.New("could not insert into 'tx' table")
+			return nil, errors.New("could not insert into 'tx' table")
 		}
+		inserted = append(inserted, tx)
 	}
 
 	pdb.txCache, err = handle.AllTransactions()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return inserted, nil
 }
 
-func (pdb *PennyDb) InsertInvestments(investments []*Investment) error {
-	pdb.mutex.Lock()
-	defer pdb.mutex.Unlock()
-
-	handle, err := pdb.OpenReadWrite()
-	if err != nil {
-		return err
-	}
-	defer handle.Close()
+func (handle *PennyDbHandle) insertInvestments(investments []*Investment, batchId int64, externalIds map[string]string) ([]*Investment, error) {
+	pdb := handle.pdb
 
 	currentInvestments, err := handle.AllInvestments()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	investmentFromId := make(map[string]*Investment)
@@ -415,42 +564,54 @@ func (pdb *PennyDb) InsertInvestments(investments []*Investment) error {
 		investmentFromId[tx.Id()] = tx
 	}
 
+	var inserted []*Investment
 	for _, investment := range investments {
 		if _, ok := investmentFromId[investment.Id()]; ok {
 			pdb.log.Info("Investment with ID %s already in database", investment.Id())
 			continue
 		}
 
+		if len(investment.Currency) == 0 {
+			investment.Currency = DefaultCurrency
+			if account := findAccount(pdb.accountCache, fmt.Sprintf("%d", investment.Account)); account != nil && len(account.Currency) > 0 {
+				investment.Currency = account.Currency
+			}
+		}
+
 		res, err := handle.Exec(
-			`INSERT INTO investment (account, date, type, symbol, shares, price, disambiguation) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			`INSERT INTO investment (account, date, type, symbol, shares, price, disambiguation, currency, batch_id, external_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			investment.Account,
 			investment.Date.Format("2006-01-02"),
 			investment.Type,
 			investment.Symbol,
 			investment.Shares,
 			investment.Price,
-			investment.Disambiguation)
+			investment.Disambiguation,
+			investment.Currency,
+			batchId,
+			externalIds[investment.Id()])
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		rows, err := res.RowsAffected()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if rows != 1 {
-			return errors.New("could not insert into 'investment' table")
+			return nil, errors.New("could not insert into 'investment' table")
 		}
+		inserted = append(inserted, investment)
 	}
 
-	pdb.txCache, err = handle.AllTransactions()
+	pdb.investmentCache, err = handle.AllInvestments()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return inserted, nil
 }
 
 func (pdb *PennyDb) decryptDbToTempFile() (string, error) {
@@ -511,7 +672,7 @@ func (pdb *PennyDb) open(readOnly bool) (*PennyDbHandle, error) {
 		return nil, err
 	}
 
-	handle := &PennyDbHandle{db, pdb, readOnly, path}
+	handle := &PennyDbHandle{db, pdb, readOnly, path, nil}
 
 	err = handle.Setup()
 
@@ -558,16 +719,39 @@ func (handle *PennyDbHandle) Close() error {
 
 func (handle *PennyDbHandle) Query(query string, args ...interface{}) (*sql.Rows, error) {
 	handle.pdb.log.DbQuery(query, args...)
+	if handle.tx != nil {
+		return handle.tx.Query(query, args...)
+	}
 	return handle.db.Query(query, args...)
 }
 
 func (handle *PennyDbHandle) Exec(query string, args ...interface{}) (sql.Result, error) {
 	handle.pdb.log.DbQuery(query, args...)
+	if handle.tx != nil {
+		return handle.tx.Exec(query, args...)
+	}
 	return handle.db.Exec(query, args...)
 }
 
+// Runs every statement of body in one SQL transaction, which is rolled back
+// if body fails
+func (handle *PennyDbHandle) atomic(body func() error) error {
+	tx, err := handle.db.Begin()
+	if err != nil {
+		return err
+	}
+	handle.tx = tx
+	defer func() { handle.tx = nil }()
+
+	if err := body(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 func (handle *PennyDbHandle) AllInvestments() ([]*Investment, error) {
-	rows, err := handle.Query("SELECT account, date, type, symbol, shares, price, disambiguation FROM investment ORDER BY date, account, shares, price, disambiguation;")
+	rows, err := handle.Query("SELECT account, date, type, symbol, shares, price, disambiguation, currency FROM investment ORDER BY date, account, shares, price, disambiguation;")
 	if err != nil {
 		return nil, err
 	}
@@ -585,6 +769,7 @@ func (handle *PennyDbHandle) AllInvestments() ([]*Investment, error) {
 			&investment.Shares,
 			&investment.Price,
 			&investment.Disambiguation,
+			&investment.Currency,
 		)
 		if err != nil {
 			return nil, err
@@ -604,8 +789,35 @@ func (handle *PennyDbHandle) AllInvestments() ([]*Investment, error) {
 	return investments, nil
 }
 
+// The external IDs (like OFX FITIDs) already in a table, tx or investment
+func (pdb *PennyDb) ExternalIds(table string) (map[string]bool, error) {
+	handle, err := pdb.OpenReadOnly()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	rows, err := handle.Query(fmt.Sprintf("SELECT external_id FROM %s WHERE external_id != '';", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+
+	return ids, rows.Err()
+}
+
 func (handle *PennyDbHandle) AllTransactions() ([]*Transaction, error) {
-	rows, err := handle.Query("SELECT source, date, amount, memo, disambiguation, category, ignored FROM tx ORDER BY date, amount, memo, disambiguation;")
+	rows, err := handle.Query("SELECT source, date, amount, memo, disambiguation, currency, category, ignored FROM tx ORDER BY date, amount, memo, disambiguation;")
 	if err != nil {
 		return nil, err
 	}
@@ -615,7 +827,7 @@ func (handle *PennyDbHandle) AllTransactions() ([]*Transaction, error) {
 	for rows.Next() {
 		var tx Transaction
 		var date string
-		err = rows.Scan(&tx.Source, &date, &tx.Amount, &tx.Memo, &tx.Disambiguation, &tx.Category, &tx.Ignored)
+		err = rows.Scan(&tx.Source, &date, &tx.Amount, &tx.Memo, &tx.Disambiguation, &tx.Currency, &tx.Category, &tx.Ignored)
 		if err != nil {
 			return nil, err
 		}
@@ -634,6 +846,75 @@ func (handle *PennyDbHandle) AllTransactions() ([]*Transaction, error) {
 	return transactions, nil
 }
 
+func (handle *PennyDbHandle) ClassificationRules() ([]ClassificationRule, error) {
+	rows, err := handle.Query("SELECT field, pattern, kind FROM classification ORDER BY rowid;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []ClassificationRule
+	for rows.Next() {
+		var rule ClassificationRule
+		var kind string
+		err = rows.Scan(&rule.Field, &rule.Pattern, &kind)
+		if err != nil {
+			return nil, err
+		}
+		rule.Kind, err = ParseKind(kind)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Adds a column to a table that was created by an older version of penny
+func (handle *PennyDbHandle) addColumn(table, column, definition string) error {
+	rows, err := handle.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return err
+	}
+
+	var columns []string
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			dflt       interface{}
+			pk         int
+		)
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &dflt, &pk)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, name)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	if contains(column, columns) {
+		return nil
+	}
+
+	_, err = handle.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	return err
+}
+
 func (handle *PennyDbHandle) Setup() error {
 	rows, err := handle.Query("SELECT name FROM sqlite_master WHERE type='table';")
 	if err != nil {
@@ -705,6 +986,166 @@ func (handle *PennyDbHandle) Setup() error {
 		}
 	}
 
+	if !contains("classification", tables) {
+		_, err := handle.Exec(`CREATE TABLE classification (
+			field TEXT,
+			pattern TEXT,
+			kind TEXT
+		);`)
+
+		if err != nil {
+			return err
+		}
+
+		for _, rule := range DefaultClassificationRules() {
+			_, err = handle.Exec(`INSERT INTO classification (field, pattern, kind) VALUES (?, ?, ?)`, rule.Field, rule.Pattern, string(rule.Kind))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !contains("tx_link", tables) {
+		_, err := handle.Exec(`CREATE TABLE tx_link (
+			from_id TEXT PRIMARY KEY,
+			to_id TEXT,
+			kind TEXT
+		);`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !contains("account", tables) {
+		_, err := handle.Exec(`CREATE TABLE account (
+			id TEXT PRIMARY KEY,
+			name TEXT,
+			institution TEXT,
+			type TEXT,
+			owner TEXT,
+			tax_treatment TEXT,
+			closed INTEGER
+		);`)
+
+		if err != nil {
+			return err
+		}
+
+		// Account names used to be hardcoded in the report command and cached in accounts_cache
+		if contains("accounts_cache", tables) {
+			_, err = handle.Exec(`INSERT INTO account (id, name, institution, type, owner, tax_treatment, closed)
+				SELECT key, value, '', '', '', '', 0 FROM accounts_cache WHERE value != ''`)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !contains("balance", tables) {
+		_, err := handle.Exec(`CREATE TABLE balance (
+			source TEXT,
+			date TEXT,
+			balance FLOAT,
+			kind TEXT
+		);`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !contains("view", tables) {
+		_, err := handle.Exec(`CREATE TABLE view (
+			name TEXT PRIMARY KEY,
+			query TEXT,
+			period TEXT,
+			group_by TEXT,
+			columns TEXT,
+			sort TEXT
+		);`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !contains("tx_tag", tables) {
+		_, err := handle.Exec(`CREATE TABLE tx_tag (
+			tx_id TEXT,
+			tag TEXT
+		);`)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = handle.Exec(`CREATE UNIQUE INDEX tx_tag_idx ON tx_tag (tx_id, tag);`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !contains("import_batch", tables) {
+		_, err := handle.Exec(`CREATE TABLE import_batch (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file TEXT,
+			hash TEXT,
+			importer TEXT,
+			imported_at TEXT,
+			rows INTEGER
+		);`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !contains("tx_note", tables) {
+		_, err := handle.Exec(`CREATE TABLE tx_note (
+			tx_id TEXT PRIMARY KEY,
+			note TEXT,
+			batch_id INTEGER DEFAULT 0
+		);`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !contains("reimbursable", tables) {
+		_, err := handle.Exec(`CREATE TABLE reimbursable (
+			tx_id TEXT PRIMARY KEY,
+			note TEXT
+		);`)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	migrations := [][]string{
+		{"tx", "currency", "TEXT DEFAULT 'USD'"},
+		{"investment", "currency", "TEXT DEFAULT 'USD'"},
+		{"account", "currency", "TEXT DEFAULT 'USD'"},
+		{"tx", "batch_id", "INTEGER DEFAULT 0"},
+		{"tx", "modified", "INTEGER DEFAULT 0"},
+		{"investment", "batch_id", "INTEGER DEFAULT 0"},
+		{"tx", "external_id", "TEXT DEFAULT ''"},
+		{"investment", "external_id", "TEXT DEFAULT ''"},
+		{"tx_tag", "batch_id", "INTEGER DEFAULT 0"},
+		{"balance", "batch_id", "INTEGER DEFAULT 0"},
+	}
+
+	for _, migration := range migrations {
+		err := handle.addColumn(migration[0], migration[1], migration[2])
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -37,13 +37,13 @@ func TestDatabase(t *testing.T) {
 	err = pdb.LoadCaches()
 	fail(t, err)
 
-	tx1 := Transaction{"source", date("Jan 1 2018"), "memo", 1.1, "", "category1", false}
-	tx1_mod := Transaction{"source", date("Jan 1 2018"), "memo", 1.1, "", "category1_NEW", true}
-	tx2 := Transaction{"source2", date("Jan 2 2018"), "memo2", 1.2, "", "category2", false}
-	tx2_mod := Transaction{"source2", date("Jan 2 2018"), "memo2", 1.2, "", "category2_NEW", false}
-	tx3 := Transaction{"source3", date("Jan 3 2018"), "memo3", 1.3, "", "category3", false}
-	tx3_mod := Transaction{"source3", date("Jan 3 2018"), "memo3", 1.3, "", "category3_NEW", true}
-	tx4 := Transaction{"source4", date("Jan 4 2018"), "memo4", 1.4, "disambiguation", "category4", false}
+	tx1 := Transaction{"source", date("Jan 1 2018"), "memo", 1.1, "", "USD", "category1", false}
+	tx1_mod := Transaction{"source", date("Jan 1 2018"), "memo", 1.1, "", "USD", "category1_NEW", true}
+	tx2 := Transaction{"source2", date("Jan 2 2018"), "memo2", 1.2, "", "USD", "category2", false}
+	tx2_mod := Transaction{"source2", date("Jan 2 2018"), "memo2", 1.2, "", "USD", "category2_NEW", false}
+	tx3 := Transaction{"source3", date("Jan 3 2018"), "memo3", 1.3, "", "USD", "category3", false}
+	tx3_mod := Transaction{"source3", date("Jan 3 2018"), "memo3", 1.3, "", "USD", "category3_NEW", true}
+	tx4 := Transaction{"source4", date("Jan 4 2018"), "memo4", 1.4, "disambiguation", "USD", "category4", false}
 
 	first := []*Transaction{&tx1, &tx2, &tx3, &tx4}
 
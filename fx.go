@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leekchan/accounting"
+)
+
+const DefaultCurrency = "USD"
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"CHF": "CHF ",
+	"CAD": "CA$",
+	"AUD": "A$",
+	"MXN": "MX$",
+}
+
+// Formats an amount in its original currency, e.g. €12.50
+func moneyIn(amount float64, currency string, color bool) string {
+	if len(currency) == 0 || currency == DefaultCurrency {
+		return money(amount, color)
+	}
+
+	symbol, ok := currencySymbols[currency]
+	if !ok {
+		symbol = currency + " "
+	}
+
+	colorFunc := nocolor
+	if color {
+		if amount > 0 {
+			colorFunc = green
+		} else {
+			colorFunc = red
+		}
+	}
+	formatter := accounting.Accounting{Symbol: symbol, Precision: 2}
+	return colorFunc(formatter.FormatMoney(amount))
+}
+
+// An FxProvider returns the number of units of `to` that one unit of `from`
+// was worth on a given date
+type FxProvider interface {
+	Rate(from, to string, date time.Time) (float64, error)
+}
+
+type fxRate struct {
+	date time.Time
+	rate float64
+}
+
+// CsvFxProvider looks up rates from a local CSV file with the columns
+// date (YYYY-MM-DD), from, to, rate.  The most recent rate on or before the
+// requested date is used, so the file can have gaps for weekends and holidays.
+type CsvFxProvider struct {
+	rates map[string][]fxRate
+}
+
+func NewCsvFxProvider(contents []byte) (*CsvFxProvider, error) {
+	records, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &CsvFxProvider{make(map[string][]fxRate)}
+	for index, record := range records {
+		if len(record) < 4 {
+			return nil, fmt.Errorf("line %d: expecting columns date, from, to, rate", index+1)
+		}
+		date, err := time.Parse("2006-01-02", record[0])
+		if err != nil {
+			if index == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: %v", index+1, err)
+		}
+		rate, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", index+1, err)
+		}
+		from, to := strings.ToUpper(record[1]), strings.ToUpper(record[2])
+		provider.rates[from+to] = append(provider.rates[from+to], fxRate{date, rate})
+		provider.rates[to+from] = append(provider.rates[to+from], fxRate{date, 1 / rate})
+	}
+
+	for _, rates := range provider.rates {
+		sort.Slice(rates, func(i, j int) bool {
+			return rates[i].date.Before(rates[j].date)
+		})
+	}
+
+	return provider, nil
+}
+
+func (provider *CsvFxProvider) Rate(from, to string, date time.Time) (float64, error) {
+	return rateOnOrBefore(provider.rates[from+to], from, to, date)
+}
+
+// The most recent of the rates (sorted by date) on or before the given date
+func rateOnOrBefore(rates []fxRate, from, to string, date time.Time) (float64, error) {
+	index := sort.Search(len(rates), func(i int) bool {
+		return rates[i].date.After(date)
+	})
+	if index == 0 {
+		return 0, fmt.Errorf("no %s/%s rate on or before %s", from, to, date.Format("2006-01-02"))
+	}
+	return rates[index-1].rate, nil
+}
+
+// FrankfurterFxProvider looks up European Central Bank reference rates.  A
+// month of rates is fetched at a time, starting a week early so that the
+// first days of the month have a rate from before the weekend or holiday.
+type FrankfurterFxProvider struct {
+	mutex  *sync.Mutex
+	months map[string][]fxRate
+}
+
+func NewFrankfurterFxProvider() *FrankfurterFxProvider {
+	var mutex sync.Mutex
+	return &FrankfurterFxProvider{&mutex, make(map[string][]fxRate)}
+}
+
+func (provider *FrankfurterFxProvider) Rate(from, to string, date time.Time) (float64, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	month := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	key := fmt.Sprintf("%s%s%s", from, to, month.Format("2006-01"))
+	rates, ok := provider.months[key]
+	if !ok {
+		var err error
+		rates, err = provider.fetch(from, to, month.AddDate(0, 0, -7), month.AddDate(0, 1, -1))
+		if err != nil {
+			return 0, err
+		}
+		provider.months[key] = rates
+	}
+	return rateOnOrBefore(rates, from, to, date)
+}
+
+func (provider *FrankfurterFxProvider) fetch(from, to string, start, end time.Time) ([]fxRate, error) {
+	resp, err := http.Get(fmt.Sprintf("https://api.frankfurter.app/%s..%s?from=%s&to=%s", start.Format("2006-01-02"), end.Format("2006-01-02"), from, to))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Rates map[string]map[string]float64 `json:"rates"`
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []fxRate
+	for day, dayRates := range response.Rates {
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return nil, err
+		}
+		if rate, ok := dayRates[to]; ok {
+			rates = append(rates, fxRate{date, rate})
+		}
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].date.Before(rates[j].date)
+	})
+	return rates, nil
+}
+
+// Selects a provider: a path to a rates CSV file, "frankfurter", or none at
+// all when the spec is empty
+func NewFxProvider(spec string) (FxProvider, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	if spec == "frankfurter" {
+		return NewFrankfurterFxProvider(), nil
+	}
+	contents, err := ioutil.ReadFile(spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewCsvFxProvider(contents)
+}
+
+// FxConverter converts amounts into a base currency.  Rates are looked up a
+// month at a time and cached in the fx_rate table as JSON by date, so that a
+// report over a year opens the database at most once per month and currency
+// rather than once per day.
+type FxConverter struct {
+	Base     string
+	pdb      *PennyDb
+	provider FxProvider
+	mutex    *sync.Mutex
+	cache    *PennyDbCache
+	months   map[string]map[string]float64
+}
+
+func NewFxConverter(pdb *PennyDb, base string, provider FxProvider) *FxConverter {
+	var mutex sync.Mutex
+	return &FxConverter{strings.ToUpper(base), pdb, provider, &mutex, nil, make(map[string]map[string]float64)}
+}
+
+// Every rate in a month from the provider, by date (YYYY-MM-DD)
+func (converter *FxConverter) fetchMonth(key string) (string, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid fx_rate key %s", key)
+	}
+	month, err := time.Parse("2006-01", parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	// Days without a rate, e.g. before the first rate in a CSV file, are
+	// left out so that converting on them is an error
+	rates := make(map[string]float64)
+	for date := month; date.Month() == month.Month(); date = date.AddDate(0, 0, 1) {
+		rate, rateErr := converter.provider.Rate(parts[0], parts[1], date)
+		if rateErr != nil {
+			err = rateErr
+			continue
+		}
+		rates[date.Format("2006-01-02")] = rate
+	}
+	if len(rates) == 0 {
+		return "", err
+	}
+
+	value, err := json.Marshal(rates)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (converter *FxConverter) rate(from, to string, date time.Time) (float64, error) {
+	converter.mutex.Lock()
+	defer converter.mutex.Unlock()
+
+	if converter.provider == nil {
+		return 0, fmt.Errorf("cannot convert %s to %s without an FX rate provider (see --fx-rates)", from, to)
+	}
+
+	month := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	key := fmt.Sprintf("%s:%s:%s", from, to, month.Format("2006-01"))
+	rates, ok := converter.months[key]
+	if !ok {
+		// The fx_rate table is only opened the first time a rate is needed
+		if converter.cache == nil {
+			cache, err := converter.pdb.DBBackedCache("fx_rate", converter.fetchMonth)
+			if err != nil {
+				return 0, err
+			}
+			converter.cache = cache
+		}
+
+		// Rates for the current month are still coming in
+		ttl := time.Duration(math.MaxInt64)
+		if !month.AddDate(0, 1, 0).Before(time.Now()) {
+			ttl = 12 * time.Hour
+		}
+		value, err := converter.cache.GetWithTTL(key, ttl)
+		if err != nil {
+			return 0, err
+		}
+		err = json.Unmarshal([]byte(value), &rates)
+		if err != nil {
+			return 0, err
+		}
+		converter.months[key] = rates
+	}
+
+	rate, ok := rates[date.Format("2006-01-02")]
+	if !ok {
+		return 0, fmt.Errorf("no %s/%s rate for %s", from, to, date.Format("2006-01-02"))
+	}
+	return rate, nil
+}
+
+func (converter *FxConverter) Convert(amount float64, currency string, date time.Time) (float64, error) {
+	if len(currency) == 0 {
+		currency = DefaultCurrency
+	}
+	if currency == converter.Base {
+		return amount, nil
+	}
+
+	rate, err := converter.rate(currency, converter.Base, date)
+	if err != nil {
+		return 0, err
+	}
+	return amount * rate, nil
+}
+
+func (pdb *PennyDb) SetFxConverter(converter *FxConverter) {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+	pdb.fx = converter
+}
+
+func (pdb *PennyDb) BaseCurrency() string {
+	pdb.mutex.RLock()
+	defer pdb.mutex.RUnlock()
+	if pdb.fx == nil {
+		return DefaultCurrency
+	}
+	return pdb.fx.Base
+}
+
+// Converts an amount into the base currency using the rate on the given date
+func (pdb *PennyDb) ToBaseCurrency(amount float64, currency string, date time.Time) (float64, error) {
+	pdb.mutex.RLock()
+	converter := pdb.fx
+	pdb.mutex.RUnlock()
+
+	if converter == nil {
+		if len(currency) == 0 || currency == DefaultCurrency {
+			return amount, nil
+		}
+		return 0, fmt.Errorf("cannot convert %s to %s without an FX rate provider", currency, DefaultCurrency)
+	}
+	return converter.Convert(amount, currency, date)
+}
+
+// Converts an amount into the base currency like ToBaseCurrency, but when it
+// can't, remembers why and returns false so that the caller can leave the
+// amount out of its totals
+func (pdb *PennyDb) InBaseCurrency(amount float64, currency string, date time.Time) (float64, bool) {
+	converted, err := pdb.ToBaseCurrency(amount, currency, date)
+	if err != nil {
+		pdb.mutex.Lock()
+		pdb.unconverted[defaultString(currency, DefaultCurrency)] = err
+		pdb.mutex.Unlock()
+		return 0, false
+	}
+	return converted, true
+}
+
+// Tells which currencies were left out of totals, once however many totals
+// were computed
+func (pdb *PennyDb) WarnUnconverted(writer io.Writer) {
+	pdb.mutex.RLock()
+	defer pdb.mutex.RUnlock()
+
+	var currencies []string
+	for currency := range pdb.unconverted {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		fmt.Fprintf(writer, "WARNING: %s amounts were left out of totals: %s\n", currency, pdb.unconverted[currency])
+	}
+}
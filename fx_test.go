@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFxConversion(t *testing.T) {
+	provider, err := NewCsvFxProvider([]byte("date,from,to,rate\n2018-01-01,EUR,USD,1.2\n2018-01-08,EUR,USD,1.25\n"))
+	fail(t, err)
+
+	rate, err := provider.Rate("EUR", "USD", date("Jan 5 2018"))
+	fail(t, err)
+	if rate != 1.2 {
+		t.Fatalf("expecting most recent rate 1.2, got %f", rate)
+	}
+
+	if _, err := provider.Rate("EUR", "USD", date("Dec 31 2017")); err == nil {
+		t.Fatalf("expecting error for date before the first rate")
+	}
+
+	dbPath := tempFilePath()
+	defer os.Remove(dbPath)
+
+	pdb, err := NewPennyDb(dbPath, NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	err = pdb.LoadCaches()
+	fail(t, err)
+	pdb.SetFxConverter(NewFxConverter(pdb, "USD", provider))
+
+	tx1 := Transaction{"dcu", date("Jan 2 2018"), "PAYROLL", 1000, "", "USD", "income", false}
+	tx2 := Transaction{"euro", date("Jan 9 2018"), "CAFE", -10, "", "EUR", "dining", false}
+	err = pdb.Insert([]*Transaction{&tx1, &tx2})
+	fail(t, err)
+
+	totals := pdb.DefaultSlice().Totals()
+	if totals.Income != 1000 || totals.Expenses != -12.5 {
+		t.Fatalf("expecting EUR expense to be converted to USD, got %+v", totals)
+	}
+
+	if s := moneyIn(-10, "EUR", false); s != "-€10.00" {
+		t.Fatalf("expecting -€10.00, got %s", s)
+	}
+}
+
+type countingFxProvider struct {
+	provider FxProvider
+	calls    int
+}
+
+func (counting *countingFxProvider) Rate(from, to string, date time.Time) (float64, error) {
+	counting.calls++
+	return counting.provider.Rate(from, to, date)
+}
+
+func TestFxConverterCachesByMonth(t *testing.T) {
+	csvProvider, err := NewCsvFxProvider([]byte("date,from,to,rate\n2018-01-01,EUR,USD,1.2\n2018-01-08,EUR,USD,1.25\n"))
+	fail(t, err)
+	provider := &countingFxProvider{csvProvider, 0}
+
+	dbPath := tempFilePath()
+	defer os.Remove(dbPath)
+
+	pdb, err := NewPennyDb(dbPath, NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+	converter := NewFxConverter(pdb, "USD", provider)
+
+	for _, day := range []string{"Jan 5 2018", "Jan 9 2018", "Jan 31 2018"} {
+		_, err := converter.Convert(-10, "EUR", date(day))
+		fail(t, err)
+	}
+	if provider.calls != 31 {
+		t.Fatalf("expecting January to be looked up once, got %d lookups", provider.calls)
+	}
+
+	// A new converter finds the month in the fx_rate table
+	converter = NewFxConverter(pdb, "USD", provider)
+	amount, err := converter.Convert(-10, "EUR", date("Jan 9 2018"))
+	fail(t, err)
+	if amount != -12.5 || provider.calls != 31 {
+		t.Fatalf("expecting -12.50 from the fx_rate table, got %.2f after %d lookups", amount, provider.calls)
+	}
+}
+
+func TestFxWithoutProvider(t *testing.T) {
+	dbPath := tempFilePath()
+	defer os.Remove(dbPath)
+
+	pdb, err := NewPennyDb(dbPath, NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+	pdb.SetFxConverter(NewFxConverter(pdb, "USD", nil))
+
+	tx1 := Transaction{"dcu", date("Jan 2 2018"), "GROCER", -20, "", "USD", "food", false}
+	tx2 := Transaction{"euro", date("Jan 9 2018"), "CAFE", -10, "", "EUR", "dining", false}
+	fail(t, pdb.Insert([]*Transaction{&tx1, &tx2}))
+
+	// Amounts that can't be converted are left out, and reported once
+	slice := pdb.DefaultSlice()
+	if totals := slice.Totals(); totals.Expenses != -20 {
+		t.Fatalf("expecting the EUR expense to be left out, got %+v", totals)
+	}
+	slice.CategorySummaries()
+
+	var warnings bytes.Buffer
+	pdb.WarnUnconverted(&warnings)
+	if lines := strings.Split(strings.TrimSpace(warnings.String()), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], "WARNING: EUR ") {
+		t.Fatalf("expecting one warning about EUR, got %q", warnings.String())
+	}
+}
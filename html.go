@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// svgSeries is one set of values in a chart, drawn in a single color
+type svgSeries struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+const (
+	svgWidth  = 720
+	svgHeight = 260
+	svgMargin = 50
+)
+
+var svgColors = []string{"#2e7d32", "#c62828", "#1565c0", "#6a1b9a", "#ef6c00", "#00838f"}
+
+func svgText(x, y float64, anchor, text string) string {
+	return fmt.Sprintf(`<text x="%.1f" y="%.1f" text-anchor="%s">%s</text>`, x, y, anchor, template.HTMLEscapeString(text))
+}
+
+func svgLegend(series []svgSeries) string {
+	var svg strings.Builder
+	for index, s := range series {
+		x := float64(svgMargin + index*140)
+		fmt.Fprintf(&svg, `<rect x="%.1f" y="4" width="12" height="12" fill="%s"/>`, x, s.Color)
+		svg.WriteString(svgText(x+18, 14, "start", s.Name))
+	}
+	return svg.String()
+}
+
+// Vertical bars grouped by label, one bar per series.  Values are drawn by
+// their size so that expenses and income can share an axis.
+func SVGBarChart(labels []string, series []svgSeries, format func(float64) string) template.HTML {
+	max := 0.0
+	for _, s := range series {
+		for _, value := range s.Values {
+			max = math.Max(max, math.Abs(value))
+		}
+	}
+	if max == 0 || len(labels) == 0 {
+		return ""
+	}
+
+	plotHeight := float64(svgHeight - 2*svgMargin)
+	groupWidth := float64(svgWidth-2*svgMargin) / float64(len(labels))
+	barWidth := groupWidth * 0.8 / float64(len(series))
+	baseline := float64(svgHeight - svgMargin)
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="chart">`, svgWidth, svgHeight)
+	svg.WriteString(svgLegend(series))
+	fmt.Fprintf(&svg, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="axis"/>`, svgMargin, baseline, svgWidth-svgMargin, baseline)
+	svg.WriteString(svgText(svgMargin-6, float64(svgMargin)+4, "end", format(max)))
+
+	for index, label := range labels {
+		x := float64(svgMargin) + float64(index)*groupWidth + groupWidth*0.1
+		for s, values := range series {
+			height := math.Abs(values.Values[index]) / max * plotHeight
+			fmt.Fprintf(&svg, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s %s: %s</title></rect>`,
+				x+float64(s)*barWidth, baseline-height, barWidth, height, values.Color,
+				template.HTMLEscapeString(label), template.HTMLEscapeString(values.Name), format(values.Values[index]))
+		}
+		// Skip labels when they would overlap
+		if step := int(math.Ceil(float64(len(labels)) / 12)); index%step == 0 {
+			svg.WriteString(svgText(x+groupWidth*0.4, baseline+16, "middle", label))
+		}
+	}
+	svg.WriteString(`</svg>`)
+	return template.HTML(svg.String())
+}
+
+// Horizontal bars, one per label, for breakdowns like categories or holdings
+func SVGHorizontalBarChart(labels []string, values []float64, format func(float64) string) template.HTML {
+	max := 0.0
+	for _, value := range values {
+		max = math.Max(max, math.Abs(value))
+	}
+	if max == 0 {
+		return ""
+	}
+
+	const rowHeight, labelWidth = 22, 180
+	height := len(labels)*rowHeight + 10
+	plotWidth := float64(svgWidth - labelWidth - 100)
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="chart">`, svgWidth, height)
+	for index, label := range labels {
+		y := float64(index*rowHeight + 5)
+		width := math.Abs(values[index]) / max * plotWidth
+		color := svgColors[0]
+		if values[index] < 0 {
+			color = svgColors[1]
+		}
+		svg.WriteString(svgText(labelWidth-8, y+14, "end", label))
+		fmt.Fprintf(&svg, `<rect x="%d" y="%.1f" width="%.1f" height="%d" fill="%s"/>`, labelWidth, y, width, rowHeight-6, color)
+		svg.WriteString(svgText(labelWidth+width+6, y+14, "start", format(values[index])))
+	}
+	svg.WriteString(`</svg>`)
+	return template.HTML(svg.String())
+}
+
+// Lines through each series, for projections over time
+func SVGLineChart(labels []string, series []svgSeries, format func(float64) string) template.HTML {
+	min, max := 0.0, 0.0
+	for _, s := range series {
+		for _, value := range s.Values {
+			min, max = math.Min(min, value), math.Max(max, value)
+		}
+	}
+	if max == min || len(labels) < 2 {
+		return ""
+	}
+
+	plotHeight := float64(svgHeight - 2*svgMargin)
+	step := float64(svgWidth-2*svgMargin) / float64(len(labels)-1)
+	y := func(value float64) float64 {
+		return float64(svgHeight-svgMargin) - (value-min)/(max-min)*plotHeight
+	}
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="chart">`, svgWidth, svgHeight)
+	svg.WriteString(svgLegend(series))
+	fmt.Fprintf(&svg, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="axis"/>`, svgMargin, y(0), svgWidth-svgMargin, y(0))
+	svg.WriteString(svgText(svgMargin-6, y(max)+4, "end", format(max)))
+
+	for _, s := range series {
+		var points []string
+		for index, value := range s.Values {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", float64(svgMargin)+float64(index)*step, y(value)))
+		}
+		fmt.Fprintf(&svg, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(points, " "), s.Color)
+	}
+
+	for index, label := range labels {
+		if labelStep := int(math.Ceil(float64(len(labels)) / 12)); index%labelStep == 0 {
+			svg.WriteString(svgText(float64(svgMargin)+float64(index)*step, float64(svgHeight-svgMargin)+16, "middle", label))
+		}
+	}
+	svg.WriteString(`</svg>`)
+	return template.HTML(svg.String())
+}
+
+// The labels and numeric values of one column of a table, skipping rows
+// without a value (like the blank rows between years)
+func tableSeries(table *Table, labelKey, valueKey string) ([]string, []float64) {
+	labelColumn, valueColumn := -1, -1
+	for index, column := range table.Columns {
+		if column.Key == labelKey {
+			labelColumn = index
+		}
+		if column.Key == valueKey {
+			valueColumn = index
+		}
+	}
+
+	var labels []string
+	var values []float64
+	if labelColumn < 0 || valueColumn < 0 {
+		return labels, values
+	}
+	for _, row := range table.Rows {
+		value, ok := rawCell(row[valueColumn]).(float64)
+		if !ok {
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%v", rawCell(row[labelColumn])))
+		values = append(values, value)
+	}
+	return labels, values
+}
+
+type htmlCell struct {
+	Text     string
+	Negative bool
+}
+
+type htmlTable struct {
+	Title    string
+	Chart    template.HTML
+	KeyValue bool
+	Headers  []string
+	Rows     [][]htmlCell
+	Footer   []htmlCell
+}
+
+// HTMLReport is a single self-contained page of tables and SVG charts
+type HTMLReport struct {
+	Title     string
+	Generated time.Time
+	Sections  []htmlTable
+}
+
+func htmlCells(row []interface{}) []htmlCell {
+	var renderer Renderer
+	var cells []htmlCell
+	for _, value := range row {
+		negative := false
+		if number, ok := rawCell(value).(float64); ok {
+			negative = number < 0
+		}
+		cells = append(cells, htmlCell{renderer.humanCell(value, false), negative})
+	}
+	return cells
+}
+
+func (report *HTMLReport) Add(table *Table, chart template.HTML) {
+	section := htmlTable{Title: table.Title, Chart: chart, KeyValue: table.KeyValue}
+	for _, column := range table.Columns {
+		section.Headers = append(section.Headers, column.Header)
+	}
+	for _, row := range table.Rows {
+		section.Rows = append(section.Rows, htmlCells(row))
+	}
+	if table.Footer != nil {
+		section.Footer = htmlCells(table.Footer)
+	}
+	report.Sections = append(report.Sections, section)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; max-width: 960px; margin: 2em auto; padding: 0 1em; }
+h1 { font-weight: 400; }
+h2 { font-weight: 400; text-transform: capitalize; border-bottom: 1px solid #ddd; padding-bottom: .3em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; font-size: 14px; margin-top: 1em; }
+th, td { padding: 4px 10px; text-align: right; border-bottom: 1px solid #eee; }
+th:first-child, td:first-child { text-align: left; }
+th { background: #f5f5f5; }
+tfoot td { font-weight: 600; border-top: 2px solid #ccc; }
+td.negative { color: #c62828; }
+.chart { width: 100%; height: auto; font-size: 11px; fill: #444; }
+.chart .axis { stroke: #999; }
+.generated { color: #888; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="generated">Generated {{.Generated.Format "January 2, 2006"}}</p>
+{{range .Sections}}
+{{if .Title}}<h2>{{.Title}}</h2>{{end}}
+{{.Chart}}
+<table>
+{{if not .KeyValue}}<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr></thead>{{end}}
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td{{if .Negative}} class="negative"{{end}}>{{.Text}}</td>{{end}}</tr>
+{{end}}</tbody>
+{{if .Footer}}<tfoot><tr>{{range .Footer}}<td>{{.Text}}</td>{{end}}</tr></tfoot>{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+func (report *HTMLReport) Write(writer io.Writer) error {
+	return htmlReportTemplate.Execute(writer, report)
+}
+
+// Builds the HTML version of the report tables, adding a chart to each
+// section that has one
+func NewHTMLReport(tables []*Table, base string) *HTMLReport {
+	report := &HTMLReport{Title: "Penny Report", Generated: time.Now()}
+	format := func(v float64) string { return moneyIn(v, base, false) }
+
+	for _, table := range tables {
+		var chart template.HTML
+		switch table.Name {
+		case "periods":
+			labels, income := tableSeries(table, "period", "income")
+			_, expenses := tableSeries(table, "period", "expenses")
+			_, investments := tableSeries(table, "period", "investments")
+			chart = SVGBarChart(labels, []svgSeries{
+				{"Income", svgColors[0], income},
+				{"Expenses", svgColors[1], expenses},
+				{"Investments", svgColors[2], investments},
+			}, format)
+		case "categories", "holdings":
+			key := "total"
+			if table.Name == "holdings" {
+				key = "value"
+			}
+			labels, values := tableSeries(table, "category", key)
+			if table.Name == "holdings" {
+				labels, values = tableSeries(table, "symbol", key)
+			}
+			chart = SVGHorizontalBarChart(labels, values, format)
+		case "retirement_projection":
+			labels, portfolio := tableSeries(table, "year", "portfolio")
+			_, expenses := tableSeries(table, "year", "expenses")
+			for index := range expenses {
+				expenses[index] = math.Abs(expenses[index])
+			}
+			chart = SVGLineChart(labels, []svgSeries{
+				{"Portfolio", svgColors[2], portfolio},
+				{"Expenses", svgColors[1], expenses},
+			}, format)
+		}
+		report.Add(table, chart)
+	}
+	return report
+}
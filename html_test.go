@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTableSeries(t *testing.T) {
+	table := &Table{Name: "periods", Columns: []Column{{"Quarter", "period"}, {"Income", "income"}}}
+	table.Append("Q1 2021", Money{100, "USD"})
+	table.Append("", "")
+	table.Append("Q2 2021", Money{-25.5, "USD"})
+
+	labels, values := tableSeries(table, "period", "income")
+	if strings.Join(labels, ",") != "Q1 2021,Q2 2021" || len(values) != 2 || values[1] != -25.5 {
+		t.Fatalf("unexpected series %v %v", labels, values)
+	}
+	if labels, _ := tableSeries(table, "period", "missing"); len(labels) != 0 {
+		t.Fatalf("expected no series for a missing column, got %v", labels)
+	}
+}
+
+func TestHTMLReport(t *testing.T) {
+	periods := &Table{Name: "periods", Title: "Quarterly Summary", Columns: []Column{
+		{"Quarter", "period"}, {"Income", "income"}, {"Expenses", "expenses"}, {"Investments", "investments"},
+	}}
+	periods.Append("Q1 2021", Money{5000, "USD"}, Money{-3000, "USD"}, Money{-1000, "USD"})
+	periods.Append("Q2 2021", Money{5200, "USD"}, Money{-3100, "USD"}, Money{-1200, "USD"})
+
+	categories := &Table{Name: "categories", Title: "categories", Columns: []Column{{"Category", "category"}, {"Total", "total"}}}
+	categories.Append("<food>", Money{-600, "USD"})
+	categories.Footer = []interface{}{"TOTAL", Money{-600, "USD"}}
+
+	projection := &Table{Name: "retirement_projection", Columns: []Column{
+		{"Year", "year"}, {"Portfolio", "portfolio"}, {"Expenses", "expenses"},
+	}}
+	projection.Append(2021, Money{100000, "USD"}, Money{-40000, "USD"})
+	projection.Append(2022, Money{110000, "USD"}, Money{-41000, "USD"})
+
+	var buffer bytes.Buffer
+	if err := NewHTMLReport([]*Table{periods, categories, projection}, "USD").Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	html := buffer.String()
+
+	for _, expected := range []string{"<h2>Quarterly Summary</h2>", "<polyline", "&lt;food&gt;", `class="negative"`, "<tfoot>"} {
+		if !strings.Contains(html, expected) {
+			t.Fatalf("expected report to contain %q", expected)
+		}
+	}
+	if count := strings.Count(html, "<svg"); count != 3 {
+		t.Fatalf("expected 3 charts, got %d", count)
+	}
+	for _, external := range []string{"src=", "<link", "<script", "href="} {
+		if strings.Contains(html, external) {
+			t.Fatalf("report should be self-contained but contains %q", external)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"os"
-	"regexp"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,15 +18,61 @@ import (
 type TransactionImporter struct {
 	txs         map[string]*Transaction
 	investments map[string]*Investment
+	SkipInvalid bool    // reject rows that don't parse instead of failing the import
+	Rejected    []error // why each rejected row was skipped
+
+	// IDs given by the institution (like OFX FITIDs), keyed by row ID
+	txExternalIds         map[string]string
+	investmentExternalIds map[string]string
+
+	tags     map[string][]string // tags to add, keyed by transaction ID
+	notes    map[string]string   // notes to add, keyed by transaction ID
+	balances []*Balance          // balances printed on imported statements
 }
 
 func NewTransactionImporter() *TransactionImporter {
 	return &TransactionImporter{
 		make(map[string]*Transaction),
 		make(map[string]*Investment),
+		false,
+		nil,
+		make(map[string]string),
+		make(map[string]string),
+		make(map[string][]string),
+		make(map[string]string),
+		nil,
 	}
 }
 
+// Adds a transaction along with the ID its institution gave it, which
+// identifies it across imports even if its memo or amount are later revised
+func (ti *TransactionImporter) AddExternal(tx *Transaction, externalId string) {
+	ti.Add(tx)
+	ti.txExternalIds[tx.Id()] = externalId
+}
+
+func (ti *TransactionImporter) AddInvestmentExternal(investment *Investment, externalId string) {
+	ti.AddInvestment(investment)
+	ti.investmentExternalIds[investment.Id()] = externalId
+}
+
+// Tags a transaction that was already added
+func (ti *TransactionImporter) AddTags(tx *Transaction, tags ...string) {
+	ti.tags[tx.Id()] = append(ti.tags[tx.Id()], tags...)
+}
+
+// Adds a note to a transaction that was already added
+func (ti *TransactionImporter) AddNote(tx *Transaction, note string) {
+	if note = strings.TrimSpace(note); len(note) > 0 {
+		ti.notes[tx.Id()] = note
+	}
+}
+
+// Records an opening or statement balance found in an imported file
+func (ti *TransactionImporter) AddBalance(balance *Balance) {
+	ti.balances = append(ti.balances, balance)
+}
+
 func (ti *TransactionImporter) Add(tx *Transaction) {
 	if _, ok := ti.txs[tx.Id()]; ok {
 		for i := 0; ; i++ {
@@ -71,157 +121,710 @@ func (ti *TransactionImporter) AllInvestments() []*Investment {
 	return slice
 }
 
-func (importer *TransactionImporter) ImportInvestments(csvFileContents []byte) error {
-	r := csv.NewReader(bytes.NewReader(csvFileContents))
-	records, err := r.ReadAll()
-	if err != nil {
-		return err
-	}
+// An Importer reads one kind of export file (a bank's CSV, an OFX file, ...)
+// and adds its rows to a TransactionImporter.  Detect scores how well a file
+// matches the importer, 0 meaning not at all, so that the format of a file
+// can be sniffed.
+type Importer interface {
+	Name() string
+	Detect(filename string, contents []byte) int
+	Import(source string, contents []byte, importer *TransactionImporter) error
+}
 
-	cvt := func(v string) string {
-		v = strings.ReplaceAll(v, "$", "")
-		v = strings.ReplaceAll(v, ",", "")
-		return v
+var importers = make(map[string]Importer)
+
+// Makes an importer available by name.  Registering a name twice replaces the
+// earlier importer, which is how configured profiles override built-in ones.
+func RegisterImporter(importer Importer) {
+	importers[importer.Name()] = importer
+}
+
+func LookupImporter(name string) (Importer, error) {
+	importer, ok := importers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown importer %s (expecting one of %s)", name, strings.Join(ImporterNames(), ", "))
 	}
+	return importer, nil
+}
 
-	normalizeDate := func(date string) string {
-		r := regexp.MustCompile("(\\d+)/(\\d+)/(\\d+)")
-		parts := r.FindStringSubmatch(date)
-		m, _ := strconv.ParseInt(parts[1], 10, 16)
-		d, _ := strconv.ParseInt(parts[2], 10, 16)
-		y, _ := strconv.ParseInt(parts[3], 10, 16)
-		return fmt.Sprintf("%02d/%02d/%04d", m, d, y)
+func ImporterNames() []string {
+	var names []string
+	for name := range importers {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	for _, record := range records {
-		account, err := strconv.ParseInt(record[0], 10, 64)
-		if err != nil {
-			continue
+// Picks the importer that best matches a file.  Two importers matching
+// equally well is an error, since guessing could import into the wrong source.
+func SniffImporter(filename string, contents []byte) (Importer, error) {
+	var best []string
+	bestScore := 0
+	for _, name := range ImporterNames() {
+		score := importers[name].Detect(filename, contents)
+		if score > bestScore {
+			best, bestScore = []string{name}, score
+		} else if score > 0 && score == bestScore {
+			best = append(best, name)
 		}
-		n := normalizeDate(record[1])
-		date, err := time.Parse("01/02/2006", n)
-		if err != nil {
-			return err
+	}
+
+	switch len(best) {
+	case 0:
+		return nil, fmt.Errorf("unrecognized format (use --format, one of %s)", strings.Join(ImporterNames(), ", "))
+	case 1:
+		return importers[best[0]], nil
+	default:
+		return nil, fmt.Errorf("ambiguous format, could be %s (use --format)", strings.Join(best, " or "))
+	}
+}
+
+// CsvProfile describes the layout of a CSV export so that a new bank only
+// needs a profile instead of code.  Columns are given by header name (case
+// insensitive) or by 1-based position for files without a header.  The header
+// is the first row after SkipRows that contains every named column.
+type CsvProfile struct {
+	ProfileName string `json:"name"`
+	Source      string `json:"source"`       // used when the import doesn't name a source
+	SkipRows    int    `json:"skip_rows"`    // rows before the header (or the data if there is no header)
+	DateFormat  string `json:"date_format"`  // Go layout, defaults to 1/2/2006
+	Date        string `json:"date"`         // column of the transaction date
+	Memo        string `json:"memo"`         // column of the description
+	Amount      string `json:"amount"`       // column of a signed amount...
+	Debit       string `json:"debit"`        // ...or separate debit (money out)
+	Credit      string `json:"credit"`       // and credit (money in) columns
+	Currency    string `json:"currency"`     // fixed currency of every row...
+	CurrencyCol string `json:"currency_col"` // ...or the column holding it
+	Negate      bool   `json:"negate"`       // amounts are positive for money out, as on many card exports
+	Strip       string `json:"strip"`        // characters removed from amounts, defaults to "$,"
+	SkipInvalid bool   `json:"skip_invalid"` // skip rows that don't parse (summary lines, ...) instead of failing
+
+	// Investment exports set these instead of Memo and Amount
+	Account string `json:"account"`
+	Type    string `json:"type"`
+	Symbol  string `json:"symbol"`
+	Shares  string `json:"shares"`
+	Price   string `json:"price"`
+}
+
+func (profile *CsvProfile) Name() string {
+	return profile.ProfileName
+}
+
+func (profile *CsvProfile) investments() bool {
+	return len(profile.Shares) > 0
+}
+
+func (profile *CsvProfile) columnSpecs() []string {
+	var specs []string
+	for _, spec := range []string{profile.Date, profile.Memo, profile.Amount, profile.Debit, profile.Credit, profile.CurrencyCol,
+		profile.Account, profile.Type, profile.Symbol, profile.Shares, profile.Price} {
+		if len(spec) > 0 {
+			specs = append(specs, spec)
 		}
-		shares, err := strconv.ParseFloat(cvt(record[4]), 64)
-		if err != nil {
-			return err
+	}
+	return specs
+}
+
+// Checks that a profile (usually from a config file) can import anything
+func (profile *CsvProfile) Validate() error {
+	if len(profile.ProfileName) == 0 {
+		return fmt.Errorf("CSV profile without a name")
+	}
+	if len(profile.Date) == 0 {
+		return fmt.Errorf("CSV profile %s has no date column", profile.ProfileName)
+	}
+	if profile.investments() {
+		if len(profile.Account) == 0 || len(profile.Symbol) == 0 {
+			return fmt.Errorf("CSV profile %s needs account and symbol columns for investments", profile.ProfileName)
 		}
+		return nil
+	}
+	if len(profile.Amount) == 0 && len(profile.Debit) == 0 && len(profile.Credit) == 0 {
+		return fmt.Errorf("CSV profile %s needs an amount column or debit and credit columns", profile.ProfileName)
+	}
+	return nil
+}
 
-		var price float64
-		if len(record[5]) == 0 {
-			price = 0.0
+// Finds the header row and the index of every column spec.  Without any
+// named columns there is no header and data starts right after SkipRows.
+func (profile *CsvProfile) columns(records [][]string) (map[string]int, int, error) {
+	indexes := make(map[string]int)
+	var names []string
+	for _, spec := range profile.columnSpecs() {
+		if position, err := strconv.Atoi(spec); err == nil {
+			indexes[spec] = position - 1
 		} else {
-			price, err = strconv.ParseFloat(cvt(record[5]), 64)
-			if err != nil {
-				return err
+			names = append(names, spec)
+		}
+	}
+	if len(names) == 0 {
+		return indexes, profile.SkipRows, nil
+	}
+
+	for row := profile.SkipRows; row < len(records); row++ {
+		found := make(map[string]int)
+		for index, header := range records[row] {
+			found[strings.ToLower(strings.TrimSpace(header))] = index
+		}
+		complete := true
+		for _, name := range names {
+			index, ok := found[strings.ToLower(name)]
+			if !ok {
+				complete = false
+				break
 			}
+			indexes[name] = index
+		}
+		if complete {
+			return indexes, row + 1, nil
 		}
+	}
+	return nil, 0, fmt.Errorf("no header with columns %s found", strings.Join(names, ", "))
+}
+
+func readCsv(contents []byte) ([][]string, error) {
+	r := csv.NewReader(bytes.NewReader(contents))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	return r.ReadAll()
+}
 
-		importer.AddInvestment(&Investment{account, date, record[2], record[3], shares, price, ""})
+// Files with a CSV extension score one point per named column found in their
+// header.  Profiles without a header match when any row can be imported.
+func (profile *CsvProfile) Detect(filename string, contents []byte) int {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt", "":
+	default:
+		return 0
 	}
-	return nil
+
+	records, err := readCsv(contents)
+	if err != nil {
+		return 0
+	}
+	if _, _, err := profile.columns(records); err != nil {
+		return 0
+	}
+
+	score := 0
+	for _, spec := range profile.columnSpecs() {
+		if _, err := strconv.Atoi(spec); err != nil {
+			score++
+		}
+	}
+	if score > 0 {
+		return score
+	}
+
+	trial := NewTransactionImporter()
+	trial.SkipInvalid = true
+	if profile.Import("detect", contents, trial) != nil || len(trial.txs)+len(trial.investments) == 0 {
+		return 0
+	}
+	return 1
 }
 
-func (importer *TransactionImporter) ImportAmazonRewards(source string, csvFileContents []byte) error {
-	r := csv.NewReader(bytes.NewReader(csvFileContents))
-	records, err := r.ReadAll()
+func (profile *CsvProfile) Import(source string, contents []byte, importer *TransactionImporter) error {
+	source = defaultString(source, profile.Source)
+	if len(source) == 0 && !profile.investments() {
+		return fmt.Errorf("%s: no source given (use --source)", profile.ProfileName)
+	}
+
+	records, err := readCsv(contents)
 	if err != nil {
 		return err
 	}
 
-	for i, record := range records {
-		if i == 0 {
+	indexes, first, err := profile.columns(records)
+	if err != nil {
+		return fmt.Errorf("%s: %v", profile.ProfileName, err)
+	}
+
+	for row := first; row < len(records); row++ {
+		record := records[row]
+		if len(strings.TrimSpace(strings.Join(record, ""))) == 0 {
 			continue
 		}
 
-		date, err := time.Parse("01/02/2006", record[1])
+		field := func(spec string) string {
+			index, ok := indexes[spec]
+			if len(spec) == 0 || !ok || index < 0 || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+
+		if profile.investments() {
+			// Headers and totals don't have an account number
+			if _, err := strconv.ParseInt(field(profile.Account), 10, 64); err != nil {
+				continue
+			}
+			err = profile.importInvestment(field, importer)
+		} else {
+			err = profile.importTransaction(source, field, importer)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: row %d: %v", profile.ProfileName, row+1, err)
+			if profile.SkipInvalid || importer.SkipInvalid {
+				importer.Rejected = append(importer.Rejected, err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (profile *CsvProfile) date(value string) (time.Time, error) {
+	return time.Parse(defaultString(profile.DateFormat, "1/2/2006"), value)
+}
+
+// Parses an amount after removing currency symbols and thousands separators.
+// An empty value is 0, as debit and credit columns leave one of them blank.
+func (profile *CsvProfile) number(value string) (float64, error) {
+	strip := profile.Strip
+	if len(strip) == 0 {
+		strip = "$,"
+	}
+	value = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(strip, r) {
+			return -1
+		}
+		return r
+	}, value)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func (profile *CsvProfile) importTransaction(source string, field func(string) string, importer *TransactionImporter) error {
+	date, err := profile.date(field(profile.Date))
+	if err != nil {
+		return err
+	}
+
+	var amount float64
+	if len(profile.Amount) > 0 {
+		amount, err = profile.number(field(profile.Amount))
 		if err != nil {
 			return err
 		}
-		amount, err := strconv.ParseFloat(record[5], 64)
+	} else {
+		debit, err := profile.number(field(profile.Debit))
 		if err != nil {
 			return err
 		}
+		credit, err := profile.number(field(profile.Credit))
+		if err != nil {
+			return err
+		}
+		amount = credit - math.Abs(debit)
+	}
+	if profile.Negate {
+		amount = -amount
+	}
 
-		importer.Add(&Transaction{source, date, record[2], amount, "", "", false})
+	currency := profile.Currency
+	if len(profile.CurrencyCol) > 0 {
+		currency = strings.ToUpper(field(profile.CurrencyCol))
 	}
+
+	importer.Add(&Transaction{source, date, field(profile.Memo), amount, "", currency, "", false})
 	return nil
 }
 
-func (importer *TransactionImporter) ImportDCU(source string, csvFileContents []byte) error {
-	r := csv.NewReader(bytes.NewReader(csvFileContents))
-	records, err := r.ReadAll()
+func (profile *CsvProfile) importInvestment(field func(string) string, importer *TransactionImporter) error {
+	account, err := strconv.ParseInt(field(profile.Account), 10, 64)
+	if err != nil {
+		return err
+	}
+	date, err := profile.date(field(profile.Date))
+	if err != nil {
+		return err
+	}
+	shares, err := profile.number(field(profile.Shares))
+	if err != nil {
+		return err
+	}
+	price, err := profile.number(field(profile.Price))
 	if err != nil {
 		return err
 	}
 
-	for i, record := range records {
-		if i == 0 {
-			continue
+	importer.AddInvestment(&Investment{account, date, field(profile.Type), field(profile.Symbol), shares, price, "", profile.Currency})
+	return nil
+}
+
+// Profiles for the exports we've always imported.  More can be added with
+// LoadCsvProfiles.
+var builtinCsvProfiles = []*CsvProfile{
+	{ProfileName: "dcu", Source: "dcu", Date: "DATE", Memo: "DESCRIPTION", Amount: "AMOUNT"},
+	{ProfileName: "chase", Source: "chase", Date: "Post Date", Memo: "Description", Amount: "Amount"},
+	{ProfileName: "capital-one", Source: "cap", Date: "Posted Date", Memo: "Description", Debit: "Debit", Credit: "Credit"},
+	{ProfileName: "investments", Date: "2", Account: "1", Type: "3", Symbol: "4", Shares: "5", Price: "6"},
+}
+
+func init() {
+	for _, profile := range builtinCsvProfiles {
+		RegisterImporter(profile)
+	}
+}
+
+// Registers the CSV profiles in a JSON file (a list of profiles)
+func LoadCsvProfiles(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var profiles []*CsvProfile
+	if err := json.Unmarshal(contents, &profiles); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, profile := range profiles {
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
 		}
+		profile.ProfileName = strings.ToLower(profile.ProfileName)
+		RegisterImporter(profile)
+	}
+	return nil
+}
 
-		date, err := time.Parse("01/02/2006", record[0])
-		if err != nil {
-			return err
+// ImportRenames maps the category and account names found in imported files
+// (typically exports of another budgeting tool) onto penny categories and
+// sources.  Names are matched ignoring case.
+type ImportRenames struct {
+	Categories map[string]string `json:"categories"`
+	Accounts   map[string]string `json:"accounts"`
+}
+
+var importRenames = &ImportRenames{}
+
+// Loads the rename table applied to every import from a JSON file like
+// {"categories": {"Food & Dining": "food"}, "accounts": {"CREDIT CARD": "chase"}}
+func LoadImportRenames(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var renames ImportRenames
+	if err := json.Unmarshal(contents, &renames); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	importRenames = &renames
+	return nil
+}
+
+func lookupRename(renames map[string]string, name string) (string, bool) {
+	if renamed, ok := renames[name]; ok {
+		return renamed, true
+	}
+	for from, to := range renames {
+		if strings.EqualFold(from, name) {
+			return to, true
 		}
+	}
+	return "", false
+}
 
-		memo := record[2]
+// Categories without a rename are kept as they are
+func (renames *ImportRenames) Category(category string) string {
+	if renamed, ok := lookupRename(renames.Categories, category); ok {
+		return renamed
+	}
+	return category
+}
 
-		amountStr := strings.Replace(record[3], ",", "", -1)
-		amountStr = strings.Replace(amountStr, "$", "", -1)
-		amount, err := strconv.ParseFloat(amountStr, 64)
-		if err != nil {
-			return err
+// An account name maps onto a source through the rename table, or else onto
+// the account with that name
+func (renames *ImportRenames) Source(name string, accounts []*Account) string {
+	if renamed, ok := lookupRename(renames.Accounts, name); ok {
+		return renamed
+	}
+	for _, account := range accounts {
+		if len(account.Name) > 0 && strings.EqualFold(account.Name, name) {
+			return account.Id
 		}
+	}
+	return name
+}
+
+// ImportSummary is what happened to the rows of one imported file
+type ImportSummary struct {
+	File      string
+	Importer  string
+	Read      int
+	New       int
+	Duplicate int
+	Rejected  int
+	Err       error
+	Batch     *ImportBatch // set once the new rows are written
+
+	hash         string
+	rows         *TransactionImporter
+	transactions []*Transaction
+	investments  []*Investment
+}
 
-		importer.Add(&Transaction{source, date, memo, amount, "", "", false})
+// Expands globs, keeping plain paths that don't exist so that reading them
+// reports the error
+func expandImportPaths(patterns []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			if strings.ContainsAny(pattern, "*?[") {
+				return nil, fmt.Errorf("no files match %s", pattern)
+			}
+			matches = []string{pattern}
+		}
+		for _, path := range matches {
+			if path = filepath.Clean(path); !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
 	}
-	return nil
+	return paths, nil
 }
 
-func importCapitalOne() []*Transaction {
-	filename := "cap.csv"
+// What importing a transaction would do, from most to least certain
+const (
+	ImportDuplicate     = "duplicate"      // already in the database, skipped
+	ImportDisambiguated = "disambiguated"  // repeated within its file, gets a suffix
+	ImportNearDuplicate = "near-duplicate" // same date and amount as one in the database
+	ImportNew           = "new"
+)
 
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		fmt.Printf("Could not find file %s ... skipping\n", filename)
-		return nil
+type ImportRow struct {
+	File        string
+	Status      string
+	Transaction *Transaction
+	Match       *Transaction // the existing transaction for duplicates
+}
+
+// ImportPlan is the result of running the importers without writing anything
+type ImportPlan struct {
+	Summaries []*ImportSummary
+	Rows      []*ImportRow
+	Linked    int // funded-by links made when the plan was applied
+}
+
+func nearDuplicateKey(tx *Transaction) string {
+	return fmt.Sprintf("%s:%.2f", tx.Date.Format("2006-01-02"), tx.Amount)
+}
+
+// Runs the importers over files and compares every row with the database.
+// The format of each file is detected unless one is given.  Rows that are
+// already in the database, or in an earlier file, are duplicates, so
+// overlapping exports can be imported safely.  Near-duplicates are likewise
+// looked for in the database and the earlier files.  A file that can't be
+// read or parsed is reported in its summary and none of its rows are
+// imported.
+func PlanImport(pdb *PennyDb, patterns []string, source, format string) (*ImportPlan, error) {
+	paths, err := expandImportPaths(patterns)
+	if err != nil {
+		return nil, err
 	}
 
-	fp, err := os.Open("cap.csv")
-	check(err)
-	defer fp.Close()
+	seenTxs := make(map[string]*Transaction)
+	nearTxs := make(map[string][]*Transaction)
+	for _, tx := range pdb.AllTransactions() {
+		seenTxs[tx.Id()] = tx
+		nearTxs[nearDuplicateKey(tx)] = append(nearTxs[nearDuplicateKey(tx)], tx)
+	}
+	seenInvestments := make(map[string]bool)
+	for _, investment := range pdb.AllInvestments() {
+		seenInvestments[investment.Id()] = true
+	}
 
-	r := csv.NewReader(fp)
-	records, err := r.ReadAll()
-	check(err)
+	// External IDs identify rows even when the institution revised them
+	seenExternalTxs, err := pdb.ExternalIds("tx")
+	if err != nil {
+		return nil, err
+	}
+	seenExternalInvestments, err := pdb.ExternalIds("investment")
+	if err != nil {
+		return nil, err
+	}
 
-	importer := NewTransactionImporter()
+	plan := &ImportPlan{}
+	for _, path := range paths {
+		summary := &ImportSummary{File: path}
+		plan.Summaries = append(plan.Summaries, summary)
 
-	for i, record := range records {
-		if i == 0 {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			summary.Err = err
 			continue
 		}
 
-		date, err := time.Parse("01/02/2006", record[1])
-		check(err)
-
-		var amount float64
-		if len(record[6]) > 0 {
-			amount, err = strconv.ParseFloat(record[6], 64)
-			amount = -amount
-			check(err)
-		} else if len(record[7]) > 0 {
-			amount, err = strconv.ParseFloat(record[7], 64)
-			check(err)
+		var importer Importer
+		if len(format) > 0 {
+			importer, err = LookupImporter(format)
 		} else {
-			check(fmt.Errorf("Invalid CSV"))
+			importer, err = SniffImporter(path, contents)
+		}
+		if err != nil {
+			summary.Err = err
+			continue
+		}
+		summary.Importer = importer.Name()
+		summary.hash = fmt.Sprintf("%x", sha256.Sum256(contents))
+
+		fileImporter := NewTransactionImporter()
+		fileImporter.SkipInvalid = true
+		if summary.Err = importer.Import(source, contents, fileImporter); summary.Err != nil {
+			continue
+		}
+		for _, err := range fileImporter.Rejected {
+			pdb.log.Info("%s: rejected %v", path, err)
+		}
+
+		// Categories and sources don't change the ID of a transaction
+		for _, tx := range fileImporter.txs {
+			tx.Category = importRenames.Category(tx.Category)
+			if len(source) == 0 {
+				tx.Source = importRenames.Source(tx.Source, pdb.Accounts())
+			}
+		}
+		if len(source) == 0 {
+			for _, balance := range fileImporter.balances {
+				balance.Source = importRenames.Source(balance.Source, pdb.Accounts())
+			}
+		}
+
+		summary.rows = fileImporter
+		summary.Rejected = len(fileImporter.Rejected)
+		summary.Read = len(fileImporter.txs) + len(fileImporter.investments) + summary.Rejected
+
+		txs := fileImporter.All()
+		sort.SliceStable(txs, func(i, j int) bool { return txs[i].Date.Before(txs[j].Date) })
+		for _, tx := range txs {
+			row := &ImportRow{path, ImportNew, tx, nil}
+			plan.Rows = append(plan.Rows, row)
+
+			externalId := fileImporter.txExternalIds[tx.Id()]
+			if existing, ok := seenTxs[tx.Id()]; ok || (len(externalId) > 0 && seenExternalTxs[externalId]) {
+				row.Status, row.Match = ImportDuplicate, existing
+				summary.Duplicate++
+				continue
+			}
+			seenTxs[tx.Id()] = tx
+			if len(externalId) > 0 {
+				seenExternalTxs[externalId] = true
+			}
+			summary.transactions = append(summary.transactions, tx)
+			summary.New++
+
+			if len(tx.Disambiguation) > 0 {
+				row.Status = ImportDisambiguated
+			} else if near := nearTxs[nearDuplicateKey(tx)]; len(near) > 0 {
+				row.Status, row.Match = ImportNearDuplicate, near[0]
+			}
+		}
+
+		// A file doesn't list the same transaction twice, so its rows are
+		// only near-duplicates of those in the files after it
+		for _, tx := range summary.transactions {
+			nearTxs[nearDuplicateKey(tx)] = append(nearTxs[nearDuplicateKey(tx)], tx)
+		}
+		for _, investment := range fileImporter.AllInvestments() {
+			externalId := fileImporter.investmentExternalIds[investment.Id()]
+			if seenInvestments[investment.Id()] || (len(externalId) > 0 && seenExternalInvestments[externalId]) {
+				summary.Duplicate++
+				continue
+			}
+			seenInvestments[investment.Id()] = true
+			if len(externalId) > 0 {
+				seenExternalInvestments[externalId] = true
+			}
+			summary.investments = append(summary.investments, investment)
+			summary.New++
 		}
+	}
+	return plan, nil
+}
+
+// Writes the new transactions and investments of the plan, one import batch
+// per file
+func (plan *ImportPlan) Apply(pdb *PennyDb) error {
+	if err := pdb.InsertBatches(plan.Summaries, time.Now()); err != nil {
+		return err
+	}
+
+	imported := false
+	for _, summary := range plan.Summaries {
+		imported = imported || summary.Batch != nil
+	}
+	if !imported {
+		return nil
+	}
 
-		importer.Add(&Transaction{"cap", date, record[4], amount, "", "", false})
+	// Either side of a PayPal or Venmo payment may have just been imported
+	links, err := pdb.LinkFundingSources()
+	if err != nil {
+		return err
 	}
+	plan.Linked = len(links)
+	return nil
+}
 
-	fmt.Printf("Imported %d records from %s\n", len(records), filename)
+// The transactions of a dry run and what importing them would do
+func (plan *ImportPlan) Table() *Table {
+	table := &Table{Name: "changes", Title: "dry run", Columns: []Column{
+		{"Status", "status"},
+		{"File", "file"},
+		{"Date", "date"},
+		{"Source", "source"},
+		{"Amount", "amount"},
+		{"Memo", "memo"},
+		{"Existing", "existing"},
+	}}
+	for _, row := range plan.Rows {
+		tx := row.Transaction
+		status, existing := row.Status, ""
+		if row.Match != nil {
+			existing = fmt.Sprintf("%s %s", row.Match.Id(), row.Match.Memo)
+		}
+		if len(tx.Disambiguation) > 0 {
+			status = fmt.Sprintf("%s (%s)", status, tx.Disambiguation)
+		}
+		table.Append(status, row.File, Date(tx.Date), tx.Source, Money{tx.Amount, defaultString(tx.Currency, DefaultCurrency)}, tx.Memo, existing)
+	}
+	return table
+}
 
-	return importer.All()
+func ImportSummaryTable(summaries []*ImportSummary) *Table {
+	table := &Table{Name: "imports", Columns: []Column{
+		{"File", "file"},
+		{"Format", "format"},
+		{"Read", "read"},
+		{"New", "new"},
+		{"Duplicate", "duplicate"},
+		{"Rejected", "rejected"},
+		{"Batch", "batch"},
+		{"Error", "error"},
+	}}
+	for _, summary := range summaries {
+		var batch interface{}
+		if summary.Batch != nil {
+			batch = int(summary.Batch.Id)
+		}
+		message := ""
+		if summary.Err != nil {
+			message = summary.Err.Error()
+		}
+		table.Append(summary.File, summary.Importer, summary.Read, summary.New, summary.Duplicate, summary.Rejected, batch, message)
+	}
+	return table
 }
@@ -3,19 +3,47 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
 
+func (importer *TransactionImporter) importWith(name, source string, contents []byte) error {
+	profile, err := LookupImporter(name)
+	if err != nil {
+		return err
+	}
+	return profile.Import(source, contents, importer)
+}
+
+func (importer *TransactionImporter) ImportAmazonRewards(source string, csvFileContents []byte) error {
+	return importer.importWith("chase", source, csvFileContents)
+}
+
+func (importer *TransactionImporter) ImportDCU(source string, csvFileContents []byte) error {
+	return importer.importWith("dcu", source, csvFileContents)
+}
+
+// Plans and applies an import in one go
+func ImportFiles(pdb *PennyDb, patterns []string, source, format string) ([]*ImportSummary, error) {
+	plan, err := PlanImport(pdb, patterns, source, format)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Summaries, plan.Apply(pdb)
+}
+
 func TestImport(t *testing.T) {
 	time1, _ := time.Parse("Jan 2 2006", "Jan 1 2018")
 	time2, _ := time.Parse("Jan 2 2006", "Jan 2 2018")
 	time3, _ := time.Parse("Jan 2 2006", "Jan 3 2018")
 	time4, _ := time.Parse("Jan 2 2006", "Jan 4 2018")
-	tx1 := Transaction{"dcu", time1, "memo", -1.1, "", "", false}
-	tx2 := Transaction{"dcu2", time2, "memo2", -1.2, "", "", false}
-	tx3 := Transaction{"dcu3", time3, "memo3", -1.3, "", "", false}
-	tx4 := Transaction{"chase", time4, "memo4", -1.4, "", "", false}
+	tx1 := Transaction{"dcu", time1, "memo", -1.1, "", "USD", "", false}
+	tx2 := Transaction{"dcu2", time2, "memo2", -1.2, "", "USD", "", false}
+	tx3 := Transaction{"dcu3", time3, "memo3", -1.3, "", "USD", "", false}
+	tx4 := Transaction{"chase", time4, "memo4", -1.4, "", "USD", "", false}
 
 	dcuImportFile := `"DATE","DESCRIPTION","AMOUNT","CURRENT BALANCE"
 "01/01/2018","memo","-1.1","998.9"`
@@ -49,3 +77,236 @@ func TestImport(t *testing.T) {
 
 	assertTransactions(t, []*Transaction{&tx1, &tx2, &tx3, &tx4}, pdb.AllTransactions())
 }
+
+func TestCsvProfile(t *testing.T) {
+	profile := &CsvProfile{ProfileName: "bank", Source: "bank", SkipRows: 1, DateFormat: "2006-01-02",
+		Date: "Booked", Memo: "Payee", Debit: "Out", Credit: "In", Currency: "EUR", Strip: "€,"}
+
+	contents := `Account statement,,,
+Exported 2021-02-01,,,
+Booked,Payee,Out,In
+2021-01-05,Grocer,"€1,200.50",
+2021-01-06,Employer,,€3000
+,,,`
+
+	importer := NewTransactionImporter()
+	fail(t, profile.Import("", []byte(contents), importer))
+
+	txs := importer.All()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Date.Before(txs[j].Date) })
+	if len(txs) != 2 {
+		t.Fatalf("expected 2 transactions, got %d", len(txs))
+	}
+	if txs[0].Amount != -1200.5 || txs[0].Memo != "Grocer" || txs[0].Source != "bank" || txs[0].Currency != "EUR" {
+		t.Fatalf("unexpected debit %v", txs[0])
+	}
+	if txs[1].Amount != 3000 {
+		t.Fatalf("unexpected credit %v", txs[1])
+	}
+
+	profile.Date = "Value Date"
+	if err := profile.Import("", []byte(contents), NewTransactionImporter()); err == nil {
+		t.Fatalf("expected an error for a missing header")
+	}
+}
+
+func TestCsvProfileNegateAndSkipInvalid(t *testing.T) {
+	profile := &CsvProfile{ProfileName: "card", Date: "1", Memo: "2", Amount: "3", Negate: true, SkipInvalid: true}
+	contents := `1/5/2021,Coffee,4.50
+Total,,4.50`
+
+	importer := NewTransactionImporter()
+	fail(t, profile.Import("card", []byte(contents), importer))
+	if txs := importer.All(); len(txs) != 1 || txs[0].Amount != -4.5 || txs[0].Source != "card" {
+		t.Fatalf("unexpected transactions %v", txs)
+	}
+
+	profile.SkipInvalid = false
+	if err := profile.Import("card", []byte(contents), NewTransactionImporter()); err == nil {
+		t.Fatalf("expected an error for the total row")
+	}
+}
+
+func TestInvestmentsProfile(t *testing.T) {
+	importer := NewTransactionImporter()
+	contents := "Account,Date,Type,Symbol,Shares,Price\n123,1/2/2020,BUY,VTI,10,$150.00\nTotal,,,,10,\n"
+	fail(t, importer.importWith("investments", "", []byte(contents)))
+	if investments := importer.AllInvestments(); len(investments) != 1 || investments[0].Account != 123 || investments[0].Price != 150 {
+		t.Fatalf("expected the header and total to be skipped, got %v", investments)
+	}
+
+	importer = NewTransactionImporter()
+	if err := importer.importWith("investments", "", []byte("123,1/2/2020,BUY,VTI,ten,$150.00\n")); err == nil {
+		t.Fatalf("expected an investment row that doesn't parse to fail the import")
+	}
+}
+
+func TestLoadCsvProfiles(t *testing.T) {
+	file, err := ioutil.TempFile("", "profiles")
+	fail(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`[{"name": "MyBank", "source": "mybank", "date": "Date", "memo": "Memo", "amount": "Amount"}]`)
+	fail(t, err)
+	file.Close()
+
+	fail(t, LoadCsvProfiles(file.Name()))
+	defer delete(importers, "mybank")
+
+	importer, err := LookupImporter("MyBank")
+	fail(t, err)
+	if importer.Name() != "mybank" {
+		t.Fatalf("unexpected importer %s", importer.Name())
+	}
+
+	if _, err := LookupImporter("nobank"); err == nil {
+		t.Fatalf("expected an error for an unknown importer")
+	}
+
+	fail(t, ioutil.WriteFile(file.Name(), []byte(`[{"name": "broken", "date": "Date"}]`), 0600))
+	if err := LoadCsvProfiles(file.Name()); err == nil {
+		t.Fatalf("expected a validation error")
+	}
+}
+
+func TestSniffImporter(t *testing.T) {
+	cases := map[string]string{
+		"DATE,DESCRIPTION,AMOUNT,CURRENT BALANCE\n01/01/2018,memo,-1.1,998.9":                                 "dcu",
+		"Transaction Date,Post Date,Description,Category,Type,Amount,Memo\n01/04/2018,01/04/2018,a,b,c,-1,":   "chase",
+		"Transaction Date,Posted Date,Card No.,Description,Category,Debit,Credit\n1/2/2020,1/2/2020,1,a,b,5,": "capital-one",
+		"123,1/2/2020,BUY,VTI,10,$150.00": "investments",
+	}
+	for contents, expected := range cases {
+		importer, err := SniffImporter("export.csv", []byte(contents))
+		fail(t, err)
+		if importer.Name() != expected {
+			t.Fatalf("expected %s, detected %s", expected, importer.Name())
+		}
+	}
+
+	if _, err := SniffImporter("export.csv", []byte("foo,bar\n1,2")); err == nil {
+		t.Fatalf("expected an unrecognized format")
+	}
+	if _, err := SniffImporter("export.pdf", []byte(`DATE,DESCRIPTION,AMOUNT`)); err == nil {
+		t.Fatalf("expected the extension to rule out CSV profiles")
+	}
+}
+
+func TestImportFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, contents string) {
+		fail(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600))
+	}
+	write("jan.csv", "DATE,DESCRIPTION,AMOUNT\n01/01/2018,rent,-1000\n01/15/2018,coffee,-3\n")
+	write("feb.csv", "DATE,DESCRIPTION,AMOUNT\n01/15/2018,coffee,-3\n02/01/2018,rent,-1000\nnot a date,x,1\n")
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	summaries, err := ImportFiles(pdb, []string{filepath.Join(dir, "*.csv"), filepath.Join(dir, "missing.csv")}, "checking", "")
+	fail(t, err)
+	if len(summaries) != 3 {
+		t.Fatalf("expected 3 summaries, got %d", len(summaries))
+	}
+
+	// Globs are sorted, so feb.csv comes first
+	feb, jan, missing := summaries[0], summaries[1], summaries[2]
+	if feb.Importer != "dcu" || feb.Read != 3 || feb.New != 2 || feb.Rejected != 1 {
+		t.Fatalf("unexpected summary %+v", feb)
+	}
+	if jan.New != 1 || jan.Duplicate != 1 {
+		t.Fatalf("unexpected summary %+v", jan)
+	}
+	if missing.Err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+
+	fail(t, pdb.LoadCaches())
+	if count := len(pdb.AllTransactions()); count != 3 {
+		t.Fatalf("expected 3 transactions, got %d", count)
+	}
+	for _, tx := range pdb.AllTransactions() {
+		if tx.Source != "checking" {
+			t.Fatalf("expected the --source to be used, got %s", tx.Source)
+		}
+	}
+
+	if _, err := ImportFiles(pdb, []string{filepath.Join(dir, "*.ofx")}, "", ""); err == nil {
+		t.Fatalf("expected an error for a glob without matches")
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	date := func(s string) time.Time {
+		d, _ := time.Parse("01/02/2006", s)
+		return d
+	}
+	fail(t, pdb.Insert([]*Transaction{
+		{"checking", date("01/01/2018"), "rent", -1000, "", "USD", "", false},
+		{"checking", date("01/02/2018"), "GROCER #12", -50, "", "USD", "", false},
+	}))
+	fail(t, pdb.LoadCaches())
+
+	path := filepath.Join(dir, "export.csv")
+	fail(t, ioutil.WriteFile(path, []byte(`DATE,DESCRIPTION,AMOUNT
+01/01/2018,rent,-1000
+01/02/2018,GROCER 12 SPRINGFIELD,-50
+01/03/2018,coffee,-3
+01/03/2018,coffee,-3
+01/04/2018,salary,2000
+`), 0600))
+
+	plan, err := PlanImport(pdb, []string{path}, "checking", "")
+	fail(t, err)
+
+	var statuses []string
+	for _, row := range plan.Rows {
+		statuses = append(statuses, row.Status)
+	}
+	sort.Strings(statuses)
+	expected := []string{ImportDisambiguated, ImportDuplicate, ImportNearDuplicate, ImportNew, ImportNew}
+	sort.Strings(expected)
+	if strings.Join(statuses, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected statuses %v, got %v", expected, statuses)
+	}
+	for _, row := range plan.Rows {
+		if row.Status == ImportNearDuplicate && row.Match.Memo != "GROCER #12" {
+			t.Fatalf("unexpected near-duplicate match %v", row.Match)
+		}
+	}
+
+	summary := plan.Summaries[0]
+	if summary.Read != 5 || summary.New != 4 || summary.Duplicate != 1 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	if rows := len(plan.Table().Rows); rows != 5 {
+		t.Fatalf("expected 5 rows in the dry run table, got %d", rows)
+	}
+
+	// Rows planned from an earlier file count as near-duplicates too
+	other := filepath.Join(dir, "other.csv")
+	fail(t, ioutil.WriteFile(other, []byte("DATE,DESCRIPTION,AMOUNT\n01/04/2018,PAYROLL ACME,2000\n"), 0600))
+	plan, err = PlanImport(pdb, []string{path, other}, "checking", "")
+	fail(t, err)
+	if row := plan.Rows[len(plan.Rows)-1]; row.Status != ImportNearDuplicate || row.Match.Memo != "salary" {
+		t.Fatalf("expected the payroll to be a near-duplicate of the planned salary, got %s %v", row.Status, row.Match)
+	}
+
+	// Planning writes nothing
+	fail(t, pdb.LoadCaches())
+	if count := len(pdb.AllTransactions()); count != 2 {
+		t.Fatalf("expected a dry run to leave 2 transactions, got %d", count)
+	}
+}
@@ -20,6 +20,7 @@ type Investment struct {
 	Shares         float64
 	Price          float64
 	Disambiguation string
+	Currency       string
 }
 
 type Holding struct {
@@ -70,6 +71,13 @@ func (holding *Holding) CurrentPrice(lookup *StockSymbolLookup) (float64, error)
 	return total, nil
 }
 
+func (holding *Holding) Currency() string {
+	if len(holding.Investments) == 0 {
+		return DefaultCurrency
+	}
+	return holding.Investments[0].Currency
+}
+
 func (holding *Holding) Key() string {
 	return fmt.Sprintf("%d-%s", holding.Account, holding.Symbol)
 }
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type LinkKind string
+
+const (
+	LinkRefundOf         LinkKind = "refund-of"
+	LinkReimbursementFor LinkKind = "reimbursement-for"
+	LinkFundedBy         LinkKind = "funded-by"
+)
+
+func ParseLinkKind(s string) (LinkKind, error) {
+	switch LinkKind(s) {
+	case LinkRefundOf, LinkReimbursementFor, LinkFundedBy:
+		return LinkKind(s), nil
+	}
+	return "", fmt.Errorf("invalid link kind %s", s)
+}
+
+// A Link says that the transaction with ID From is a refund of (or a
+// reimbursement for) the transaction with ID To.  Linked transactions are
+// counted against the category of the transaction they're linked to.  A
+// funded-by link says that From is the bank or card side of a PayPal or
+// Venmo payment To, and From isn't counted at all.
+type Link struct {
+	From string
+	To   string
+	Kind LinkKind
+}
+
+type Reimbursable struct {
+	Expense        *Transaction
+	Note           string
+	Reimbursements []*Transaction
+}
+
+func (r *Reimbursable) Reimbursed() float64 {
+	total := 0.0
+	for _, tx := range r.Reimbursements {
+		total += tx.Amount
+	}
+	return total
+}
+
+func (r *Reimbursable) Outstanding() float64 {
+	return -r.Expense.Amount - r.Reimbursed()
+}
+
+func (r *Reimbursable) Status() string {
+	if r.Outstanding() < 0.005 {
+		return "settled"
+	}
+	return "outstanding"
+}
+
+func (pdb *PennyDb) Links() []*Link {
+	pdb.mutex.RLock()
+	defer pdb.mutex.RUnlock()
+	return pdb.linkCache
+}
+
+func (pdb *PennyDb) SaveLink(link *Link) error {
+	return pdb.SaveLinks([]*Link{link})
+}
+
+// Saves links through one handle
+func (pdb *PennyDb) SaveLinks(links []*Link) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	for _, link := range links {
+		_, err = handle.Exec(`REPLACE INTO tx_link (from_id, to_id, kind) VALUES (?, ?, ?)`, link.From, link.To, string(link.Kind))
+		if err != nil {
+			return err
+		}
+	}
+
+	pdb.linkCache, err = handle.AllLinks()
+	return err
+}
+
+func (pdb *PennyDb) DeleteLink(from string) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	res, err := handle.Exec(`DELETE FROM tx_link WHERE from_id=?`, from)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows != 1 {
+		return fmt.Errorf("transaction %s is not linked", from)
+	}
+
+	pdb.linkCache, err = handle.AllLinks()
+	return err
+}
+
+func (pdb *PennyDb) MarkReimbursable(id, note string, reimbursable bool) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	if reimbursable {
+		_, err = handle.Exec(`REPLACE INTO reimbursable (tx_id, note) VALUES (?, ?)`, id, note)
+	} else {
+		_, err = handle.Exec(`DELETE FROM reimbursable WHERE tx_id=?`, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	pdb.reimbursableCache, err = handle.AllReimbursable()
+	return err
+}
+
+func (pdb *PennyDb) TransactionById(id string) (*Transaction, error) {
+	for _, tx := range pdb.AllTransactions() {
+		if tx.Id() == id {
+			return tx, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find transaction %s", id)
+}
+
+// Maps the ID of every linked refund or reimbursement to the transaction it
+// offsets
+func (pdb *PennyDb) LinkedOriginals() map[string]*Transaction {
+	links := pdb.Links()
+	if len(links) == 0 {
+		return nil
+	}
+
+	txById := make(map[string]*Transaction)
+	for _, tx := range pdb.AllTransactions() {
+		txById[tx.Id()] = tx
+	}
+
+	originals := make(map[string]*Transaction)
+	for _, link := range links {
+		if link.Kind == LinkFundedBy {
+			continue
+		}
+		if original, ok := txById[link.To]; ok {
+			originals[link.From] = original
+		}
+	}
+	return originals
+}
+
+// IDs of the bank and card transactions that funded a wallet payment
+func (pdb *PennyDb) FundingTransactions() map[string]bool {
+	funding := make(map[string]bool)
+	for _, link := range pdb.Links() {
+		if link.Kind == LinkFundedBy {
+			funding[link.From] = true
+		}
+	}
+	return funding
+}
+
+// Expenses explicitly marked as reimbursable, plus any expense that has a
+// reimbursement linked to it
+func (pdb *PennyDb) Reimbursables() []*Reimbursable {
+	pdb.mutex.RLock()
+	notes := pdb.reimbursableCache
+	links := pdb.linkCache
+	txs := pdb.txCache
+	pdb.mutex.RUnlock()
+
+	txById := make(map[string]*Transaction)
+	for _, tx := range txs {
+		txById[tx.Id()] = tx
+	}
+
+	byId := make(map[string]*Reimbursable)
+	for id, note := range notes {
+		if tx, ok := txById[id]; ok {
+			byId[id] = &Reimbursable{tx, note, nil}
+		}
+	}
+
+	for _, link := range links {
+		if link.Kind != LinkReimbursementFor {
+			continue
+		}
+		expense, ok := txById[link.To]
+		if !ok {
+			continue
+		}
+		reimbursement, ok := txById[link.From]
+		if !ok {
+			continue
+		}
+		if _, ok := byId[link.To]; !ok {
+			byId[link.To] = &Reimbursable{expense, "", nil}
+		}
+		byId[link.To].Reimbursements = append(byId[link.To].Reimbursements, reimbursement)
+	}
+
+	var reimbursables []*Reimbursable
+	for _, r := range byId {
+		reimbursables = append(reimbursables, r)
+	}
+	sort.Slice(reimbursables, func(i, j int) bool {
+		return reimbursables[i].Expense.Date.Before(reimbursables[j].Expense.Date)
+	})
+	return reimbursables
+}
+
+// Proposes refund-of links for incoming transactions that have the same payee
+// and amount as an earlier outgoing transaction
+func FindLinkCandidates(transactions []*Transaction, links []*Link, window time.Duration) []*Link {
+	linked := make(map[string]bool)
+	for _, link := range links {
+		linked[link.From] = true
+		linked[link.To] = true
+	}
+
+	byPayee := make(map[string][]*Transaction)
+	for _, tx := range transactions {
+		if tx.Amount < 0 && !linked[tx.Id()] {
+			byPayee[tx.Payee()] = append(byPayee[tx.Payee()], tx)
+		}
+	}
+
+	var candidates []*Link
+	for _, tx := range transactions {
+		if tx.Amount <= 0 || linked[tx.Id()] || len(tx.Payee()) == 0 {
+			continue
+		}
+
+		var best *Transaction
+		for _, original := range byPayee[tx.Payee()] {
+			if linked[original.Id()] || original.Date.After(tx.Date) || tx.Date.Sub(original.Date) > window {
+				continue
+			}
+			if math.Abs(original.Amount+tx.Amount) > 0.005 {
+				continue
+			}
+			if best == nil || original.Date.After(best.Date) {
+				best = original
+			}
+		}
+
+		if best != nil {
+			linked[tx.Id()] = true
+			linked[best.Id()] = true
+			candidates = append(candidates, &Link{tx.Id(), best.Id(), LinkRefundOf})
+		}
+	}
+	return candidates
+}
+
+func (handle *PennyDbHandle) AllLinks() ([]*Link, error) {
+	rows, err := handle.Query("SELECT from_id, to_id, kind FROM tx_link ORDER BY from_id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*Link
+	for rows.Next() {
+		var link Link
+		var kind string
+		err = rows.Scan(&link.From, &link.To, &kind)
+		if err != nil {
+			return nil, err
+		}
+		link.Kind, err = ParseLinkKind(kind)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, &link)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (handle *PennyDbHandle) AllReimbursable() (map[string]string, error) {
+	rows, err := handle.Query("SELECT tx_id, note FROM reimbursable;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := make(map[string]string)
+	for rows.Next() {
+		var id, note string
+		err = rows.Scan(&id, &note)
+		if err != nil {
+			return nil, err
+		}
+		notes[id] = note
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
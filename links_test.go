@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLinkedRefundOffsetsCategory(t *testing.T) {
+	dbPath := tempFilePath()
+	defer os.Remove(dbPath)
+
+	pdb, err := NewPennyDb(dbPath, NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	err = pdb.LoadCaches()
+	fail(t, err)
+
+	purchase := Transaction{"chase", date("Jan 1 2018"), "POS TARGET 1234", -40, "", "USD", "household", false}
+	refund := Transaction{"chase", date("Jan 9 2018"), "TARGET 5678", 40, "", "USD", "", false}
+	other := Transaction{"chase", date("Jan 9 2018"), "WALMART", 40, "", "USD", "", false}
+
+	err = pdb.Insert([]*Transaction{&purchase, &refund, &other})
+	fail(t, err)
+
+	candidates := FindLinkCandidates(pdb.AllTransactions(), pdb.Links(), 30*24*time.Hour)
+	if len(candidates) != 1 || candidates[0].From != refund.Id() || candidates[0].To != purchase.Id() {
+		t.Fatalf("expecting refund %s to be linked to %s, got %v", refund.Id(), purchase.Id(), candidates)
+	}
+
+	fail(t, pdb.SaveLinks(candidates))
+
+	for _, summary := range pdb.DefaultSlice().CategorySummaries() {
+		if summary.Category == "household" && summary.Total != 0 {
+			t.Fatalf("expecting refund to offset the household category, got %.2f", summary.Total)
+		}
+	}
+
+	if FindLinkCandidates(pdb.AllTransactions(), pdb.Links(), 30*24*time.Hour) != nil {
+		t.Fatalf("expecting linked transactions to not be proposed again")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Displayed as ✓ for included transactions and ✘ for ignored ones
+type ignoredMark bool
+
+func (mark ignoredMark) String() string {
+	if mark {
+		return "✘"
+	}
+	return "✓"
+}
+
+func (mark ignoredMark) Raw() interface{} {
+	return bool(mark)
+}
+
+var listColumns = map[string]func(slice *TxSlice, tx *Transaction) interface{}{
+	"ignored":  func(slice *TxSlice, tx *Transaction) interface{} { return ignoredMark(tx.Ignored) },
+	"id":       func(slice *TxSlice, tx *Transaction) interface{} { return tx.Id() },
+	"source":   func(slice *TxSlice, tx *Transaction) interface{} { return tx.Source },
+	"account":  func(slice *TxSlice, tx *Transaction) interface{} { return slice.tableRow(tx)[1] },
+	"date":     func(slice *TxSlice, tx *Transaction) interface{} { return Date(tx.Date) },
+	"amount":   func(slice *TxSlice, tx *Transaction) interface{} { return Money{tx.Amount, tx.Currency} },
+	"category": func(slice *TxSlice, tx *Transaction) interface{} { return tx.Category },
+	"memo":     func(slice *TxSlice, tx *Transaction) interface{} { return tx.Memo },
+	"payee":    func(slice *TxSlice, tx *Transaction) interface{} { return tx.Payee() },
+	"currency": func(slice *TxSlice, tx *Transaction) interface{} { return tx.Currency },
+	"kind":     func(slice *TxSlice, tx *Transaction) interface{} { return string(slice.Classifier().Classify(tx)) },
+	"tags": func(slice *TxSlice, tx *Transaction) interface{} {
+		if slice.db == nil {
+			return ""
+		}
+		return strings.Join(slice.db.Tags(tx.Id()), ",")
+	},
+	"note": func(slice *TxSlice, tx *Transaction) interface{} {
+		if slice.db == nil {
+			return ""
+		}
+		return slice.db.Note(tx.Id())
+	},
+}
+
+var DefaultListColumns = []string{"ignored", "account", "date", "amount", "category", "memo"}
+
+var listGroups = map[string]func(slice *TxSlice, tx *Transaction) string{
+	"month":    func(slice *TxSlice, tx *Transaction) string { return tx.Date.Format("2006-01") },
+	"category": func(slice *TxSlice, tx *Transaction) string { return categoryName(tx.Category) },
+	"source":   func(slice *TxSlice, tx *Transaction) string { return slice.tableRow(tx)[1] },
+	"payee":    func(slice *TxSlice, tx *Transaction) string { return tx.Payee() },
+	"week": func(slice *TxSlice, tx *Transaction) string {
+		year, week := tx.Date.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	},
+}
+
+var listSorts = map[string]func(a, b *Transaction) bool{
+	"date":   func(a, b *Transaction) bool { return a.Date.Before(b.Date) },
+	"amount": func(a, b *Transaction) bool { return a.Amount < b.Amount },
+	"memo":   func(a, b *Transaction) bool { return strings.ToLower(a.Memo) < strings.ToLower(b.Memo) },
+}
+
+type ListOptions struct {
+	GroupBy    string
+	Sort       string
+	Descending bool
+	Columns    []string
+}
+
+// Parses the --group-by, --sort (e.g. amount:desc) and --columns flags of
+// the list command.  Empty values select the defaults.
+func ParseListOptions(groupBy, sortBy, columns string) (*ListOptions, error) {
+	options := &ListOptions{GroupBy: strings.ToLower(groupBy), Sort: "date", Columns: DefaultListColumns}
+
+	if _, ok := listGroups[options.GroupBy]; len(options.GroupBy) > 0 && !ok {
+		return nil, fmt.Errorf("invalid group %s (expecting month, week, category, source or payee)", groupBy)
+	}
+
+	if len(sortBy) > 0 {
+		parts := strings.SplitN(strings.ToLower(sortBy), ":", 2)
+		if _, ok := listSorts[parts[0]]; !ok {
+			return nil, fmt.Errorf("invalid sort %s (expecting amount, date or memo)", parts[0])
+		}
+		options.Sort = parts[0]
+		if len(parts) == 2 {
+			switch parts[1] {
+			case "asc":
+			case "desc":
+				options.Descending = true
+			default:
+				return nil, fmt.Errorf("invalid sort direction %s (expecting asc or desc)", parts[1])
+			}
+		}
+	}
+
+	if len(columns) > 0 {
+		options.Columns = nil
+		for _, column := range strings.Split(strings.ToLower(columns), ",") {
+			column = strings.TrimSpace(column)
+			if _, ok := listColumns[column]; !ok {
+				var valid []string
+				for name := range listColumns {
+					valid = append(valid, name)
+				}
+				sort.Strings(valid)
+				return nil, fmt.Errorf("invalid column %s (expecting %s)", column, strings.Join(valid, ", "))
+			}
+			options.Columns = append(options.Columns, column)
+		}
+	}
+
+	return options, nil
+}
+
+type TxGroup struct {
+	Key   string
+	Slice *TxSlice
+}
+
+// Splits the slice into groups ordered by key, each sorted by options.Sort.
+// Without a GroupBy there's a single group with an empty key.
+func (slice *TxSlice) Groups(options *ListOptions) []TxGroup {
+	byKey := make(map[string][]*Transaction)
+	var keys []string
+	for _, tx := range slice.transactions {
+		key := ""
+		if group, ok := listGroups[options.GroupBy]; ok {
+			key = group(slice, tx)
+		}
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], tx)
+	}
+	sort.Strings(keys)
+
+	less := listSorts[options.Sort]
+	if less == nil {
+		less = listSorts["date"]
+	}
+
+	var groups []TxGroup
+	for _, key := range keys {
+		txs := byKey[key]
+		sort.SliceStable(txs, func(i, j int) bool {
+			if options.Descending {
+				return less(txs[j], txs[i])
+			}
+			return less(txs[i], txs[j])
+		})
+		groups = append(groups, TxGroup{key, &TxSlice{txs, slice.db}})
+	}
+	return groups
+}
+
+// Machine-readable form of WriteList with one row per transaction.  Each
+// column's JSON key is its name, and grouped lists add a "group" column.
+func (slice *TxSlice) ListTable(options *ListOptions) *Table {
+	header := func(name string) string {
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+
+	table := &Table{Name: "transactions"}
+	if len(options.GroupBy) > 0 {
+		table.Columns = append(table.Columns, Column{header(options.GroupBy), "group"})
+	}
+	for _, column := range options.Columns {
+		table.Columns = append(table.Columns, Column{header(column), column})
+	}
+
+	for _, group := range slice.Groups(options) {
+		for _, tx := range group.Slice.transactions {
+			var row []interface{}
+			if len(options.GroupBy) > 0 {
+				row = append(row, group.Key)
+			}
+			for _, column := range options.Columns {
+				row = append(row, listColumns[column](slice, tx))
+			}
+			table.Rows = append(table.Rows, row)
+		}
+	}
+	return table
+}
+
+func (slice *TxSlice) WriteList(writer io.Writer, options *ListOptions) {
+	groups := slice.Groups(options)
+
+	var renderer Renderer
+	cell := func(column string, tx *Transaction) string {
+		return renderer.humanCell(listColumns[column](slice, tx), false)
+	}
+
+	maxColumnWidth := make(map[int]int)
+	for _, tx := range slice.transactions {
+		for col, column := range options.Columns {
+			if width := len(cell(column, tx)); width > maxColumnWidth[col] {
+				maxColumnWidth[col] = width
+			}
+		}
+	}
+
+	for index, group := range groups {
+		if len(options.GroupBy) > 0 {
+			if index > 0 {
+				io.WriteString(writer, "\n")
+			}
+			io.WriteString(writer, fmt.Sprintf("%s\n", strings.ToUpper(group.Key)))
+		}
+
+		for _, tx := range group.Slice.transactions {
+			var row string
+			for col, column := range options.Columns {
+				padded := PadRight(cell(column, tx), " ", maxColumnWidth[col]+1)
+				if column == "amount" {
+					if tx.Amount > 0 {
+						padded = green(padded)
+					} else {
+						padded = red(padded)
+					}
+				}
+				row += padded
+			}
+			io.WriteString(writer, row+"\n")
+		}
+
+		if len(options.GroupBy) > 0 {
+			// Subtotals follow the same classification as WriteHumanReadableTotals
+			totals := group.Slice.Totals()
+			base := DefaultCurrency
+			if slice.db != nil {
+				base = slice.db.BaseCurrency()
+			}
+			io.WriteString(writer, fmt.Sprintf(
+				"%d transactions, income %s, expenses %s, investments %s, net %s\n",
+				len(group.Slice.transactions),
+				moneyIn(totals.Income, base, true),
+				moneyIn(totals.Expenses, base, true),
+				moneyIn(totals.Investments, base, true),
+				moneyIn(totals.Income+totals.Expenses, base, true),
+			))
+		}
+	}
+}
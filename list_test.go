@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestListGroupsAndSort(t *testing.T) {
+	tx1 := Transaction{"dcu", date("Jan 2 2018"), "PAYROLL", 1000, "", "USD", "income", false}
+	tx2 := Transaction{"dcu", date("Jan 3 2018"), "GROCERY", -50, "", "USD", "food", false}
+	tx3 := Transaction{"dcu", date("Feb 3 2018"), "CARD PAYMENT", -200, "", "USD", "payoff", false}
+	tx4 := Transaction{"dcu", date("Feb 9 2018"), "RESTAURANT", -75, "", "USD", "food", false}
+	slice := &TxSlice{[]*Transaction{&tx1, &tx2, &tx3, &tx4}, nil}
+
+	options, err := ParseListOptions("month", "amount:desc", "date,amount,memo")
+	fail(t, err)
+
+	groups := slice.Groups(options)
+	if len(groups) != 2 || groups[0].Key != "2018-01" || groups[1].Key != "2018-02" {
+		t.Fatalf("expecting groups 2018-01 and 2018-02, got %v", groups)
+	}
+
+	if feb := groups[1].Slice.transactions; feb[0] != &tx4 || feb[1] != &tx3 {
+		t.Fatalf("expecting February sorted by descending amount")
+	}
+
+	if totals := groups[1].Slice.Totals(); totals.Expenses != -75 {
+		t.Fatalf("expecting payoff to be excluded from the February subtotal, got %.2f", totals.Expenses)
+	}
+
+	var buf bytes.Buffer
+	slice.WriteList(&buf, options)
+	if !strings.HasPrefix(buf.String(), "2018-01\n") || !strings.Contains(buf.String(), "2 transactions") {
+		t.Fatalf("unexpected list output:\n%s", buf.String())
+	}
+
+	for _, invalid := range [][]string{{"year", "", ""}, {"", "size", ""}, {"", "date:up", ""}, {"", "", "date,bogus"}} {
+		if _, err := ParseListOptions(invalid[0], invalid[1], invalid[2]); err == nil {
+			t.Errorf("expecting %v to be rejected", invalid)
+		}
+	}
+}
@@ -7,46 +7,169 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/mitchellh/go-wordwrap"
-	"github.com/olekukonko/tablewriter"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func main() {
-	defaultStart := time.Now().Add(-365 * 24 * time.Hour).Format("01/02/2006")
-	defaultEnd := time.Now().Format("01/02/2006")
-
 	var (
 		app            = kingpin.New("penny", "A command-line day manager")
 		verbose        = app.Flag("verbose", "Verbose output").Short('v').Bool()
 		db             = app.Flag("db", "Path to database file").Default("penny.sqlite3.encrypted").String()
-		start          = app.Flag("start", "Start date (MM/DD/YYYY)").Default(defaultStart).String()
-		end            = app.Flag("end", "End date (MM/DD/YYYY)").Default(defaultEnd).String()
+		start          = app.Flag("start", "Start date, e.g. 01/02/2006, 2021-03-01 or 'since 2022-03' (default: 12 months ago)").String()
+		end            = app.Flag("end", "End date, e.g. 12/31/2021, 2021 or last-month (default: today)").String()
+		period         = app.Flag("period", "Date range, e.g. 2021, Q12021, ytd, last-month, last-12-months or 2021-01..2021-06").String()
 		categories     = app.Flag("category", "Filter by categories").String()
 		regexString    = app.Flag("regex", "Filter by regular expression").String()
+		viewName       = app.Flag("view", "Apply a saved view").String()
+		queryString    = app.Flag("query", "Filter by query, e.g. 'amount < -100 and source in (chase, dcu) and not category:payoff'").Short('q').String()
+		baseCurrency   = app.Flag("currency", "Currency that reports are converted to").Default(DefaultCurrency).Envar("PENNY_CURRENCY").String()
+		fxRates        = app.Flag("fx-rates", "CSV file of FX rates (date,from,to,rate) or 'frankfurter' to look them up online").Envar("PENNY_FX_RATES").String()
+		importerConf   = app.Flag("importers", "JSON file of CSV import profiles for banks without a built-in importer").Envar("PENNY_IMPORTERS").String()
+		importMap      = app.Flag("import-map", "JSON file renaming imported categories and account names, e.g. from Mint, YNAB or Actual").Envar("PENNY_IMPORT_MAP").String()
+		output         = app.Flag("output", "Output format: table, json, csv, tsv or markdown").Short('o').Default("table").Envar("PENNY_OUTPUT").String()
 		list           = app.Command("list", "List transactions")
+		listGroupBy    = list.Flag("group-by", "Group by month, week, category, source or payee with subtotals").String()
+		listSort       = list.Flag("sort", "Sort by amount, date or memo, optionally with :asc or :desc").String()
+		listColumns    = list.Flag("columns", "Comma-separated columns: "+strings.Join(DefaultListColumns, ",")+",id,source,payee,currency,kind,tags,note").String()
 		edit           = app.Command("edit", "Edit transactions")
-		importCmd      = app.Command("import", "Import transactions from raw CSV exports")
+		importCmd      = app.Command("import", "Import transactions from bank and brokerage exports")
+		importFiles    = importCmd.Arg("files", "Files or globs to import, e.g. 'exports/*.csv'").Required().Strings()
+		importSource   = importCmd.Flag("source", "Source of the imported transactions (default: the format's source)").String()
+		importDryRun   = importCmd.Flag("dry-run", "Show what would be imported without writing anything").Bool()
+		importFormat   = importCmd.Flag("format", "Format of the files instead of detecting it: "+strings.Join(ImporterNames(), ", ")).String()
+		amazon         = app.Command("amazon", "Note the items of Amazon orders on the card transactions they were charged as")
+		amazonFiles    = amazon.Arg("files", "Amazon order history CSV exports or globs").Required().Strings()
+		amazonWindow   = amazon.Flag("window", "Days after an order that it may be charged").Default("3").Int()
+		amazonCategory = amazon.Flag("categorize", "Set the proposed category on uncategorized transactions").Bool()
+		amazonDryRun   = amazon.Flag("dry-run", "Show the matches without writing anything").Bool()
+		importsCmd     = app.Command("imports", "Review and undo imports")
+		importsList    = importsCmd.Command("list", "List import batches")
+		importsRoll    = importsCmd.Command("rollback", "Delete the transactions of an import batch that haven't been edited since")
+		importsRollId  = importsRoll.Arg("batch", "Batch number from 'imports list'").Required().Int64()
+		exportCmd      = app.Command("export", "Export transactions and investments for other tools")
+		exportFormat   = exportCmd.Flag("format", "Export format: qif").Default("qif").String()
+		exportFile     = exportCmd.Flag("file", "File to write (default: standard output)").String()
 		markPayoffsCmd = app.Command("mark-payoffs", "Mark transactions that cancel each other into the 'payoffs' category")
 		decryptCmd     = app.Command("decrypt", "Decrypt a file")
 		encryptCmd     = app.Command("encrypt", "Encrypt a file")
 		report         = app.Command("report", "Generate Report")
+		reportBy       = report.Flag("by", "Summarize by week, month, quarter or year").Default("quarter").String()
+		reportFiscal   = report.Flag("fiscal-year-start", "First month of the fiscal year, e.g. 10 or october").Default("january").String()
+		reportHTML     = report.Flag("html", "Write the report to a self-contained HTML file").String()
 		investments    = app.Command("investments", "Show investment table")
+		pivot          = app.Command("pivot", "Show category totals by month or quarter")
+		pivotBy        = pivot.Flag("by", "Columns are weeks, months, quarters or years").Default("month").String()
+		pivotFiscal    = pivot.Flag("fiscal-year-start", "First month of the fiscal year, e.g. 10 or october").Default("january").String()
+		chart          = app.Command("chart", "Draw charts in the terminal")
+		chartBy        = chart.Flag("by", "Bars are weeks, months, quarters or years (default: month for expenses, quarter for savings)").String()
+		chartWidth     = chart.Flag("width", "Width of the longest bar").Default("50").Int()
+		chartASCII     = chart.Flag("ascii", "Draw with ASCII characters only (default when the locale isn't UTF-8)").Bool()
+		chartTop       = chart.Flag("categories", "Number of categories shown in stacked bars").Default("6").Int()
+		chartExpenses  = chart.Command("expenses", "Expenses per period split by category")
+		chartSavings   = chart.Command("savings", "Savings rate per period")
+		chartCats      = chart.Command("categories", "Total per category")
+		chartPortfolio = chart.Command("portfolio", "Current value of each holding")
+		compare        = app.Command("compare", "Compare category totals between periods")
+		comparePeriods = compare.Arg("periods", "Periods to compare, e.g. 2022 2021, or a single period to compare with previous years (default: last-12-months)").Strings()
+		compareYears   = compare.Flag("years", "Number of previous years to compare a single period with").Default("1").Int()
+		compareNorm    = compare.Flag("normalize", "Scale totals to the elapsed days of the latest period").Default("true").Bool()
+		compareMovers  = compare.Flag("movers", "Number of biggest movers to highlight").Default("5").Int()
 		sqlite         = app.Command("sqlite", "Get SQLite shell for database. CTRL-D to exit and save")
 		journal        = app.Command("journal", "Journal")
 		journalEdit    = journal.Command("edit", "Edit today's entry")
-		journalEditDay = journalEdit.Arg("editDay", "MM/DD/YYYY of day to edit").String()
+		journalEditDay = journalEdit.Arg("editDay", "Day to edit, e.g. 01/02/2006, 2021-03-01 or yesterday").String()
 		journalShow    = journal.Command("show", "Show journal entry")
-		journalShowDay = journalShow.Arg("showDay", "MM/DD/YYYY of day to edit").String()
+		journalShowDay = journalShow.Arg("showDay", "Day to show, e.g. 01/02/2006, 2021-03-01 or yesterday").String()
+		classify       = app.Command("classify", "Manage how categories and memos are classified")
+		classifyList   = classify.Command("list", "List classification rules")
+		classifySet    = classify.Command("set", "Classify a category or memo as income, expense, transfer, investment or excluded")
+		classifyField  = classifySet.Flag("memo", "Match memos containing the pattern instead of the category").Bool()
+		classifyPat    = classifySet.Arg("pattern", "Category name (or memo substring with --memo)").Required().String()
+		classifyKind   = classifySet.Arg("kind", "income, expense, transfer, investment or excluded").Required().String()
+		classifyRemove = classify.Command("remove", "Remove a classification rule")
+		classifyRmMemo = classifyRemove.Flag("memo", "Remove a memo rule instead of a category rule").Bool()
+		classifyRmPat  = classifyRemove.Arg("pattern", "Category name (or memo substring with --memo)").Required().String()
+		link           = app.Command("link", "Link refunds, reimbursements and wallet payments to related transactions")
+		linkRefund     = link.Command("refund", "Mark a transaction as a refund of another")
+		linkRefundFrom = linkRefund.Arg("refund", "ID of the refund").Required().String()
+		linkRefundTo   = linkRefund.Arg("original", "ID of the original purchase").Required().String()
+		linkReimb      = link.Command("reimbursement", "Mark a transaction as a reimbursement for an expense")
+		linkReimbFrom  = linkReimb.Arg("reimbursement", "ID of the reimbursement").Required().String()
+		linkReimbTo    = linkReimb.Arg("expense", "ID of the reimbursed expense").Required().String()
+		linkRemove     = link.Command("remove", "Remove the link from a refund or reimbursement")
+		linkRemoveId   = linkRemove.Arg("id", "ID of the refund or reimbursement").Required().String()
+		linkList       = link.Command("list", "List linked transactions")
+		linkCandidates = link.Command("candidates", "Detect refunds by payee and amount")
+		linkApply      = linkCandidates.Flag("apply", "Save the detected links").Bool()
+		linkWindow     = linkCandidates.Flag("days", "Maximum days between purchase and refund").Default("90").Int()
+		linkFunding    = link.Command("funding", "Link PayPal and Venmo payments to the bank or card transactions that funded them")
+		reimb          = app.Command("reimbursable", "Track expenses that are owed back to us")
+		reimbMark      = reimb.Command("mark", "Mark an expense as reimbursable")
+		reimbMarkId    = reimbMark.Arg("id", "ID of the expense").Required().String()
+		reimbMarkNote  = reimbMark.Flag("note", "Who owes us").String()
+		reimbUnmark    = reimb.Command("unmark", "Mark an expense as not reimbursable")
+		reimbUnmarkId  = reimbUnmark.Arg("id", "ID of the expense").Required().String()
+		reimbReport    = reimb.Command("report", "Show what we are still owed")
+		reimbAll       = reimbReport.Flag("all", "Include settled expenses").Bool()
+		balance        = app.Command("balance", "Record opening balances and statement balances per source")
+		balanceOpen    = balance.Command("open", "Set the opening balance of a source")
+		balanceOpenSrc = balanceOpen.Arg("source", "Transaction source").Required().String()
+		balanceOpenDay = balanceOpen.Flag("date", "MM/DD/YYYY of the opening balance").Required().String()
+		balanceOpenAmt = balanceOpen.Flag("balance", "Balance before any transactions on that day").Required().Float64()
+		balanceAssert  = balance.Command("assert", "Record a statement balance for a source")
+		balanceAsrtSrc = balanceAssert.Arg("source", "Transaction source").Required().String()
+		balanceAsrtDay = balanceAssert.Flag("date", "MM/DD/YYYY of the statement").Required().String()
+		balanceAsrtAmt = balanceAssert.Flag("balance", "Balance at the end of that day").Required().Float64()
+		balanceList    = balance.Command("list", "List recorded balances")
+		reconcile      = app.Command("reconcile", "Compare the running balance of a source against statement balances")
+		reconcileSrc   = reconcile.Arg("source", "Transaction source").Required().String()
+		reconcileDay   = reconcile.Flag("date", "MM/DD/YYYY of the statement (default: check every recorded assertion)").String()
+		reconcileAmt   = reconcile.Flag("balance", "Statement balance at the end of --date").String()
+		accounts       = app.Command("accounts", "Manage the account registry")
+		accountsList   = accounts.Command("list", "List accounts")
+		accountsSet    = accounts.Command("set", "Add or update an account")
+		accountsSetId  = accountsSet.Arg("id", "Transaction source or brokerage account number").Required().String()
+		accountsName   = accountsSet.Flag("name", "Display name").String()
+		accountsInst   = accountsSet.Flag("institution", "Bank or brokerage").String()
+		accountsType   = accountsSet.Flag("type", "checking, credit, brokerage, 401k, ira or hsa").String()
+		accountsOwner  = accountsSet.Flag("owner", "Account owner").String()
+		accountsTax    = accountsSet.Flag("tax", "taxable, tax-deferred or tax-free").String()
+		accountsCurr   = accountsSet.Flag("account-currency", "Currency of transactions in this account (e.g. EUR)").String()
+		accountsClose  = accounts.Command("close", "Mark an account as closed")
+		accountsClsId  = accountsClose.Arg("id", "Account ID").Required().String()
+		accountsReopen = accounts.Command("reopen", "Mark a closed account as open")
+		accountsOpnId  = accountsReopen.Arg("id", "Account ID").Required().String()
+		viewCmd        = app.Command("view", "Manage saved views")
+		viewSave       = viewCmd.Command("save", "Save the current --query and --period as a named view")
+		viewSaveName   = viewSave.Arg("name", "Name of the view").Required().String()
+		viewGroupBy    = viewSave.Flag("group-by", "Grouping for list").String()
+		viewColumns    = viewSave.Flag("columns", "Columns for list").String()
+		viewSort       = viewSave.Flag("sort", "Sort order for list").String()
+		viewList       = viewCmd.Command("list", "List saved views")
+		viewDelete     = viewCmd.Command("delete", "Delete a saved view")
+		viewDeleteName = viewDelete.Arg("name", "Name of the view").Required().String()
+		tag            = app.Command("tag", "Add or remove tags on a transaction")
+		tagId          = tag.Arg("id", "Transaction ID").Required().String()
+		tagNames       = tag.Arg("tags", "Tags to add").Strings()
+		tagRemove      = tag.Flag("remove", "Tag to remove").Strings()
+		note           = app.Command("note", "Show or change the note on a transaction")
+		noteId         = note.Arg("id", "Transaction ID").Required().String()
+		noteText       = note.Arg("note", "New note").String()
+		noteClear      = note.Flag("clear", "Remove the note").Bool()
 		test           = app.Command("test", "test")
 	)
 
 	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	if strings.HasPrefix(command, chart.FullCommand()+" ") && *chartWidth < 1 {
+		app.Fatalf("--width must be at least 1, got %d", *chartWidth)
+	}
 
 	key := []byte(os.Getenv("PENNY_SECRET_KEY"))
 
@@ -96,19 +219,376 @@ func main() {
 		os.Exit(0)
 	}
 
+	renderer, err := NewRenderer(*output, os.Stdout)
+	check(err)
+	defer func() { check(renderer.Flush()) }()
+
+	// Escape codes are only useful to a person looking at a terminal
+	colorEnabled = renderer.Format() == "table" && len(os.Getenv("NO_COLOR")) == 0 && isTerminal(os.Stdout)
+
 	pdb, err := NewPennyDb(*db, log, key)
 	check(err)
 
 	err = pdb.LoadCaches()
 	check(err)
 
+	fxProvider, err := NewFxProvider(*fxRates)
+	check(err)
+	pdb.SetFxConverter(NewFxConverter(pdb, *baseCurrency, fxProvider))
+	defer pdb.WarnUnconverted(os.Stderr)
+
+	if len(*importerConf) > 0 {
+		check(LoadCsvProfiles(*importerConf))
+	}
+	if len(*importMap) > 0 {
+		check(LoadImportRenames(*importMap))
+	}
+
 	switch command {
 	case test.FullCommand():
 		fmt.Printf("test\n")
+	case classifyList.FullCommand():
+		table := &Table{Name: "rules", Columns: []Column{{"Field", "field"}, {"Pattern", "pattern"}, {"Kind", "kind"}}}
+		for _, rule := range pdb.Classifier().Rules() {
+			table.Append(rule.Field, rule.Pattern, string(rule.Kind))
+		}
+		renderer.Render(table)
+		return
+	case classifySet.FullCommand():
+		kind, err := ParseKind(*classifyKind)
+		check(err)
+		field := "category"
+		if *classifyField {
+			field = "memo"
+		}
+		check(pdb.SaveClassificationRule(ClassificationRule{field, *classifyPat, kind}))
+		return
+	case classifyRemove.FullCommand():
+		field := "category"
+		if *classifyRmMemo {
+			field = "memo"
+		}
+		check(pdb.DeleteClassificationRule(field, *classifyRmPat))
+		return
+	case linkRefund.FullCommand(), linkReimb.FullCommand():
+		link := &Link{*linkRefundFrom, *linkRefundTo, LinkRefundOf}
+		if command == linkReimb.FullCommand() {
+			link = &Link{*linkReimbFrom, *linkReimbTo, LinkReimbursementFor}
+		}
+		_, err := pdb.TransactionById(link.From)
+		check(err)
+		_, err = pdb.TransactionById(link.To)
+		check(err)
+		check(pdb.SaveLink(link))
+		return
+	case linkFunding.FullCommand():
+		links, err := pdb.LinkFundingSources()
+		check(err)
+		fmt.Fprintf(os.Stderr, "Linked %d PayPal/Venmo payments to their funding transactions\n", len(links))
+		return
+	case linkRemove.FullCommand():
+		check(pdb.DeleteLink(*linkRemoveId))
+		return
+	case linkList.FullCommand():
+		table := &Table{Name: "links", Columns: []Column{
+			{"ID", "from_id"}, {"Date", "from_date"}, {"Amount", "from_amount"}, {"Memo", "from_memo"},
+			{"Link", "kind"},
+			{"ID", "to_id"}, {"Date", "to_date"}, {"Amount", "to_amount"}, {"Category", "to_category"}, {"Memo", "to_memo"},
+		}}
+		for _, link := range pdb.Links() {
+			from, err := pdb.TransactionById(link.From)
+			check(err)
+			to, err := pdb.TransactionById(link.To)
+			check(err)
+			table.Append(
+				from.Id(), Date(from.Date), Money{from.Amount, from.Currency}, from.Memo,
+				string(link.Kind),
+				to.Id(), Date(to.Date), Money{to.Amount, to.Currency}, to.Category, to.Memo,
+			)
+		}
+		renderer.Render(table)
+		return
+	case accountsList.FullCommand():
+		table := &Table{Name: "accounts", Columns: []Column{
+			{"ID", "id"},
+			{"Name", "name"},
+			{"Institution", "institution"},
+			{"Type", "type"},
+			{"Owner", "owner"},
+			{"Tax Treatment", "tax_treatment"},
+			{"Currency", "currency"},
+			{"Status", "status"},
+		}}
+		for _, account := range pdb.Accounts() {
+			table.Append(
+				account.Id,
+				account.Name,
+				account.Institution,
+				string(account.Type),
+				account.Owner,
+				account.TaxTreatment,
+				account.Currency,
+				account.Status(),
+			)
+		}
+		renderer.Render(table)
+		return
+	case accountsSet.FullCommand():
+		account := &Account{Id: *accountsSetId}
+		if existing := pdb.Account(*accountsSetId); existing != nil {
+			copy := *existing
+			account = &copy
+		}
+		if len(*accountsName) > 0 {
+			account.Name = *accountsName
+		}
+		if len(*accountsInst) > 0 {
+			account.Institution = *accountsInst
+		}
+		if len(*accountsType) > 0 {
+			account.Type, err = ParseAccountType(*accountsType)
+			check(err)
+		}
+		if len(*accountsOwner) > 0 {
+			account.Owner = *accountsOwner
+		}
+		if len(*accountsCurr) > 0 {
+			account.Currency = strings.ToUpper(*accountsCurr)
+		}
+		if len(*accountsTax) > 0 {
+			account.TaxTreatment, err = ParseTaxTreatment(*accountsTax)
+			check(err)
+		}
+		check(pdb.SaveAccount(account))
+		return
+	case accountsClose.FullCommand(), accountsReopen.FullCommand():
+		id := *accountsClsId
+		if command == accountsReopen.FullCommand() {
+			id = *accountsOpnId
+		}
+		existing := pdb.Account(id)
+		if existing == nil {
+			check(fmt.Errorf("no account with ID %s", id))
+		}
+		account := *existing
+		account.Closed = command == accountsClose.FullCommand()
+		check(pdb.SaveAccount(&account))
+		return
+	case viewSave.FullCommand():
+		if len(*period) > 0 {
+			_, err := ParseDateRange(*period)
+			check(err)
+		}
+		check(pdb.SaveView(&View{*viewSaveName, *queryString, *period, *viewGroupBy, *viewColumns, *viewSort}))
+		return
+	case viewList.FullCommand():
+		views, err := pdb.Views()
+		check(err)
+		table := &Table{Name: "views", Columns: []Column{
+			{"Name", "name"}, {"Query", "query"}, {"Period", "period"}, {"Group By", "group_by"}, {"Columns", "columns"}, {"Sort", "sort"},
+		}}
+		for _, view := range views {
+			table.Append(view.Name, view.Query, view.Period, view.GroupBy, view.Columns, view.Sort)
+		}
+		renderer.Render(table)
+		return
+	case viewDelete.FullCommand():
+		check(pdb.DeleteView(*viewDeleteName))
+		return
+	case tag.FullCommand():
+		_, err := pdb.TransactionById(*tagId)
+		check(err)
+		check(pdb.SaveTags(*tagId, *tagNames, *tagRemove))
+		table := NewKeyValueTable("tags", "")
+		table.Append("ID", *tagId)
+		table.Append("Tags", strings.Join(pdb.Tags(*tagId), ", "))
+		renderer.Render(table)
+		return
+	case note.FullCommand():
+		_, err := pdb.TransactionById(*noteId)
+		check(err)
+		if len(*noteText) > 0 || *noteClear {
+			check(pdb.SaveNote(*noteId, *noteText))
+		}
+		table := NewKeyValueTable("note", "")
+		table.Append("ID", *noteId)
+		table.Append("Note", pdb.Note(*noteId))
+		renderer.Render(table)
+		return
+	case balanceOpen.FullCommand(), balanceAssert.FullCommand():
+		source, day, amount, kind := *balanceOpenSrc, *balanceOpenDay, *balanceOpenAmt, BalanceOpening
+		if command == balanceAssert.FullCommand() {
+			source, day, amount, kind = *balanceAsrtSrc, *balanceAsrtDay, *balanceAsrtAmt, BalanceAssertion
+		}
+		date, err := ParseDate(day)
+		check(err)
+		check(pdb.SaveBalance(&Balance{source, date, amount, kind}))
+		return
+	case balanceList.FullCommand():
+		balances, err := pdb.Balances()
+		check(err)
+		table := &Table{Name: "balances", Columns: []Column{{"Source", "source"}, {"Date", "date"}, {"Kind", "kind"}, {"Balance", "balance"}}}
+		for _, balance := range balances {
+			table.Append(balance.Source, Date(balance.Date), string(balance.Kind), Money{balance.Balance, pdb.AccountCurrency(balance.Source)})
+		}
+		renderer.Render(table)
+		return
+	case reconcile.FullCommand():
+		balances, err := pdb.Balances()
+		check(err)
+
+		if (len(*reconcileDay) > 0) != (len(*reconcileAmt) > 0) {
+			check(fmt.Errorf("--date and --balance must be given together"))
+		}
+
+		var assertions []*Balance
+		if len(*reconcileDay) > 0 {
+			date, err := ParseDate(*reconcileDay)
+			check(err)
+			amount, err := strconv.ParseFloat(*reconcileAmt, 64)
+			check(err)
+			assertions = append(assertions, &Balance{*reconcileSrc, date, amount, BalanceAssertion})
+		} else {
+			for _, balance := range balances {
+				if balance.Source == *reconcileSrc && balance.Kind == BalanceAssertion {
+					assertions = append(assertions, balance)
+				}
+			}
+		}
+
+		if len(assertions) == 0 {
+			fmt.Fprintf(os.Stderr, "No balance assertions for %s (use --date and --balance)\n", *reconcileSrc)
+			return
+		}
+
+		var reconciliations []*Reconciliation
+		for _, assertion := range assertions {
+			reconciliations = append(reconciliations, Reconcile(*reconcileSrc, assertion.Date, assertion.Balance, pdb.AllTransactions(), balances))
+		}
+		for _, table := range ReconciliationTables(pdb, reconciliations) {
+			if len(table.Rows) > 0 || renderer.Format() != "table" {
+				renderer.Render(table)
+			}
+		}
+		return
+	case chartPortfolio.FullCommand():
+		lookup, err := NewStockSymbolLookup(pdb)
+		check(err)
+
+		base := pdb.BaseCurrency()
+		portfolio := &BarChart{Title: "portfolio", Format: func(v float64) string { return moneyIn(v, base, false) }}
+		for _, holding := range pdb.GroupedInvestments() {
+			value, err := holding.CurrentPrice(lookup)
+			check(err)
+			value, ok := pdb.InBaseCurrency(value, holding.Currency(), time.Now())
+			if !ok {
+				continue
+			}
+			portfolio.Labels = append(portfolio.Labels, fmt.Sprintf("%s %s", pdb.AccountName(fmt.Sprintf("%d", holding.Account)), holding.Symbol))
+			portfolio.Values = append(portfolio.Values, value)
+		}
+
+		if renderer.Format() == "table" {
+			portfolio.Write(os.Stdout, chartStyle(*chartASCII), *chartWidth)
+		} else {
+			renderer.Render(portfolio.Table("portfolio"))
+		}
+		return
+	case compare.FullCommand():
+		raw := RawFilter{*categories, *regexString, *queryString, "", "", ""}
+		if len(*viewName) > 0 {
+			view, err := pdb.View(*viewName)
+			check(err)
+			raw.Query = view.Apply(raw).Query
+		}
+		filter, errors := ParseFilter(raw)
+		if len(errors) != 0 {
+			for k, v := range errors {
+				fmt.Fprintf(os.Stderr, "ERROR: %s: %s", k, v)
+			}
+			os.Exit(1)
+		}
+
+		expressions := *comparePeriods
+		if len(expressions) == 0 {
+			expressions = []string{"last-12-months"}
+		}
+
+		var ranges []DateRange
+		for _, expression := range expressions {
+			r, err := ParseDateRange(expression)
+			check(err)
+			ranges = append(ranges, r)
+		}
+		if len(ranges) == 1 {
+			for years := 1; years <= *compareYears; years++ {
+				ranges = append(ranges, ShiftYears(ranges[0], -years))
+			}
+		}
+		if len(ranges) < 2 {
+			check(fmt.Errorf("expecting at least two periods to compare"))
+		}
+		sort.Slice(ranges, func(i, j int) bool {
+			return ranges[i].Start.Before(ranges[j].Start)
+		})
+
+		var labels []string
+		var slices []*TxSlice
+		for _, r := range ranges {
+			labels = append(labels, rangeLabel(r))
+			slices = append(slices, pdb.Slice(&Filter{filter.Categories, filter.Regex, filter.Query, r.Start, r.End}))
+		}
+
+		for _, table := range NewComparison(labels, slices, *compareNorm).Tables(pdb.BaseCurrency(), *compareMovers) {
+			renderer.Render(table)
+		}
+		return
+	case reimbMark.FullCommand():
+		_, err := pdb.TransactionById(*reimbMarkId)
+		check(err)
+		check(pdb.MarkReimbursable(*reimbMarkId, *reimbMarkNote, true))
+		return
+	case reimbUnmark.FullCommand():
+		check(pdb.MarkReimbursable(*reimbUnmarkId, "", false))
+		return
+	case reimbReport.FullCommand():
+		table := &Table{Name: "reimbursables", Columns: []Column{
+			{"ID", "id"},
+			{"Date", "date"},
+			{"Memo", "memo"},
+			{"Note", "note"},
+			{"Expense", "expense"},
+			{"Reimbursed", "reimbursed"},
+			{"Outstanding", "outstanding"},
+			{"Status", "status"},
+		}}
+		base := pdb.BaseCurrency()
+		outstanding := 0.0
+		for _, r := range pdb.Reimbursables() {
+			if r.Status() == "settled" && !*reimbAll {
+				continue
+			}
+			if amount, ok := pdb.InBaseCurrency(r.Outstanding(), r.Expense.Currency, r.Expense.Date); ok {
+				outstanding += amount
+			}
+			table.Append(
+				r.Expense.Id(),
+				Date(r.Expense.Date),
+				r.Expense.Memo,
+				r.Note,
+				Money{r.Expense.Amount, r.Expense.Currency},
+				Money{r.Reimbursed(), r.Expense.Currency},
+				Money{r.Outstanding(), r.Expense.Currency},
+				r.Status(),
+			)
+		}
+		table.Footer = []interface{}{"TOTAL", nil, nil, nil, nil, nil, Money{outstanding, base}, nil}
+		renderer.Render(table)
+		return
 	case journalShow.FullCommand():
 		day := time.Now()
 		if len(*journalShowDay) > 0 {
-			day, err = time.Parse("01/02/2006", *journalShowDay)
+			day, err = ParseDate(*journalShowDay)
 			check(err)
 		}
 		handle, err := pdb.OpenReadWrite()
@@ -123,7 +603,7 @@ func main() {
 	case journalEdit.FullCommand():
 		day := time.Now()
 		if len(*journalEditDay) > 0 {
-			day, err = time.Parse("01/02/2006", *journalEditDay)
+			day, err = ParseDate(*journalEditDay)
 			check(err)
 		}
 		handle, err := pdb.OpenReadWrite()
@@ -154,124 +634,133 @@ func main() {
 
 		err = handle.SaveJournalEntry(day, string(contents))
 		check(err)
-	case report.FullCommand():
-		txs := pdb.AllTransactions()
-		year := txs[0].Date.Year()
-		quarter := monthToQuarter(int(txs[0].Date.Month()))
-		title := func(s string) string {
-			t := fmt.Sprintf("\n\n%s\n\n", strings.ToUpper(s))
-			fmt.Printf(t)
-			return t
-		}
-
-		var quarters Quarters
-
-		for {
-			start, end, err := quarterToDateRange(int(quarter), int(year))
-			check(err)
-
-			slice := pdb.Slice(&Filter{nil, nil, start, end})
-
-			if len(slice.transactions) == 0 {
-				break
+	case importsList.FullCommand():
+		batches, err := pdb.ImportBatches()
+		check(err)
+		renderer.Render(ImportBatchTable(batches))
+		return
+	case importsRoll.FullCommand():
+		rollback, err := pdb.RollbackBatch(*importsRollId)
+		check(err)
+		for _, table := range RollbackTables(*importsRollId, rollback) {
+			if len(table.Rows) > 0 || renderer.Format() != "table" {
+				renderer.Render(table)
 			}
+		}
+		return
+	case importCmd.FullCommand():
+		plan, err := PlanImport(pdb, *importFiles, *importSource, *importFormat)
+		check(err)
+		if *importDryRun {
+			renderer.Render(plan.Table())
+		} else {
+			check(plan.Apply(pdb))
+		}
+		renderer.Render(ImportSummaryTable(plan.Summaries))
+		if plan.Linked > 0 {
+			fmt.Fprintf(os.Stderr, "Linked %d PayPal/Venmo payments to their funding transactions\n", plan.Linked)
+		}
+		return
+	case amazon.FullCommand():
+		matches, err := PlanAmazonEnrichment(pdb, *amazonFiles, *amazonWindow)
+		check(err)
+		if !*amazonDryRun {
+			check(pdb.EnrichAmazon(matches, *amazonCategory))
+		}
+		renderer.Render(AmazonMatchTable(matches))
+		return
+	case report.FullCommand():
+		query, err := ParseQuery(*queryString)
+		check(err)
 
-			quarters = append(quarters, Quarter{quarter, year, slice})
+		granularity, err := ParseGranularity(*reportBy)
+		check(err)
+		fiscalYearStart, err := ParseFiscalYearStart(*reportFiscal)
+		check(err)
 
-			if quarter == 4 {
-				quarter = 1
-				year++
-			} else {
-				quarter++
-			}
+		periods := pdb.Periods(granularity, fiscalYearStart, query)
+		if len(periods) == 0 {
+			fmt.Fprintf(os.Stderr, "No transactions found\n")
+			return
 		}
 
+		// Every section is collected first so it can be written as a
+		// terminal report or an HTML file
+		var tables []*Table
+
 		stockLookup, err := NewStockSymbolLookup(pdb)
 		check(err)
 		investmentTotal := 0.0
 		for _, investment := range pdb.AllInvestments() {
 			currentPrice, err := stockLookup.Get(investment.Symbol)
 			check(err)
-			investmentTotal += investment.Shares * currentPrice
+			if currentValue, ok := pdb.InBaseCurrency(investment.Shares*currentPrice, investment.Currency, time.Now()); ok {
+				investmentTotal += currentValue
+			}
 		}
 
-		writer := os.Stdout
-
 		////////////////////////////////////////////////////////////////////////////////////////////
-		//// QUARTERLY SUMMARY
+		//// PERIOD SUMMARY
 		////////////////////////////////////////////////////////////////////////////////////////////
 
-		title("Quarterly Summary")
-		table := tablewriter.NewWriter(writer)
-		table.SetHeader([]string{
-			"Quarter",
-			"Income",
-			"Expenses",
-			"Investments",
-			"Savings Rate",
-		})
-
-		for _, q := range quarters {
-			table.Append([]string{
-				fmt.Sprintf("Q%d %d", q.quarter, q.year),
-				money(q.Income(), true),
-				money(q.Expenses(), true),
-				money(q.Investments(), true),
-				fmt.Sprintf("%.1f%%", q.SavingsRate()),
-			})
-			if q.quarter == 4 {
-				table.Append([]string{"", "", "", "", ""})
+		header := strings.ToUpper(string(granularity)[:1]) + string(granularity)[1:]
+		table := &Table{Name: "periods", Title: granularity.Title() + " Summary", Columns: []Column{
+			{header, "period"},
+			{"Income", "income"},
+			{"Expenses", "expenses"},
+			{"Investments", "investments"},
+			{"Savings Rate", "savings_rate"},
+		}}
+
+		for _, p := range periods {
+			table.Append(
+				p.Label(),
+				Money{p.Income(), pdb.BaseCurrency()},
+				Money{p.Expenses(), pdb.BaseCurrency()},
+				Money{p.Investments(), pdb.BaseCurrency()},
+				Percent(p.SavingsRate()),
+			)
+			if p.EndsFiscalYear() && granularity != GranularityYear && renderer.Format() == "table" {
+				table.Append(nil, nil, nil, nil, nil)
 			}
 		}
 
-		table.SetFooter([]string{
+		table.Footer = []interface{}{
 			"AVERAGE",
-			money(quarters.AvgIncome(), false),
-			money(quarters.AvgExpenses(), false),
-			money(quarters.AvgInvestments(), false),
-			fmt.Sprintf("%.1f%%", quarters.AvgSavingsRate()),
-		})
-		table.Render()
-		io.WriteString(writer, "\n")
+			Money{periods.AvgIncome(), pdb.BaseCurrency()},
+			Money{periods.AvgExpenses(), pdb.BaseCurrency()},
+			Money{periods.AvgInvestments(), pdb.BaseCurrency()},
+			Percent(periods.AvgSavingsRate()),
+		}
+		tables = append(tables, table)
+
+		////////////////////////////////////////////////////////////////////////////////////////////
+		//// CATEGORY SUMMARY
+		////////////////////////////////////////////////////////////////////////////////////////////
+
+		all := pdb.Slice(&Filter{nil, nil, query, periods[0].Start, periods[len(periods)-1].End})
+		if len(all.transactions) > 0 {
+			categories := all.TotalsTables()[1]
+			categories.Title = "categories"
+			tables = append(tables, categories)
+		}
 
 		////////////////////////////////////////////////////////////////////////////////////////////
 		//// INVESTMENT SUMMARY
 		////////////////////////////////////////////////////////////////////////////////////////////
 
-		title("investments")
 		cache, err := NewStockSymbolLookup(pdb)
 		check(err)
 
-		accountNames, err := pdb.DBBackedCache("accounts_cache", func(s string) (string, error) {
-			name := ""
-			switch s {
-			case "57103097":
-				name = "Traditional IRA (Deb)"
-			case "41535601":
-				name = "Traditional IRA (Deb)"
-			case "20405599":
-				name = "Roth (Deb)"
-			case "88939119":
-				name = "401k (Scott)"
-			case "32428283":
-				name = "Roth (Scott)"
-			case "49335127":
-				name = "Taxable (Scott+Deb)"
-			}
-			return name, nil
-		})
-		check(err)
-
-		table = tablewriter.NewWriter(writer)
-		table.SetHeader([]string{
-			"Account",
-			"Account Name",
-			"Symbol",
-			"Shares",
-			"Purchase",
-			"Value",
-			"Profit",
-		})
+		table = &Table{Name: "holdings", Title: "investments", Columns: []Column{
+			{"Account", "account"},
+			{"Account Name", "account_name"},
+			{"Symbol", "symbol"},
+			{"Shares", "shares"},
+			{"Purchase", "purchase"},
+			{"Value", "value"},
+			{"Profit", "profit"},
+		}}
 
 		var shares, purchase, value, profit float64
 		for _, holding := range pdb.GroupedInvestments() {
@@ -279,34 +768,37 @@ func main() {
 			currentValue, err := holding.CurrentPrice(cache)
 			check(err)
 
-			name, err := accountNames.GetWithTTL(fmt.Sprintf("%d", holding.Account), time.Hour*24*7)
-			check(err)
+			name := pdb.AccountName(fmt.Sprintf("%d", holding.Account))
 
 			shares += holding.Shares()
-			purchase += purchaseValue
-			value += currentValue
-			profit += (currentValue - purchaseValue)
+			basePurchaseValue, purchaseOk := pdb.InBaseCurrency(purchaseValue, holding.Currency(), time.Now())
+			baseCurrentValue, currentOk := pdb.InBaseCurrency(currentValue, holding.Currency(), time.Now())
+			if purchaseOk && currentOk {
+				purchase += basePurchaseValue
+				value += baseCurrentValue
+				profit += (baseCurrentValue - basePurchaseValue)
+			}
 
-			table.Append([]string{
+			table.Append(
 				fmt.Sprintf("%d", holding.Account),
 				name,
 				holding.Symbol,
-				fmt.Sprintf("%.2f", holding.Shares()),
-				money(purchaseValue, true),
-				money(currentValue, true),
-				money(currentValue-purchaseValue, true),
-			})
+				holding.Shares(),
+				Money{purchaseValue, holding.Currency()},
+				Money{currentValue, holding.Currency()},
+				Money{currentValue - purchaseValue, holding.Currency()},
+			)
 		}
-		table.SetFooter([]string{
+		table.Footer = []interface{}{
 			"TOTAL",
-			"-",
-			"-",
-			fmt.Sprintf("%.2f", shares),
-			money(purchase, false),
-			money(value, false),
-			money(profit, false),
-		})
-		table.Render()
+			nil,
+			nil,
+			shares,
+			Money{purchase, pdb.BaseCurrency()},
+			Money{value, pdb.BaseCurrency()},
+			Money{profit, pdb.BaseCurrency()},
+		}
+		tables = append(tables, table)
 
 		////////////////////////////////////////////////////////////////////////////////////////////
 		//// RETIREMENT TABLE
@@ -317,7 +809,7 @@ func main() {
 		annual_contribution := 40000.0
 		ror_retirement := .05 // nominal
 		inflation := .03
-		annual_expenses := -quarters.AvgExpenses() * 4
+		annual_expenses := -periods.AvgExpenses() * granularity.PerYear()
 		expense_growth := func(year int) float64 {
 			return FV(inflation, float64(year-2021), 0, annual_expenses, false)
 		}
@@ -340,25 +832,23 @@ func main() {
 			return v
 		}
 
-		title("retirement projection")
-		table = tablewriter.NewWriter(writer)
-		table.Append([]string{"Life Expectancy", fmt.Sprintf("%d", life_expectancy)})
-		table.Append([]string{"Nominal Rate of Return", fmt.Sprintf("%.2f%%", ror*100)})
-		table.Append([]string{"Retirement Rate of Return", fmt.Sprintf("%.2f%%", ror_retirement*100)})
-		table.Append([]string{"Inflation", fmt.Sprintf("%.2f%%", inflation*100)})
-		table.Append([]string{"Annual Contribution", money(annual_contribution, true)})
-		table.Render()
-
-		table = tablewriter.NewWriter(writer)
-		table.SetHeader([]string{
-			"Year",
-			"Age",
-			"Portfolio",
-			"Expenses",
-			"X% Rule",
-			"FIRECalc",
-			fmt.Sprintf("PMT @ %.1f%%", (ror_retirement-inflation)*100),
-		})
+		parameters := NewKeyValueTable("retirement_parameters", "retirement projection")
+		parameters.Append("Life Expectancy", life_expectancy)
+		parameters.Append("Nominal Rate of Return", Percent(ror*100))
+		parameters.Append("Retirement Rate of Return", Percent(ror_retirement*100))
+		parameters.Append("Inflation", Percent(inflation*100))
+		parameters.Append("Annual Contribution", Money{annual_contribution, pdb.BaseCurrency()})
+		tables = append(tables, parameters)
+
+		table = &Table{Name: "retirement_projection", Columns: []Column{
+			{"Year", "year"},
+			{"Age", "age"},
+			{"Portfolio", "portfolio"},
+			{"Expenses", "expenses"},
+			{"X% Rule", "withdrawal_rate"},
+			{"FIRECalc", "firecalc_success_rate"},
+			{fmt.Sprintf("PMT @ %.1f%%", (ror_retirement-inflation)*100), "pmt_coverage"},
+		}}
 
 		currentYear, err := strconv.Atoi(time.Now().Format("2006"))
 		check(err)
@@ -372,21 +862,54 @@ func main() {
 
 			pmt := PMT(ror_retirement-inflation, float64(retirement_length), fv, 0.0, false)
 
-			table.Append([]string{
-				fmt.Sprintf("%d", year),
-				fmt.Sprintf("%d", age),
-				money(fv, true),
-				money(expenses, true),
-				fmt.Sprintf("%.1f%%", (-expenses/fv)*100),
-				fmt.Sprintf("%.0f%%", firecalc_success_rate),
-				fmt.Sprintf("%.1f%%", (pmt/-expenses)*100),
-			})
-		}
-		table.Render()
-		os.Exit(0)
+			table.Append(
+				year,
+				age,
+				Money{fv, pdb.BaseCurrency()},
+				Money{expenses, pdb.BaseCurrency()},
+				Percent((-expenses/fv)*100),
+				Percent(firecalc_success_rate),
+				Percent((pmt/-expenses)*100),
+			)
+		}
+		tables = append(tables, table)
+
+		if len(*reportHTML) > 0 {
+			file, err := os.Create(*reportHTML)
+			check(err)
+			err = NewHTMLReport(tables, pdb.BaseCurrency()).Write(file)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			check(err)
+			return
+		}
+
+		for _, table := range tables {
+			renderer.Render(table)
+		}
+		return
 	}
 
-	filter, errors := ParseFilter(RawFilter{*categories, *regexString, *start, *end})
+	rawFilter := RawFilter{*categories, *regexString, *queryString, *start, *end, *period}
+	if len(*viewName) > 0 {
+		view, err := pdb.View(*viewName)
+		check(err)
+		rawFilter = view.Apply(rawFilter)
+
+		// Display settings on the command line take precedence over the view's
+		if len(*listGroupBy) == 0 {
+			*listGroupBy = view.GroupBy
+		}
+		if len(*listSort) == 0 {
+			*listSort = view.Sort
+		}
+		if len(*listColumns) == 0 {
+			*listColumns = view.Columns
+		}
+	}
+
+	filter, errors := ParseFilter(rawFilter)
 	if len(errors) != 0 {
 		for k, v := range errors {
 			fmt.Fprintf(os.Stderr, "ERROR: %s: %s", k, v)
@@ -397,48 +920,118 @@ func main() {
 	slice := pdb.Slice(filter)
 
 	if len(slice.transactions) == 0 {
-		fmt.Printf("No transactions found\n")
+		fmt.Fprintf(os.Stderr, "No transactions found\n")
 		return
 	}
 
 	switch command {
+	case exportCmd.FullCommand():
+		if *exportFormat != "qif" {
+			check(fmt.Errorf("unsupported export format %s (expecting qif)", *exportFormat))
+		}
+
+		// Investments aren't filtered by category or query, only by date
+		var exported []*Investment
+		for _, investment := range pdb.AllInvestments() {
+			if !investment.Date.Before(filter.Start) && !investment.Date.After(filter.End) {
+				exported = append(exported, investment)
+			}
+		}
+
+		writer := io.Writer(os.Stdout)
+		if len(*exportFile) > 0 {
+			file, err := os.Create(*exportFile)
+			check(err)
+			defer file.Close()
+			writer = file
+		}
+		check(WriteQIF(writer, slice.transactions, exported, pdb.Accounts()))
 	case investments.FullCommand():
 		investments := pdb.AllInvestments()
 		cache, err := NewStockSymbolLookup(pdb)
 		check(err)
 
-		writer := os.Stdout
-		table := tablewriter.NewWriter(writer)
-		table.SetHeader([]string{
-			"Account",
-			"Date",
-			"Type",
-			"Symbol",
-			"Shares",
-			"Price",
-			"Purchase",
-			"Value",
-			"Profit",
-		})
+		table := &Table{Name: "investments", Columns: []Column{
+			{"Account", "account"},
+			{"Account Name", "account_name"},
+			{"Date", "date"},
+			{"Type", "type"},
+			{"Symbol", "symbol"},
+			{"Shares", "shares"},
+			{"Price", "price"},
+			{"Purchase", "purchase"},
+			{"Value", "value"},
+			{"Profit", "profit"},
+		}}
 		for _, investment := range investments {
 			price, err := cache.Get(investment.Symbol)
 			check(err)
 			value := investment.Shares * price
 			purchase := investment.Shares * investment.Price
 			profit := value - purchase
-			table.Append([]string{
+			table.Append(
 				fmt.Sprintf("%d", investment.Account),
-				investment.Date.Format("01/02/2006"),
+				pdb.AccountName(fmt.Sprintf("%d", investment.Account)),
+				Date(investment.Date),
 				investment.Type,
 				investment.Symbol,
-				fmt.Sprintf("%.2f", investment.Shares),
-				money(investment.Price, true),
-				money(purchase, true),
-				money(value, true),
-				money(profit, true),
-			})
-		}
-		table.Render()
+				investment.Shares,
+				Money{investment.Price, investment.Currency},
+				Money{purchase, investment.Currency},
+				Money{value, investment.Currency},
+				Money{profit, investment.Currency},
+			)
+		}
+		renderer.Render(table)
+	case chartExpenses.FullCommand():
+		granularity, err := ParseGranularity(defaultString(*chartBy, "month"))
+		check(err)
+		expenses := ExpenseChart(slice.Pivot(granularity, time.January), slice.Classifier(), *chartTop, pdb.BaseCurrency())
+		if renderer.Format() == "table" {
+			expenses.Write(os.Stdout, chartStyle(*chartASCII), *chartWidth)
+		} else {
+			renderer.Render(expenses.Table("expenses"))
+		}
+	case chartSavings.FullCommand(), chartCats.FullCommand():
+		var bars *BarChart
+		if command == chartSavings.FullCommand() {
+			granularity, err := ParseGranularity(defaultString(*chartBy, "quarter"))
+			check(err)
+			bars = SavingsRateChart(slice.Pivot(granularity, time.January).Periods)
+		} else {
+			bars = CategoryChart(slice.CategorySummaries(), pdb.BaseCurrency())
+		}
+		if renderer.Format() == "table" {
+			bars.Write(os.Stdout, chartStyle(*chartASCII), *chartWidth)
+		} else {
+			renderer.Render(bars.Table(jsonKey(bars.Title)))
+		}
+	case pivot.FullCommand():
+		granularity, err := ParseGranularity(*pivotBy)
+		check(err)
+		fiscalYearStart, err := ParseFiscalYearStart(*pivotFiscal)
+		check(err)
+		renderer.Render(slice.Pivot(granularity, fiscalYearStart).Table(pdb.BaseCurrency()))
+	case linkCandidates.FullCommand():
+		candidates := FindLinkCandidates(slice.transactions, pdb.Links(), time.Duration(*linkWindow)*24*time.Hour)
+		table := &Table{Name: "candidates", Columns: []Column{
+			{"Refund", "from_id"}, {"Date", "from_date"}, {"Amount", "from_amount"}, {"Memo", "from_memo"},
+			{"Original", "to_id"}, {"Date", "to_date"}, {"Amount", "to_amount"}, {"Category", "to_category"},
+		}}
+		for _, candidate := range candidates {
+			from, err := pdb.TransactionById(candidate.From)
+			check(err)
+			to, err := pdb.TransactionById(candidate.To)
+			check(err)
+			table.Append(
+				from.Id(), Date(from.Date), Money{from.Amount, from.Currency}, from.Memo,
+				to.Id(), Date(to.Date), Money{to.Amount, to.Currency}, to.Category,
+			)
+		}
+		if *linkApply {
+			check(pdb.SaveLinks(candidates))
+		}
+		renderer.Render(table)
 	case markPayoffsCmd.FullCommand():
 		check(slice.SaveEditCsv(bytes.NewReader(slice.MarkPayoffs().GetEditCsv())))
 	case encryptCmd.FullCommand():
@@ -453,53 +1046,18 @@ func main() {
 		plaintext, err := decrypt(key, contents)
 		check(err)
 		os.Stdout.Write(plaintext)
-	case importCmd.FullCommand():
-		importer := NewTransactionImporter()
-
-		investmentContents, err := ioutil.ReadFile("investments.csv")
-		if err != nil {
-			fmt.Printf("Could not read file investments.csv... skipping (%v)\n", err)
-		} else {
-			check(importer.ImportInvestments(investmentContents))
-		}
-
-		chaseCsvContents, err := ioutil.ReadFile("chase.csv")
-		if err != nil {
-			fmt.Printf("Could not read file chase.csv... skipping (%v)\n", err)
-		} else {
-			check(importer.ImportAmazonRewards("chase", chaseCsvContents))
-		}
-
-		dcuCsvContents, err := ioutil.ReadFile("dcu.csv")
-		if err != nil {
-			fmt.Printf("Could not read file dcu.csv... skipping (%v)\n", err)
-		} else {
-			check(importer.ImportDCU("dcu", dcuCsvContents))
-		}
-
-		dcu2CsvContents, err := ioutil.ReadFile("dcu2.csv")
-		if err != nil {
-			fmt.Printf("Could not read file dcu2.csv... skipping (%v)\n", err)
+	case list.FullCommand():
+		options, err := ParseListOptions(*listGroupBy, *listSort, *listColumns)
+		check(err)
+		if renderer.Format() == "table" {
+			slice.WriteList(os.Stdout, options)
+			fmt.Printf("\n\n")
 		} else {
-			check(importer.ImportDCU("dcu2", dcu2CsvContents))
+			renderer.Render(slice.ListTable(options))
 		}
-
-		dcu3CsvContents, err := ioutil.ReadFile("dcu3.csv")
-		if err != nil {
-			fmt.Printf("Could not read file dcu3.csv... skipping (%v)\n", err)
-		} else {
-			check(importer.ImportDCU("dcu3", dcu3CsvContents))
+		for _, table := range slice.TotalsTables() {
+			renderer.Render(table)
 		}
-
-		err = pdb.Insert(importer.All())
-		check(err)
-
-		err = pdb.InsertInvestments(importer.AllInvestments())
-		check(err)
-	case list.FullCommand():
-		slice.WriteHumanReadableTable(os.Stdout)
-		fmt.Printf("\n\n")
-		slice.WriteHumanReadableTotals(os.Stdout)
 	case edit.FullCommand():
 		tmpfile, err := ioutil.TempFile("", "")
 		check(err)
@@ -522,3 +1080,17 @@ func main() {
 		slice.SaveEditCsv(bytes.NewReader(contents))
 	}
 }
+
+func chartStyle(ascii bool) ChartStyle {
+	if ascii || !unicodeSupported() {
+		return ASCIIChartStyle
+	}
+	return UnicodeChartStyle
+}
+
+func defaultString(value, fallback string) string {
+	if len(value) == 0 {
+		return fallback
+	}
+	return value
+}
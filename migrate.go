@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Importers for the exports of other budgeting tools, so that switching to
+// penny keeps the history along with its categories, notes and splits.
+// Account names become sources and categories are kept, both going through
+// the rename table (see ImportRenames) when the file is imported.
+
+// Reads a CSV export into rows keyed by column name.  The header is the first
+// row with all of the given columns, as some exports start with a title.
+func readCsvRows(contents []byte, columns ...string) ([]string, []map[string]string, error) {
+	records, err := readCsv(bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf")))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("empty file")
+	}
+
+	first := -1
+	for index, record := range records {
+		for column := range record {
+			record[column] = strings.TrimSpace(record[column])
+		}
+		found := true
+		for _, column := range columns {
+			found = found && contains(column, record)
+		}
+		if found {
+			first = index
+			break
+		}
+	}
+	if first < 0 {
+		return nil, nil, fmt.Errorf("no header with columns %s", strings.Join(columns, ", "))
+	}
+	header := records[first]
+	records = records[first:]
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		if len(strings.TrimSpace(strings.Join(record, ""))) == 0 {
+			continue
+		}
+		row := make(map[string]string)
+		for index, value := range record {
+			if index < len(header) {
+				row[header[index]] = strings.TrimSpace(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return header, rows, nil
+}
+
+// Exports are detected by columns that only that tool writes
+func detectCsvColumns(filename string, contents []byte, columns ...string) int {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt", "":
+	default:
+		return 0
+	}
+
+	if _, _, err := readCsvRows(contents, columns...); err != nil {
+		return 0
+	}
+	return 20
+}
+
+// Amounts with currency symbols and thousands separators, e.g. "$1,234.50"
+func parseMoney(value string) (float64, error) {
+	value = strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, value)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func parseDateFormats(value string, formats ...string) (time.Time, error) {
+	for _, format := range formats {
+		if date, err := time.Parse(format, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// Adds each row of an export, rejecting the rows that don't parse when the
+// importer allows it
+func importCsvRows(name string, rows []map[string]string, importer *TransactionImporter, importRow func(row map[string]string) error) error {
+	for index, row := range rows {
+		if err := importRow(row); err != nil {
+			err = fmt.Errorf("%s: row %d: %v", name, index+2, err)
+			if importer.SkipInvalid {
+				importer.Rejected = append(importer.Rejected, err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// MintImporter reads the transactions CSV exported by Mint.  Amounts are
+// positive with a debit or credit type, and split transactions are exported
+// as one row per split.
+type MintImporter struct{}
+
+func (MintImporter) Name() string {
+	return "mint"
+}
+
+func (MintImporter) Detect(filename string, contents []byte) int {
+	return detectCsvColumns(filename, contents, "Original Description", "Transaction Type", "Account Name")
+}
+
+func (mint MintImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	_, rows, err := readCsvRows(contents)
+	if err != nil {
+		return err
+	}
+
+	return importCsvRows(mint.Name(), rows, importer, func(row map[string]string) error {
+		date, err := time.Parse("1/2/2006", row["Date"])
+		if err != nil {
+			return err
+		}
+		amount, err := parseMoney(row["Amount"])
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(row["Transaction Type"], "debit") {
+			amount = -amount
+		}
+
+		category := row["Category"]
+		if strings.EqualFold(category, "Uncategorized") {
+			category = ""
+		}
+
+		memo := defaultString(row["Original Description"], row["Description"])
+		tx := &Transaction{defaultString(source, row["Account Name"]), date, memo, amount, "", "", category, false}
+		importer.Add(tx)
+		importer.AddNote(tx, row["Notes"])
+		return nil
+	})
+}
+
+// YNAB marks the rows of a split transaction with a prefix on their memo
+var ynabSplit = regexp.MustCompile(`^Split \((\d+)/\d+\)\s*`)
+
+// YNABImporter reads the register CSV exported by YNAB.  The payee becomes
+// the memo and YNAB's memo becomes the note.  Split rows keep their position
+// in the split as disambiguation (s1, s2, ...).
+type YNABImporter struct{}
+
+func (YNABImporter) Name() string {
+	return "ynab"
+}
+
+func (YNABImporter) Detect(filename string, contents []byte) int {
+	return detectCsvColumns(filename, contents, "Account", "Payee", "Outflow", "Inflow")
+}
+
+func (ynab YNABImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	_, rows, err := readCsvRows(contents)
+	if err != nil {
+		return err
+	}
+
+	return importCsvRows(ynab.Name(), rows, importer, func(row map[string]string) error {
+		date, err := parseDateFormats(row["Date"], "01/02/2006", "2006-01-02", "02.01.2006")
+		if err != nil {
+			return err
+		}
+		outflow, err := parseMoney(row["Outflow"])
+		if err != nil {
+			return err
+		}
+		inflow, err := parseMoney(row["Inflow"])
+		if err != nil {
+			return err
+		}
+
+		// "Category Group/Category" holds "Group: Category" in older exports
+		category := row["Category"]
+		if _, ok := row["Category"]; !ok {
+			group := row["Category Group/Category"]
+			category = strings.TrimSpace(group[strings.Index(group, ":")+1:])
+		}
+
+		note := row["Memo"]
+		var disambiguation string
+		if match := ynabSplit.FindStringSubmatch(note); match != nil {
+			disambiguation = "s" + match[1]
+			note = note[len(match[0]):]
+		}
+
+		tx := &Transaction{defaultString(source, row["Account"]), date, row["Payee"], inflow - outflow, disambiguation, "", category, false}
+		importer.Add(tx)
+		importer.AddNote(tx, note)
+		return nil
+	})
+}
+
+// Actual prefixes the notes of split transactions
+var (
+	actualSplitParent = regexp.MustCompile(`^\(SPLIT INTO \d+\)\s*`)
+	actualSplitChild  = regexp.MustCompile(`^\(SPLIT (\d+) OF \d+\)\s*`)
+)
+
+// ActualImporter reads the transactions CSV exported by Actual Budget.  Split
+// transactions are exported as a parent row followed by its splits, of which
+// only the splits are imported, disambiguated by their position (s1, s2,
+// ...).
+type ActualImporter struct{}
+
+func (ActualImporter) Name() string {
+	return "actual"
+}
+
+func (ActualImporter) Detect(filename string, contents []byte) int {
+	return detectCsvColumns(filename, contents, "Account", "Payee", "Notes", "Category", "Amount")
+}
+
+func (actual ActualImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	_, rows, err := readCsvRows(contents)
+	if err != nil {
+		return err
+	}
+
+	return importCsvRows(actual.Name(), rows, importer, func(row map[string]string) error {
+		note := row["Notes"]
+		if actualSplitParent.MatchString(note) {
+			return nil
+		}
+
+		date, err := parseDateFormats(row["Date"], "2006-01-02", "01/02/2006")
+		if err != nil {
+			return err
+		}
+		amount, err := parseMoney(row["Amount"])
+		if err != nil {
+			return err
+		}
+		splitAmount, err := parseMoney(row["Split_Amount"])
+		if err != nil {
+			return err
+		}
+
+		var disambiguation string
+		if match := actualSplitChild.FindStringSubmatch(note); match != nil {
+			disambiguation = "s" + match[1]
+			note = note[len(match[0]):]
+		}
+		if splitAmount != 0 {
+			amount = splitAmount
+		}
+
+		tx := &Transaction{defaultString(source, row["Account"]), date, row["Payee"], amount, disambiguation, "", row["Category"], false}
+		importer.Add(tx)
+		importer.AddNote(tx, note)
+		return nil
+	})
+}
+
+func init() {
+	RegisterImporter(MintImporter{})
+	RegisterImporter(YNABImporter{})
+	RegisterImporter(ActualImporter{})
+}
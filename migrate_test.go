@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+const mintSample = `"Date","Description","Original Description","Amount","Transaction Type","Category","Account Name","Labels","Notes"
+"1/05/2021","Whole Foods","WHOLEFDS #123","54.20","debit","Groceries","CREDIT CARD","","weekly shop"
+"1/05/2021","Target","TARGET 0042","30.00","debit","Household","CREDIT CARD","",""
+"1/05/2021","Target","TARGET 0042","30.00","debit","Household","CREDIT CARD","",""
+"1/15/2021","Acme Payroll","ACME PAYROLL","2,500.00","credit","Paycheck","Checking","",""
+"1/16/2021","Unknown","SQ *STAND","4.00","debit","Uncategorized","Checking","",""
+`
+
+const ynabSample = "\xef\xbb\xbf" + `"Account","Flag","Date","Payee","Category Group/Category","Category Group","Category","Memo","Outflow","Inflow","Cleared"
+"Checking","","01/03/2021","Costco","Everyday: Groceries","Everyday","Groceries","Split (1/2) food","$80.00","$0.00","Cleared"
+"Checking","","01/03/2021","Costco","Everyday: Household","Everyday","Household","Split (2/2) ","$20.00","$0.00","Cleared"
+"Checking","","01/15/2021","Employer","Inflow: Ready to Assign","Inflow","Ready to Assign","","$0.00","$1,000.00","Cleared"
+`
+
+const actualSample = `Account,Date,Payee,Notes,Category,Amount,Split_Amount,Cleared
+Checking,2021-01-04,Costco,(SPLIT INTO 2) big run,,-100.00,0,Cleared
+Checking,2021-01-04,Costco,(SPLIT 1 OF 2) food,Groceries,0,-70.00,Cleared
+Checking,2021-01-04,Costco,(SPLIT 2 OF 2) ,Household,0,-30.00,Cleared
+Savings,2021-01-10,Bank,interest,Interest,1.25,0,Cleared
+`
+
+func TestMintImporter(t *testing.T) {
+	if (MintImporter{}).Detect("transactions.csv", []byte(mintSample)) <= (&CsvProfile{ProfileName: "dcu", Date: "DATE", Memo: "DESCRIPTION", Amount: "AMOUNT"}).Detect("transactions.csv", []byte(mintSample)) {
+		t.Fatalf("expected Mint exports to be detected over the generic CSV profiles")
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, MintImporter{}.Import("", []byte(mintSample), importer))
+
+	txs := importer.All()
+	if len(txs) != 5 {
+		t.Fatalf("expected every split row to be kept, got %v", txs)
+	}
+	for _, tx := range txs {
+		switch tx.Memo {
+		case "WHOLEFDS #123":
+			if tx.Amount != -54.20 || tx.Source != "CREDIT CARD" || tx.Category != "Groceries" || importer.notes[tx.Id()] != "weekly shop" {
+				t.Fatalf("unexpected debit %v", tx)
+			}
+		case "ACME PAYROLL":
+			if tx.Amount != 2500 || tx.Source != "Checking" {
+				t.Fatalf("unexpected credit %v", tx)
+			}
+		case "SQ *STAND":
+			if tx.Category != "" {
+				t.Fatalf("expected Uncategorized to be left blank, got %q", tx.Category)
+			}
+		}
+	}
+}
+
+func TestYNABImporter(t *testing.T) {
+	if (YNABImporter{}).Detect("register.csv", []byte(ynabSample)) == 0 {
+		t.Fatalf("expected a YNAB register to be detected")
+	}
+	if (ActualImporter{}).Detect("register.csv", []byte(ynabSample)) != 0 {
+		t.Fatalf("expected a YNAB register not to be detected as Actual")
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, YNABImporter{}.Import("", []byte(ynabSample), importer))
+
+	txs := importer.All()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Amount < txs[j].Amount })
+	if len(txs) != 3 {
+		t.Fatalf("expected 3 transactions, got %v", txs)
+	}
+	food, household, income := txs[0], txs[1], txs[2]
+	if food.Amount != -80 || food.Memo != "Costco" || food.Disambiguation != "s1" || food.Category != "Groceries" || importer.notes[food.Id()] != "food" {
+		t.Fatalf("unexpected split %v (%s)", food, food.Disambiguation)
+	}
+	if household.Disambiguation != "s2" || len(importer.notes[household.Id()]) != 0 {
+		t.Fatalf("unexpected split %v (%s)", household, household.Disambiguation)
+	}
+	if income.Amount != 1000 || income.Source != "Checking" || income.Date.Format("2006-01-02") != "2021-01-15" {
+		t.Fatalf("unexpected inflow %v", income)
+	}
+}
+
+func TestActualImporter(t *testing.T) {
+	if (ActualImporter{}).Detect("actual.csv", []byte(actualSample)) == 0 {
+		t.Fatalf("expected an Actual export to be detected")
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, ActualImporter{}.Import("", []byte(actualSample), importer))
+
+	txs := importer.All()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Amount < txs[j].Amount })
+	if len(txs) != 3 {
+		t.Fatalf("expected the split parent to be left out, got %v", txs)
+	}
+	food, household, interest := txs[0], txs[1], txs[2]
+	if food.Amount != -70 || food.Disambiguation != "s1" || food.Category != "Groceries" || importer.notes[food.Id()] != "food" {
+		t.Fatalf("unexpected split %v", food)
+	}
+	if household.Amount != -30 || household.Disambiguation != "s2" {
+		t.Fatalf("unexpected split %v", household)
+	}
+	if interest.Source != "Savings" || interest.Amount != 1.25 || importer.notes[interest.Id()] != "interest" {
+		t.Fatalf("unexpected transaction %v", interest)
+	}
+}
+
+func TestImportRenames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { importRenames = &ImportRenames{} }()
+
+	renames := filepath.Join(dir, "renames.json")
+	fail(t, ioutil.WriteFile(renames, []byte(`{
+		"categories": {"groceries": "food", "Paycheck": "income"},
+		"accounts": {"CREDIT CARD": "chase"}
+	}`), 0600))
+	fail(t, LoadImportRenames(renames))
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+	fail(t, pdb.SaveAccount(&Account{"dcu", "Checking", "DCU", AccountChecking, "", "", "EUR", false}))
+
+	path := filepath.Join(dir, "transactions.csv")
+	fail(t, ioutil.WriteFile(path, []byte(mintSample), 0600))
+	summaries, err := ImportFiles(pdb, []string{path}, "", "")
+	fail(t, err)
+	if summaries[0].Importer != "mint" || summaries[0].New != 5 {
+		t.Fatalf("unexpected summary %+v", summaries[0])
+	}
+	fail(t, pdb.LoadCaches())
+
+	for _, tx := range pdb.AllTransactions() {
+		switch tx.Memo {
+		case "WHOLEFDS #123":
+			if tx.Source != "chase" || tx.Category != "food" || tx.Currency != "USD" {
+				t.Fatalf("expected the rename table to apply, got %v", tx)
+			}
+			if note := pdb.Note(tx.Id()); note != "weekly shop" {
+				t.Fatalf("expected the note to be saved, got %q", note)
+			}
+		case "ACME PAYROLL":
+			if tx.Source != "dcu" || tx.Category != "income" || tx.Currency != "EUR" {
+				t.Fatalf("expected the account name to map onto its source and currency, got %v", tx)
+			}
+		case "TARGET 0042":
+			if tx.Category != "Household" {
+				t.Fatalf("expected an unmapped category to be kept, got %v", tx)
+			}
+		}
+	}
+
+	// A note changed after the import keeps the transaction on rollback
+	var payroll *Transaction
+	for _, tx := range pdb.AllTransactions() {
+		if tx.Memo == "ACME PAYROLL" {
+			payroll = tx
+		}
+	}
+	fail(t, pdb.SaveNote(payroll.Id(), "January"))
+	rollback, err := pdb.RollbackBatch(summaries[0].Batch.Id)
+	fail(t, err)
+	if rollback.Transactions != 4 || len(rollback.Kept) != 1 || rollback.Kept[0].Memo != "ACME PAYROLL" {
+		t.Fatalf("unexpected rollback %+v", rollback)
+	}
+	if len(pdb.noteCache) != 1 {
+		t.Fatalf("expected only the edited note to be left, got %v", pdb.noteCache)
+	}
+}
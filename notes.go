@@ -0,0 +1,68 @@
+package main
+
+// Free-form note on a transaction, or "" if it has none
+func (pdb *PennyDb) Note(id string) string {
+	pdb.mutex.RLock()
+	defer pdb.mutex.RUnlock()
+	return pdb.noteCache[id]
+}
+
+// Replaces the note on a transaction.  An empty note removes it.
+func (pdb *PennyDb) SaveNote(id, note string) error {
+	return pdb.SaveNotes(map[string]string{id: note})
+}
+
+// Replaces the notes on transactions, by ID, through one handle
+func (pdb *PennyDb) SaveNotes(notes map[string]string) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	for id, note := range notes {
+		if len(note) == 0 {
+			_, err = handle.Exec(`DELETE FROM tx_note WHERE tx_id=?`, id)
+		} else {
+			_, err = handle.Exec(`REPLACE INTO tx_note (tx_id, note) VALUES (?, ?)`, id, note)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	pdb.noteCache, err = handle.AllNotes()
+	return err
+}
+
+func (handle *PennyDbHandle) AllNotes() (map[string]string, error) {
+	rows, err := handle.Query("SELECT tx_id, note FROM tx_note;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := make(map[string]string)
+	for rows.Next() {
+		var id, note string
+		err = rows.Scan(&id, &note)
+		if err != nil {
+			return nil, err
+		}
+		notes[id] = note
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ofxNode is an element of an OFX document.  Aggregates have children and
+// leaves have a value.
+type ofxNode struct {
+	Name     string
+	Value    string
+	Children []*ofxNode
+}
+
+// The first child with a name, following a path of names
+func (node *ofxNode) Find(path ...string) *ofxNode {
+	current := node
+	for _, name := range path {
+		var next *ofxNode
+		for _, child := range current.Children {
+			if child.Name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// The value of the leaf at a path, or "" if there is none
+func (node *ofxNode) Get(path ...string) string {
+	if found := node.Find(path...); found != nil {
+		return found.Value
+	}
+	return ""
+}
+
+// Every descendant with a name, in document order
+func (node *ofxNode) All(name string) []*ofxNode {
+	var found []*ofxNode
+	for _, child := range node.Children {
+		if child.Name == name {
+			found = append(found, child)
+		}
+		found = append(found, child.All(name)...)
+	}
+	return found
+}
+
+// Parses OFX 1.x (SGML) and 2.x (XML) documents.  SGML leaves have no closing
+// tags, so any element followed by text is treated as a leaf and a closing
+// tag closes every element opened since the matching one.
+func ParseOFX(contents []byte) (*ofxNode, error) {
+	start := bytes.Index(bytes.ToUpper(contents), []byte("<OFX>"))
+	if start < 0 {
+		return nil, fmt.Errorf("not an OFX file (no <OFX> element)")
+	}
+
+	root := &ofxNode{Name: "ROOT"}
+	stack := []*ofxNode{root}
+	text := string(contents[start:])
+
+	for len(text) > 0 {
+		open := strings.IndexByte(text, '<')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(text[open:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated tag %q", text[open:])
+		}
+		tag := strings.TrimSpace(text[open+1 : open+end])
+		text = text[open+end+1:]
+
+		if len(tag) == 0 || strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+			continue
+		}
+
+		if strings.HasPrefix(tag, "/") {
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for index := len(stack) - 1; index > 0; index-- {
+				if stack[index].Name == name {
+					stack = stack[:index]
+					break
+				}
+			}
+			continue
+		}
+
+		node := &ofxNode{Name: strings.ToUpper(strings.Fields(tag)[0])}
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, node)
+
+		value := text
+		if next := strings.IndexByte(text, '<'); next >= 0 {
+			value = text[:next]
+		}
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			node.Value = html.UnescapeString(value)
+		} else {
+			stack = append(stack, node)
+		}
+	}
+
+	ofx := root.Find("OFX")
+	if ofx == nil {
+		return nil, fmt.Errorf("not an OFX file (no <OFX> element)")
+	}
+	return ofx, nil
+}
+
+// OFX dates are YYYYMMDD followed by an optional time and time zone, of which
+// only the day is kept
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid OFX date %q", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+// OFX amounts may use a comma as the decimal separator
+func parseOFXAmount(value string) (float64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+}
+
+// OFXImporter reads bank, credit card and brokerage statements from OFX and
+// QFX downloads.  Transactions take their source from the account number
+// unless one is given, and FITIDs (scoped to the account) are kept as
+// external IDs so that revised transactions are still recognized.
+type OFXImporter struct{}
+
+func (OFXImporter) Name() string {
+	return "ofx"
+}
+
+func (OFXImporter) Detect(filename string, contents []byte) int {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ofx", ".qfx":
+		return 10
+	}
+	head := bytes.ToUpper(contents)
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if bytes.Contains(head, []byte("OFXHEADER")) || bytes.Contains(head, []byte("<OFX>")) {
+		return 10
+	}
+	return 0
+}
+
+func (ofxImporter OFXImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	ofx, err := ParseOFX(contents)
+	if err != nil {
+		return err
+	}
+
+	statements := 0
+	for _, statement := range append(ofx.All("STMTRS"), ofx.All("CCSTMTRS")...) {
+		account := statement.Get("BANKACCTFROM", "ACCTID")
+		if len(account) == 0 {
+			account = statement.Get("CCACCTFROM", "ACCTID")
+		}
+		for _, stmttrn := range statement.All("STMTTRN") {
+			if err := ofxImporter.importTransaction(stmttrn, defaultString(source, account), account, statement.Get("CURDEF"), importer); err != nil {
+				return err
+			}
+		}
+		statements++
+	}
+
+	for _, statement := range ofx.All("INVSTMTRS") {
+		if err := ofxImporter.importInvestments(ofx, statement, source, importer); err != nil {
+			return err
+		}
+		statements++
+	}
+
+	if statements == 0 {
+		return fmt.Errorf("no bank, credit card or investment statements found")
+	}
+	return nil
+}
+
+func (OFXImporter) importTransaction(stmttrn *ofxNode, source, account, currency string, importer *TransactionImporter) error {
+	date, err := parseOFXDate(stmttrn.Get("DTPOSTED"))
+	if err != nil {
+		return err
+	}
+	amount, err := parseOFXAmount(stmttrn.Get("TRNAMT"))
+	if err != nil {
+		return err
+	}
+
+	memo := stmttrn.Get("NAME")
+	if extra := stmttrn.Get("MEMO"); len(memo) == 0 {
+		memo = extra
+	} else if len(extra) > 0 && !strings.Contains(memo, extra) {
+		memo = memo + " " + extra
+	}
+	if sym := stmttrn.Get("CURRENCY", "CURSYM"); len(sym) > 0 {
+		currency = sym
+	}
+
+	tx := &Transaction{source, date, memo, amount, "", currency, "", false}
+	if fitid := stmttrn.Get("FITID"); len(fitid) > 0 {
+		importer.AddExternal(tx, account+":"+fitid)
+	} else {
+		importer.Add(tx)
+	}
+	return nil
+}
+
+// Buys and sells add or remove shares at their unit price, reinvestments add
+// shares, and income (dividends, interest, capital gains paid out) is recorded
+// without shares with its total as the price.  Cash movements in the account
+// are imported as transactions.
+func (ofxImporter OFXImporter) importInvestments(ofx, statement *ofxNode, source string, importer *TransactionImporter) error {
+	accountId := statement.Get("INVACCTFROM", "ACCTID")
+	account, err := strconv.ParseInt(strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, accountId), 10, 64)
+	if err != nil {
+		return fmt.Errorf("investment account %q isn't a number", accountId)
+	}
+	currency := statement.Get("CURDEF")
+
+	// Securities are referred to by CUSIP, with tickers in the security list
+	tickers := make(map[string]string)
+	for _, info := range ofx.All("SECINFO") {
+		if ticker := info.Get("TICKER"); len(ticker) > 0 {
+			tickers[info.Get("SECID", "UNIQUEID")] = ticker
+		}
+	}
+	symbol := func(node *ofxNode) string {
+		id := node.Get("SECID", "UNIQUEID")
+		return defaultString(tickers[id], id)
+	}
+
+	list := statement.Find("INVTRANLIST")
+	if list == nil {
+		return nil
+	}
+
+	for _, entry := range list.Children {
+		var kind string
+		var trade *ofxNode
+		switch {
+		case entry.Find("INVBUY") != nil:
+			kind, trade = "BUY", entry.Find("INVBUY")
+		case entry.Find("INVSELL") != nil:
+			kind, trade = "SELL", entry.Find("INVSELL")
+		case entry.Name == "INCOME":
+			kind, trade = "INCOME", entry
+		case entry.Name == "REINVEST":
+			kind, trade = "REINVEST", entry
+		case entry.Name == "INVBANKTRAN":
+			stmttrn := entry.Find("STMTTRN")
+			if stmttrn == nil {
+				continue
+			}
+			if err := ofxImporter.importTransaction(stmttrn, defaultString(source, accountId), accountId, currency, importer); err != nil {
+				return err
+			}
+			continue
+		default:
+			continue
+		}
+
+		date, err := parseOFXDate(trade.Get("INVTRAN", "DTTRADE"))
+		if err != nil {
+			return err
+		}
+		units, err := parseOFXAmount(trade.Get("UNITS"))
+		if err != nil {
+			return err
+		}
+		price, err := parseOFXAmount(trade.Get("UNITPRICE"))
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case "SELL":
+			units = -math.Abs(units)
+		case "INCOME":
+			total, err := parseOFXAmount(trade.Get("TOTAL"))
+			if err != nil {
+				return err
+			}
+			units, price = 0, total
+			if incomeType := trade.Get("INCOMETYPE"); len(incomeType) > 0 {
+				kind = kind + ":" + incomeType
+			}
+		default:
+			units = math.Abs(units)
+		}
+
+		investment := &Investment{account, date, kind, symbol(trade), units, price, "", currency}
+		if fitid := trade.Get("INVTRAN", "FITID"); len(fitid) > 0 {
+			importer.AddInvestmentExternal(investment, accountId+":"+fitid)
+		} else {
+			importer.AddInvestment(investment)
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterImporter(OFXImporter{})
+}
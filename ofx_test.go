@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const ofxBankSGML = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+SECURITY:NONE
+ENCODING:USASCII
+CHARSET:1252
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>20210201120000<LANGUAGE>ENG</SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>1
+<STMTRS>
+<CURDEF>USD
+<BANKACCTFROM><BANKID>123<ACCTID>987654<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20210101
+<DTEND>20210131
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20210105120000[-5:EST]<TRNAMT>-42.10<FITID>A1<NAME>GROCER &amp; CO<MEMO>POS PURCHASE</STMTTRN>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20210106<TRNAMT>-3,50<FITID>A2<NAME>COFFEE</STMTTRN>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20210106<TRNAMT>-3,50<FITID>A3<NAME>COFFEE</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>1000.00<DTASOF>20210131</LEDGERBAL>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`
+
+const ofxInvestmentXML = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <INVSTMTMSGSRSV1>
+    <INVSTMTTRNRS>
+      <TRNUID>1</TRNUID>
+      <INVSTMTRS>
+        <DTASOF>20210131</DTASOF>
+        <CURDEF>USD</CURDEF>
+        <INVACCTFROM><BROKERID>broker.com</BROKERID><ACCTID>X-4455</ACCTID></INVACCTFROM>
+        <INVTRANLIST>
+          <DTSTART>20210101</DTSTART><DTEND>20210131</DTEND>
+          <BUYMF>
+            <INVBUY>
+              <INVTRAN><FITID>B1</FITID><DTTRADE>20210104</DTTRADE></INVTRAN>
+              <SECID><UNIQUEID>922908769</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID>
+              <UNITS>10</UNITS><UNITPRICE>200.50</UNITPRICE><TOTAL>-2005.00</TOTAL>
+            </INVBUY>
+            <BUYTYPE>BUY</BUYTYPE>
+          </BUYMF>
+          <SELLSTOCK>
+            <INVSELL>
+              <INVTRAN><FITID>S1</FITID><DTTRADE>20210110</DTTRADE></INVTRAN>
+              <SECID><UNIQUEID>037833100</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID>
+              <UNITS>-5</UNITS><UNITPRICE>130</UNITPRICE><TOTAL>650</TOTAL>
+            </INVSELL>
+            <SELLTYPE>SELL</SELLTYPE>
+          </SELLSTOCK>
+          <INCOME>
+            <INVTRAN><FITID>I1</FITID><DTTRADE>20210115</DTTRADE><MEMO></MEMO></INVTRAN>
+            <SECID><UNIQUEID>922908769</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID>
+            <INCOMETYPE>DIV</INCOMETYPE><TOTAL>12.34</TOTAL>
+          </INCOME>
+          <REINVEST>
+            <INVTRAN><FITID>R1</FITID><DTTRADE>20210115</DTTRADE></INVTRAN>
+            <SECID><UNIQUEID>922908769</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID>
+            <INCOMETYPE>DIV</INCOMETYPE><TOTAL>-12.34</TOTAL><UNITS>0.06</UNITS><UNITPRICE>205.67</UNITPRICE>
+          </REINVEST>
+          <INVBANKTRAN>
+            <STMTTRN><TRNTYPE>CREDIT</TRNTYPE><DTPOSTED>20210120</DTPOSTED><TRNAMT>500.00</TRNAMT><FITID>C1</FITID><NAME>DEPOSIT</NAME></STMTTRN>
+            <SUBACCTFUND>CASH</SUBACCTFUND>
+          </INVBANKTRAN>
+        </INVTRANLIST>
+      </INVSTMTRS>
+    </INVSTMTTRNRS>
+  </INVSTMTMSGSRSV1>
+  <SECLISTMSGSRSV1>
+    <SECLIST>
+      <MFINFO><SECINFO><SECID><UNIQUEID>922908769</UNIQUEID><UNIQUEIDTYPE>CUSIP</UNIQUEIDTYPE></SECID><SECNAME>Total Stock Market</SECNAME><TICKER>VTSAX</TICKER></SECINFO></MFINFO>
+    </SECLIST>
+  </SECLISTMSGSRSV1>
+</OFX>
+`
+
+func TestParseOFX(t *testing.T) {
+	ofx, err := ParseOFX([]byte(ofxBankSGML))
+	fail(t, err)
+	if code := ofx.Get("SIGNONMSGSRSV1", "SONRS", "STATUS", "CODE"); code != "0" {
+		t.Fatalf("unexpected status code %q", code)
+	}
+	if count := len(ofx.All("STMTTRN")); count != 3 {
+		t.Fatalf("expected 3 transactions, got %d", count)
+	}
+	if balance := ofx.Get("BANKMSGSRSV1", "STMTTRNRS", "STMTRS", "LEDGERBAL", "BALAMT"); balance != "1000.00" {
+		t.Fatalf("unexpected balance %q", balance)
+	}
+
+	ofx, err = ParseOFX([]byte(ofxInvestmentXML))
+	fail(t, err)
+	if ticker := ofx.All("SECINFO")[0].Get("TICKER"); ticker != "VTSAX" {
+		t.Fatalf("unexpected ticker %q", ticker)
+	}
+
+	if _, err := ParseOFX([]byte("DATE,AMOUNT\n")); err == nil {
+		t.Fatalf("expected an error for a file that isn't OFX")
+	}
+}
+
+func TestOFXImporter(t *testing.T) {
+	importer := NewTransactionImporter()
+	fail(t, OFXImporter{}.Import("", []byte(ofxBankSGML), importer))
+
+	txs := importer.All()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Id() < txs[j].Id() })
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].Date.Before(txs[j].Date) })
+	if len(txs) != 3 {
+		t.Fatalf("expected 3 transactions, got %d", len(txs))
+	}
+	grocer := txs[0]
+	if grocer.Memo != "GROCER & CO POS PURCHASE" || grocer.Amount != -42.10 || grocer.Source != "987654" || grocer.Currency != "USD" {
+		t.Fatalf("unexpected transaction %v", grocer)
+	}
+	if importer.txExternalIds[grocer.Id()] != "987654:A1" {
+		t.Fatalf("unexpected external ID %q", importer.txExternalIds[grocer.Id()])
+	}
+	if txs[1].Amount != -3.5 || txs[1].Disambiguation == txs[2].Disambiguation {
+		t.Fatalf("expected identical coffees to be disambiguated, got %v and %v", txs[1], txs[2])
+	}
+
+	importer = NewTransactionImporter()
+	fail(t, OFXImporter{}.Import("brokerage", []byte(ofxInvestmentXML), importer))
+
+	byType := make(map[string]*Investment)
+	for _, investment := range importer.AllInvestments() {
+		byType[investment.Type] = investment
+	}
+	if buy := byType["BUY"]; buy == nil || buy.Account != 4455 || buy.Symbol != "VTSAX" || buy.Shares != 10 || buy.Price != 200.5 {
+		t.Fatalf("unexpected buy %v", buy)
+	}
+	if sell := byType["SELL"]; sell == nil || sell.Symbol != "037833100" || sell.Shares != -5 {
+		t.Fatalf("unexpected sell %v", sell)
+	}
+	if income := byType["INCOME:DIV"]; income == nil || income.Shares != 0 || income.Price != 12.34 {
+		t.Fatalf("unexpected income %v", income)
+	}
+	if reinvest := byType["REINVEST"]; reinvest == nil || reinvest.Shares != 0.06 {
+		t.Fatalf("unexpected reinvestment %v", reinvest)
+	}
+	if cash := importer.All(); len(cash) != 1 || cash[0].Amount != 500 || cash[0].Source != "brokerage" {
+		t.Fatalf("unexpected cash transactions %v", cash)
+	}
+}
+
+func TestOFXImportDeduplicatesByFITID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ofx")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	path := filepath.Join(dir, "january.qfx")
+	fail(t, ioutil.WriteFile(path, []byte(ofxBankSGML), 0600))
+	summaries, err := ImportFiles(pdb, []string{path}, "checking", "")
+	fail(t, err)
+	if summaries[0].Importer != "ofx" || summaries[0].New != 3 {
+		t.Fatalf("unexpected summary %+v", summaries[0])
+	}
+
+	// The bank later revised the memo of a transaction it had already sent
+	revised := filepath.Join(dir, "revised.ofx")
+	fail(t, ioutil.WriteFile(revised, []byte(strings.Replace(ofxBankSGML, "<NAME>GROCER &amp; CO", "<NAME>GROCER AND CO", 1)), 0600))
+	summaries, err = ImportFiles(pdb, []string{revised}, "checking", "")
+	fail(t, err)
+	if summaries[0].New != 0 || summaries[0].Duplicate != 3 {
+		t.Fatalf("expected every transaction to be a duplicate, got %+v", summaries[0])
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Granularity string
+
+const (
+	GranularityWeek    Granularity = "week"
+	GranularityMonth   Granularity = "month"
+	GranularityQuarter Granularity = "quarter"
+	GranularityYear    Granularity = "year"
+)
+
+func ParseGranularity(s string) (Granularity, error) {
+	switch Granularity(strings.ToLower(s)) {
+	case GranularityWeek, "weekly":
+		return GranularityWeek, nil
+	case GranularityMonth, "monthly":
+		return GranularityMonth, nil
+	case GranularityQuarter, "quarterly":
+		return GranularityQuarter, nil
+	case GranularityYear, "yearly", "annual":
+		return GranularityYear, nil
+	}
+	return "", fmt.Errorf("invalid period %s (expecting week, month, quarter or year)", s)
+}
+
+// Number of periods of this granularity in a year, e.g. for annualizing averages
+func (g Granularity) PerYear() float64 {
+	switch g {
+	case GranularityWeek:
+		return 52
+	case GranularityMonth:
+		return 12
+	case GranularityYear:
+		return 1
+	}
+	return 4
+}
+
+func (g Granularity) Title() string {
+	switch g {
+	case GranularityWeek:
+		return "Weekly"
+	case GranularityMonth:
+		return "Monthly"
+	case GranularityYear:
+		return "Yearly"
+	}
+	return "Quarterly"
+}
+
+// Parses the first month of the fiscal year as a number (1-12) or a name
+// (oct, october)
+func ParseFiscalYearStart(s string) (time.Month, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= 12 {
+		return time.Month(n), nil
+	}
+	for month := time.January; month <= time.December; month++ {
+		name := strings.ToLower(month.String())
+		if len(s) >= 3 && strings.HasPrefix(name, strings.ToLower(s)) {
+			return month, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid fiscal year start %s (expecting a month)", s)
+}
+
+// A Period is a week, month, quarter or year along with the transactions in
+// it.  Quarters and years follow the fiscal year, which starts in January
+// unless FiscalYearStart says otherwise.
+type Period struct {
+	Granularity     Granularity
+	FiscalYearStart time.Month
+	Start           time.Time
+	End             time.Time
+	slice           *TxSlice
+}
+
+// The period of the given granularity that contains date
+func PeriodContaining(granularity Granularity, fiscalYearStart time.Month, date time.Time) Period {
+	if fiscalYearStart == 0 {
+		fiscalYearStart = time.January
+	}
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	period := Period{Granularity: granularity, FiscalYearStart: fiscalYearStart}
+
+	// Months since the start of the fiscal year
+	offset := (int(day.Month()) - int(fiscalYearStart) + 12) % 12
+	fiscalYearStartDate := time.Date(day.Year(), day.Month()-time.Month(offset), 1, 0, 0, 0, 0, time.UTC)
+
+	switch granularity {
+	case GranularityWeek:
+		// ISO weeks start on Monday
+		period.Start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		period.End = period.Start.AddDate(0, 0, 6)
+	case GranularityMonth:
+		period.Start = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+		period.End = period.Start.AddDate(0, 1, -1)
+	case GranularityYear:
+		period.Start = fiscalYearStartDate
+		period.End = period.Start.AddDate(1, 0, -1)
+	default:
+		period.Granularity = GranularityQuarter
+		period.Start = fiscalYearStartDate.AddDate(0, (offset/3)*3, 0)
+		period.End = period.Start.AddDate(0, 3, -1)
+	}
+	return period
+}
+
+func (period Period) Next() Period {
+	return PeriodContaining(period.Granularity, period.FiscalYearStart, period.End.AddDate(0, 0, 1))
+}
+
+func (period Period) Range() DateRange {
+	return DateRange{period.Start, period.End}
+}
+
+// The fiscal year is named after the calendar year it ends in
+func (period Period) fiscalYear() int {
+	year := PeriodContaining(GranularityYear, period.FiscalYearStart, period.Start)
+	return year.End.Year()
+}
+
+// Labels look like 2021-W05, 2021-03, Q1 2021 and 2021.  With a fiscal year
+// that doesn't start in January quarters and years are FY-prefixed, e.g.
+// FY2022 Q1.
+func (period Period) Label() string {
+	fiscal := period.FiscalYearStart != time.January && period.FiscalYearStart != 0
+	switch period.Granularity {
+	case GranularityWeek:
+		year, week := period.Start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case GranularityMonth:
+		return period.Start.Format("2006-01")
+	case GranularityYear:
+		if fiscal {
+			return fmt.Sprintf("FY%d", period.fiscalYear())
+		}
+		return fmt.Sprintf("%d", period.Start.Year())
+	}
+	quarter := (int(period.Start.Month())-int(period.FiscalYearStart)+12)%12/3 + 1
+	if fiscal {
+		return fmt.Sprintf("FY%d Q%d", period.fiscalYear(), quarter)
+	}
+	return fmt.Sprintf("Q%d %d", quarter, period.Start.Year())
+}
+
+// Whether this is the last month or quarter of the fiscal year
+func (period Period) EndsFiscalYear() bool {
+	next := period.End.AddDate(0, 0, 1)
+	return period.Granularity != GranularityWeek && next.Day() == 1 && next.Month() == period.FiscalYearStart
+}
+
+func (period Period) Income() float64 {
+	return period.slice.Totals().Income
+}
+
+func (period Period) Investments() float64 {
+	return period.slice.Totals().Investments
+}
+
+func (period Period) Expenses() float64 {
+	return period.slice.Totals().Expenses
+}
+
+func (period Period) SavingsRate() float64 {
+	return period.slice.Totals().SavingsRate()
+}
+
+func (period Period) Slice() *TxSlice {
+	return period.slice
+}
+
+type Periods []Period
+
+// Splits the transactions matching query into consecutive periods, from the
+// one containing the first transaction to the one containing the last.
+// Periods without transactions are included with empty slices.
+func (pdb *PennyDb) Periods(granularity Granularity, fiscalYearStart time.Month, query Query) Periods {
+	txs := pdb.AllTransactions()
+	if len(txs) == 0 {
+		return nil
+	}
+
+	var periods Periods
+	last := txs[len(txs)-1].Date
+	for period := PeriodContaining(granularity, fiscalYearStart, txs[0].Date); !period.Start.After(last); period = period.Next() {
+		period.slice = pdb.Slice(&Filter{nil, nil, query, period.Start, period.End})
+		periods = append(periods, period)
+	}
+	return periods
+}
+
+func (periods Periods) AvgIncome() float64 {
+	total := 0.0
+	for _, period := range periods {
+		total += period.Income()
+	}
+	return total / float64(len(periods))
+}
+
+func (periods Periods) AvgExpenses() float64 {
+	total := 0.0
+	for _, period := range periods {
+		total += period.Expenses()
+	}
+	return total / float64(len(periods))
+}
+
+func (periods Periods) AvgInvestments() float64 {
+	total := 0.0
+	for _, period := range periods {
+		total += period.Investments()
+	}
+	return total / float64(len(periods))
+}
+
+// Periods without income have no savings rate and are left out
+func (periods Periods) AvgSavingsRate() float64 {
+	total, count := 0.0, 0
+	for _, period := range periods {
+		if period.Income() != 0 {
+			total += period.SavingsRate()
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodContaining(t *testing.T) {
+	for _, c := range []struct {
+		granularity Granularity
+		fiscal      time.Month
+		day         string
+		label       string
+		start, end  string
+	}{
+		{GranularityWeek, time.January, "Mar 5 2021", "2021-W09", "Mar 1 2021", "Mar 7 2021"},
+		{GranularityMonth, time.January, "Feb 15 2020", "2020-02", "Feb 1 2020", "Feb 29 2020"},
+		{GranularityQuarter, time.January, "May 15 2021", "Q2 2021", "Apr 1 2021", "Jun 30 2021"},
+		{GranularityYear, time.January, "May 15 2021", "2021", "Jan 1 2021", "Dec 31 2021"},
+		{GranularityQuarter, time.October, "Nov 3 2021", "FY2022 Q1", "Oct 1 2021", "Dec 31 2021"},
+		{GranularityQuarter, time.October, "Aug 3 2022", "FY2022 Q4", "Jul 1 2022", "Sep 30 2022"},
+		{GranularityYear, time.April, "Feb 1 2022", "FY2022", "Apr 1 2021", "Mar 31 2022"},
+	} {
+		period := PeriodContaining(c.granularity, c.fiscal, date(c.day))
+		if period.Label() != c.label || !period.Start.Equal(date(c.start)) || !period.End.Equal(date(c.end)) {
+			t.Errorf("%v: got %s %s to %s", c, period.Label(), period.Start, period.End)
+		}
+	}
+
+	quarter := PeriodContaining(GranularityQuarter, time.October, date("Aug 3 2022"))
+	if !quarter.EndsFiscalYear() || quarter.Next().Label() != "FY2023 Q1" {
+		t.Errorf("expecting FY2022 Q4 to end the fiscal year and be followed by FY2023 Q1")
+	}
+
+	if month, err := ParseFiscalYearStart("oct"); err != nil || month != time.October {
+		t.Errorf("expecting oct to be October, got %v %v", month, err)
+	}
+	if _, err := ParseGranularity("decade"); err == nil {
+		t.Errorf("expecting decade to be rejected")
+	}
+}
+
+func TestPeriodTotals(t *testing.T) {
+	tx1 := Transaction{"dcu", date("Jan 2 2018"), "PAYROLL", 1000, "", "USD", "income", false}
+	tx2 := Transaction{"dcu", date("Jan 3 2018"), "GROCERY", -250, "", "USD", "food", false}
+	tx3 := Transaction{"dcu", date("Feb 9 2018"), "RESTAURANT", -75, "", "USD", "food", false}
+
+	january := PeriodContaining(GranularityMonth, time.January, tx1.Date)
+	january.slice = &TxSlice{[]*Transaction{&tx1, &tx2}, nil}
+	february := january.Next()
+	february.slice = &TxSlice{[]*Transaction{&tx3}, nil}
+
+	periods := Periods{january, february}
+	if january.SavingsRate() != 75 || february.SavingsRate() != 0 {
+		t.Fatalf("unexpected savings rates %.1f and %.1f", january.SavingsRate(), february.SavingsRate())
+	}
+	if periods.AvgExpenses() != -162.5 || periods.AvgSavingsRate() != 75 {
+		t.Fatalf("unexpected averages %.2f and %.1f", periods.AvgExpenses(), periods.AvgSavingsRate())
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Pivot is a category by period table of totals, the view we used to build in
+// spreadsheets.  Categories are ordered by the size of their overall total.
+type Pivot struct {
+	Periods    Periods
+	Categories []string
+	Totals     map[string][]float64 // category to one total per period
+}
+
+// Splits the slice into periods from its first to its last transaction and
+// aggregates each period with CategorySummaries()
+func (slice *TxSlice) Pivot(granularity Granularity, fiscalYearStart time.Month) *Pivot {
+	pivot := &Pivot{Totals: make(map[string][]float64)}
+	if len(slice.transactions) == 0 {
+		return pivot
+	}
+
+	index := 0
+	for period := PeriodContaining(granularity, fiscalYearStart, slice.Start()); !period.Start.After(slice.End()); period = period.Next() {
+		var txs []*Transaction
+		for ; index < len(slice.transactions) && !slice.transactions[index].Date.After(period.End); index++ {
+			txs = append(txs, slice.transactions[index])
+		}
+		period.slice = &TxSlice{txs, slice.db}
+		pivot.Periods = append(pivot.Periods, period)
+	}
+
+	for column, period := range pivot.Periods {
+		for _, summary := range period.slice.CategorySummaries() {
+			if _, ok := pivot.Totals[summary.Category]; !ok {
+				pivot.Totals[summary.Category] = make([]float64, len(pivot.Periods))
+				pivot.Categories = append(pivot.Categories, summary.Category)
+			}
+			pivot.Totals[summary.Category][column] = summary.Total
+		}
+	}
+
+	sort.SliceStable(pivot.Categories, func(i, j int) bool {
+		return math.Abs(pivot.RowTotal(pivot.Categories[i])) > math.Abs(pivot.RowTotal(pivot.Categories[j]))
+	})
+
+	return pivot
+}
+
+func (pivot *Pivot) RowTotal(category string) float64 {
+	total := 0.0
+	for _, amount := range pivot.Totals[category] {
+		total += amount
+	}
+	return total
+}
+
+func (pivot *Pivot) ColumnTotal(column int) float64 {
+	total := 0.0
+	for _, totals := range pivot.Totals {
+		total += totals[column]
+	}
+	return total
+}
+
+func (pivot *Pivot) Table(base string) *Table {
+	table := &Table{Name: "pivot", Columns: []Column{{"Category", "category"}}}
+	for _, period := range pivot.Periods {
+		table.Columns = append(table.Columns, Column{period.Label(), jsonKey(period.Label())})
+	}
+	table.Columns = append(table.Columns, Column{"Total", "total"}, Column{"Average", "average"})
+
+	count := math.Max(1, float64(len(pivot.Periods)))
+	for _, category := range pivot.Categories {
+		row := []interface{}{categoryName(category)}
+		for _, amount := range pivot.Totals[category] {
+			row = append(row, Money{amount, base})
+		}
+		total := pivot.RowTotal(category)
+		row = append(row, Money{total, base}, Money{total / count, base})
+		table.Rows = append(table.Rows, row)
+	}
+
+	footer := []interface{}{"TOTAL"}
+	grandTotal := 0.0
+	for column := range pivot.Periods {
+		total := pivot.ColumnTotal(column)
+		grandTotal += total
+		footer = append(footer, Money{total, base})
+	}
+	table.Footer = append(footer, Money{grandTotal, base}, Money{grandTotal / count, base})
+
+	return table
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPivot(t *testing.T) {
+	slice := &TxSlice{[]*Transaction{
+		{"dcu", date("Jan 2 2018"), "PAYROLL", 1000, "", "USD", "income", false},
+		{"dcu", date("Jan 3 2018"), "GROCERY", -50, "", "USD", "food", false},
+		{"dcu", date("Jan 20 2018"), "GROCERY", -30, "", "USD", "food", false},
+		{"dcu", date("Mar 9 2018"), "RESTAURANT", -75, "", "USD", "dining", false},
+	}, nil}
+
+	pivot := slice.Pivot(GranularityMonth, time.January)
+	if len(pivot.Periods) != 3 || pivot.Periods[1].Label() != "2018-02" {
+		t.Fatalf("expecting January through March including an empty February, got %v", pivot.Periods)
+	}
+	if pivot.Categories[0] != "income" || pivot.Totals["food"][0] != -80 || pivot.Totals["dining"][2] != -75 {
+		t.Fatalf("unexpected pivot %v %v", pivot.Categories, pivot.Totals)
+	}
+	if pivot.ColumnTotal(0) != 920 || pivot.RowTotal("food") != -80 {
+		t.Fatalf("unexpected totals %.2f %.2f", pivot.ColumnTotal(0), pivot.RowTotal("food"))
+	}
+
+	table := pivot.Table("USD")
+	if len(table.Columns) != 6 || table.Columns[1].Key != "2018_01" {
+		t.Fatalf("unexpected columns %v", table.Columns)
+	}
+	if average := table.Footer[5].(Money).Amount; average != 845.0/3 {
+		t.Fatalf("unexpected average %.2f", average)
+	}
+
+	if quarters := slice.Pivot(GranularityQuarter, time.January); len(quarters.Periods) != 1 || quarters.Totals["food"][0] != -80 {
+		t.Fatalf("expecting a single quarter")
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A QIF record is the lines between two ^ separators, each a one letter code
+// followed by a value
+type qifRecord struct {
+	Section string // lowercase type, e.g. bank, ccard, invst, security
+	Account string // name from the last !Account block
+	Fields  [][2]string
+}
+
+func (record *qifRecord) Get(code string) string {
+	for _, field := range record.Fields {
+		if field[0] == code {
+			return field[1]
+		}
+	}
+	return ""
+}
+
+func parseQIF(contents []byte) ([]*qifRecord, error) {
+	var records []*qifRecord
+	section, account := "", ""
+	current := &qifRecord{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			header := strings.ToLower(strings.TrimSpace(line[1:]))
+			switch {
+			case strings.HasPrefix(header, "type:"):
+				section = strings.TrimSpace(strings.TrimPrefix(header, "type:"))
+			case header == "account":
+				section = "account"
+			}
+			continue
+		}
+
+		if line[0] == '^' {
+			if len(current.Fields) > 0 {
+				current.Section, current.Account = section, account
+				if section == "account" {
+					account = current.Get("N")
+				} else {
+					records = append(records, current)
+				}
+			}
+			current = &qifRecord{}
+			continue
+		}
+
+		current.Fields = append(current.Fields, [2]string{line[:1], strings.TrimSpace(line[1:])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(current.Fields) > 0 && section != "account" {
+		current.Section, current.Account = section, account
+		records = append(records, current)
+	}
+	return records, nil
+}
+
+var qifDate = regexp.MustCompile(`^(\d{1,2})[/.-](\d{1,2})(?:[/.-]|')(\d{2}|\d{4})$`)
+
+// Quicken writes US dates with a 2 or 4 digit year, using an apostrophe
+// before 2 digit years after 1999 (1/15'21)
+func parseQIFDate(value string) (time.Time, error) {
+	match := qifDate.FindStringSubmatch(strings.ReplaceAll(value, " ", ""))
+	if match == nil {
+		return time.Time{}, fmt.Errorf("invalid QIF date %q", value)
+	}
+	month, _ := strconv.Atoi(match[1])
+	day, _ := strconv.Atoi(match[2])
+	year, _ := strconv.Atoi(match[3])
+	if year < 100 {
+		year += 1900
+		if year < 1950 {
+			year += 100
+		}
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("invalid QIF date %q", value)
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), nil
+}
+
+func parseQIFAmount(value string) (float64, error) {
+	value = strings.ReplaceAll(value, ",", "")
+	if len(value) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// QIF investment actions and the investment types they're stored as
+var qifActions = map[string]string{
+	"buy":      "BUY",
+	"buyx":     "BUY",
+	"shrsin":   "BUY",
+	"sell":     "SELL",
+	"sellx":    "SELL",
+	"shrsout":  "SELL",
+	"reinvdiv": "REINVEST",
+	"reinvint": "REINVEST",
+	"reinvlg":  "REINVEST",
+	"reinvsh":  "REINVEST",
+	"div":      "INCOME:DIV",
+	"divx":     "INCOME:DIV",
+	"intinc":   "INCOME:INTEREST",
+	"cglong":   "INCOME:CGLONG",
+	"cgshort":  "INCOME:CGSHORT",
+}
+
+// QIFImporter reads Quicken exports of bank, credit card, cash and investment
+// accounts.  Transactions take their source from the !Account block they're
+// in unless one is given.  A split transaction becomes one transaction per
+// split, disambiguated as s1, s2, ... with the split memo after the payee.
+type QIFImporter struct{}
+
+func (QIFImporter) Name() string {
+	return "qif"
+}
+
+func (QIFImporter) Detect(filename string, contents []byte) int {
+	if strings.ToLower(filepath.Ext(filename)) == ".qif" {
+		return 10
+	}
+	head := strings.ToLower(string(bytes.TrimSpace(contents)))
+	if strings.HasPrefix(head, "!type:") || strings.HasPrefix(head, "!account") || strings.HasPrefix(head, "!option:") {
+		return 10
+	}
+	return 0
+}
+
+func (qif QIFImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	records, err := parseQIF(contents)
+	if err != nil {
+		return err
+	}
+
+	// Investment records name the security, which the security list maps to
+	// a symbol
+	symbols := make(map[string]string)
+	for _, record := range records {
+		if record.Section == "security" && len(record.Get("S")) > 0 {
+			symbols[record.Get("N")] = record.Get("S")
+		}
+	}
+
+	for index, record := range records {
+		switch record.Section {
+		case "bank", "ccard", "cash", "oth a", "oth l":
+			err = qif.importTransaction(record, defaultString(source, record.Account), importer)
+		case "invst":
+			err = qif.importInvestment(record, defaultString(source, record.Account), symbols, importer)
+		default:
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("qif: record %d: %v", index+1, err)
+			if importer.SkipInvalid {
+				importer.Rejected = append(importer.Rejected, err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (QIFImporter) importTransaction(record *qifRecord, source string, importer *TransactionImporter) error {
+	if len(source) == 0 {
+		return fmt.Errorf("no account name in the file (use --source)")
+	}
+
+	date, err := parseQIFDate(record.Get("D"))
+	if err != nil {
+		return err
+	}
+	amount, err := parseQIFAmount(defaultString(record.Get("T"), record.Get("U")))
+	if err != nil {
+		return err
+	}
+
+	memo := record.Get("P")
+	if extra := record.Get("M"); len(memo) == 0 {
+		memo = extra
+	} else if len(extra) > 0 && !strings.Contains(memo, extra) {
+		memo = memo + " " + extra
+	}
+
+	type split struct {
+		category, memo, amount string
+	}
+	var splits []*split
+	for _, field := range record.Fields {
+		switch field[0] {
+		case "S":
+			splits = append(splits, &split{category: field[1]})
+		case "E":
+			if len(splits) > 0 {
+				splits[len(splits)-1].memo = field[1]
+			}
+		case "$":
+			if len(splits) > 0 {
+				splits[len(splits)-1].amount = field[1]
+			}
+		}
+	}
+
+	if len(splits) == 0 {
+		importer.Add(&Transaction{source, date, memo, amount, "", "", record.Get("L"), false})
+		return nil
+	}
+
+	for index, s := range splits {
+		splitAmount, err := parseQIFAmount(s.amount)
+		if err != nil {
+			return err
+		}
+		splitMemo := memo
+		if len(s.memo) > 0 {
+			splitMemo = memo + " / " + s.memo
+		}
+		importer.Add(&Transaction{source, date, splitMemo, splitAmount, fmt.Sprintf("s%d", index+1), "", s.category, false})
+	}
+	return nil
+}
+
+func (QIFImporter) importInvestment(record *qifRecord, account string, symbols map[string]string, importer *TransactionImporter) error {
+	kind, ok := qifActions[strings.ToLower(record.Get("N"))]
+	if !ok {
+		return nil // cash movements and other actions that don't change holdings
+	}
+
+	number, err := strconv.ParseInt(strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, account), 10, 64)
+	if err != nil {
+		return fmt.Errorf("investment account %q isn't a number (use --source with the account number)", account)
+	}
+
+	date, err := parseQIFDate(record.Get("D"))
+	if err != nil {
+		return err
+	}
+	shares, err := parseQIFAmount(record.Get("Q"))
+	if err != nil {
+		return err
+	}
+	price, err := parseQIFAmount(record.Get("I"))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case kind == "SELL":
+		shares = -math.Abs(shares)
+	case strings.HasPrefix(kind, "INCOME"):
+		total, err := parseQIFAmount(record.Get("T"))
+		if err != nil {
+			return err
+		}
+		shares, price = 0, total
+	default:
+		shares = math.Abs(shares)
+	}
+
+	security := record.Get("Y")
+	importer.AddInvestment(&Investment{number, date, kind, defaultString(symbols[security], security), shares, price, "", ""})
+	return nil
+}
+
+var qifSplitDisambiguation = regexp.MustCompile(`^s(\d+)$`)
+
+func qifAmount(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// Writes transactions grouped into one account per source, and investments
+// into one account per account number.  Transactions that were imported as
+// splits are written as a single split transaction again.
+func WriteQIF(writer io.Writer, transactions []*Transaction, investments []*Investment, accounts []*Account) error {
+	w := bufio.NewWriter(writer)
+
+	bySource := make(map[string][]*Transaction)
+	for _, tx := range transactions {
+		bySource[tx.Source] = append(bySource[tx.Source], tx)
+	}
+	var sources []string
+	for source := range bySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		qifType := "Bank"
+		if account := findAccount(accounts, source); account != nil && account.Type == AccountCredit {
+			qifType = "CCard"
+		}
+		fmt.Fprintf(w, "!Account\nN%s\nT%s\n^\n!Type:%s\n", source, qifType, qifType)
+
+		// Splits of one transaction share a date and the memo before " / "
+		splits := make(map[string][]*Transaction)
+		splitKey := func(tx *Transaction) string {
+			return tx.Date.Format("2006-01-02") + strings.SplitN(tx.Memo, " / ", 2)[0]
+		}
+		for _, tx := range bySource[source] {
+			if qifSplitDisambiguation.MatchString(tx.Disambiguation) {
+				splits[splitKey(tx)] = append(splits[splitKey(tx)], tx)
+			}
+		}
+
+		for _, tx := range bySource[source] {
+			if !qifSplitDisambiguation.MatchString(tx.Disambiguation) {
+				fmt.Fprintf(w, "D%s\nT%s\nP%s\n", tx.Date.Format("01/02/2006"), qifAmount(tx.Amount), tx.Memo)
+				if len(tx.Category) > 0 {
+					fmt.Fprintf(w, "L%s\n", tx.Category)
+				}
+				w.WriteString("^\n")
+				continue
+			}
+
+			group, ok := splits[splitKey(tx)]
+			if !ok {
+				continue // already written with the first split
+			}
+			delete(splits, splitKey(tx))
+
+			sort.SliceStable(group, func(i, j int) bool {
+				a, _ := strconv.Atoi(qifSplitDisambiguation.FindStringSubmatch(group[i].Disambiguation)[1])
+				b, _ := strconv.Atoi(qifSplitDisambiguation.FindStringSubmatch(group[j].Disambiguation)[1])
+				return a < b
+			})
+			total := 0.0
+			for _, split := range group {
+				total += split.Amount
+			}
+			fmt.Fprintf(w, "D%s\nT%s\nP%s\n", tx.Date.Format("01/02/2006"), qifAmount(total), strings.SplitN(tx.Memo, " / ", 2)[0])
+			for _, split := range group {
+				fmt.Fprintf(w, "S%s\n", split.Category)
+				if parts := strings.SplitN(split.Memo, " / ", 2); len(parts) == 2 {
+					fmt.Fprintf(w, "E%s\n", parts[1])
+				}
+				fmt.Fprintf(w, "$%s\n", qifAmount(split.Amount))
+			}
+			w.WriteString("^\n")
+		}
+	}
+
+	byAccount := make(map[int64][]*Investment)
+	var numbers []int64
+	for _, investment := range investments {
+		if _, ok := byAccount[investment.Account]; !ok {
+			numbers = append(numbers, investment.Account)
+		}
+		byAccount[investment.Account] = append(byAccount[investment.Account], investment)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	actions := map[string]string{
+		"BUY":             "Buy",
+		"SELL":            "Sell",
+		"REINVEST":        "ReinvDiv",
+		"INCOME:DIV":      "Div",
+		"INCOME:INTEREST": "IntInc",
+		"INCOME:CGLONG":   "CGLong",
+		"INCOME:CGSHORT":  "CGShort",
+	}
+
+	for _, number := range numbers {
+		fmt.Fprintf(w, "!Account\nN%d\nTInvst\n^\n!Type:Invst\n", number)
+		for _, investment := range byAccount[number] {
+			action, ok := actions[strings.ToUpper(investment.Type)]
+			if !ok {
+				action = "Buy"
+				if investment.Shares < 0 {
+					action = "Sell"
+				}
+			}
+
+			fmt.Fprintf(w, "D%s\nN%s\nY%s\n", investment.Date.Format("01/02/2006"), action, investment.Symbol)
+			if strings.HasPrefix(action, "Div") || action == "IntInc" || strings.HasPrefix(action, "CG") {
+				fmt.Fprintf(w, "T%s\n", qifAmount(investment.Price))
+			} else {
+				shares := math.Abs(investment.Shares)
+				fmt.Fprintf(w, "I%s\nQ%s\nT%s\n",
+					strconv.FormatFloat(investment.Price, 'f', -1, 64),
+					strconv.FormatFloat(shares, 'f', -1, 64),
+					qifAmount(shares*investment.Price))
+			}
+			w.WriteString("^\n")
+		}
+	}
+
+	return w.Flush()
+}
+
+func init() {
+	RegisterImporter(QIFImporter{})
+}
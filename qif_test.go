@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const qifSample = `!Account
+NChecking
+TBank
+^
+!Type:Bank
+D1/15'21
+T-42.10
+PGROCER
+LFood:Groceries
+^
+D01/20/2021
+T-150.00
+PCOSTCO
+MMonthly run
+SFood:Groceries
+EFood
+$-100.00
+SHousehold
+$-50.00
+^
+!Account
+NVisa
+TCCard
+^
+!Type:CCard
+D 2/ 1'21
+U-9.99
+PNETFLIX
+LEntertainment
+^
+!Type:Security
+NVanguard Total Stock
+SVTSAX
+TMutual Fund
+^
+!Account
+N12345
+TInvst
+^
+!Type:Invst
+D1/4'21
+NBuy
+YVanguard Total Stock
+I200.5
+Q10
+T2005.00
+^
+D1/15'21
+NDiv
+YVanguard Total Stock
+T12.34
+^
+D1/20'21
+NXIn
+T500.00
+^
+`
+
+func TestParseQIFDate(t *testing.T) {
+	for value, expected := range map[string]string{
+		"1/15'21":    "2021-01-15",
+		"01/15/2021": "2021-01-15",
+		" 2/ 1'21":   "2021-02-01",
+		"12/31/99":   "1999-12-31",
+		"3-7-2020":   "2020-03-07",
+	} {
+		date, err := parseQIFDate(value)
+		fail(t, err)
+		if date.Format("2006-01-02") != expected {
+			t.Fatalf("expected %s for %q, got %s", expected, value, date.Format("2006-01-02"))
+		}
+	}
+	if _, err := parseQIFDate("13/01/2021"); err == nil {
+		t.Fatalf("expected an error for month 13")
+	}
+}
+
+func TestQIFImporter(t *testing.T) {
+	if (QIFImporter{}).Detect("export.txt", []byte(qifSample)) == 0 {
+		t.Fatalf("expected QIF contents to be detected")
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, QIFImporter{}.Import("", []byte(qifSample), importer))
+
+	txs := importer.All()
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Amount < txs[j].Amount })
+	if len(txs) != 4 {
+		t.Fatalf("expected 4 transactions, got %d: %v", len(txs), txs)
+	}
+
+	food, household, grocer, netflix := txs[0], txs[1], txs[2], txs[3]
+	if food.Source != "Checking" || food.Memo != "COSTCO Monthly run / Food" || food.Category != "Food:Groceries" || food.Disambiguation != "s1" {
+		t.Fatalf("unexpected first split %v (%s, %s)", food, food.Category, food.Disambiguation)
+	}
+	if household.Amount != -50 || household.Memo != "COSTCO Monthly run" || household.Disambiguation != "s2" {
+		t.Fatalf("unexpected second split %v", household)
+	}
+	if grocer.Amount != -42.10 || grocer.Category != "Food:Groceries" || grocer.Date.Format("2006-01-02") != "2021-01-15" {
+		t.Fatalf("unexpected transaction %v", grocer)
+	}
+	if netflix.Source != "Visa" || netflix.Amount != -9.99 {
+		t.Fatalf("unexpected card transaction %v", netflix)
+	}
+
+	investments := importer.AllInvestments()
+	sort.Slice(investments, func(i, j int) bool { return investments[i].Date.Before(investments[j].Date) })
+	if len(investments) != 2 {
+		t.Fatalf("expected 2 investments, got %d", len(investments))
+	}
+	if buy := investments[0]; buy.Account != 12345 || buy.Type != "BUY" || buy.Symbol != "VTSAX" || buy.Shares != 10 || buy.Price != 200.5 {
+		t.Fatalf("unexpected buy %v", buy)
+	}
+	if div := investments[1]; div.Type != "INCOME:DIV" || div.Shares != 0 || div.Price != 12.34 {
+		t.Fatalf("unexpected dividend %v", div)
+	}
+}
+
+func TestQIFRoundTrip(t *testing.T) {
+	date := time.Date(2021, time.March, 5, 0, 0, 0, 0, time.UTC)
+	txs := []*Transaction{
+		{"card", date, "AIRLINE", -300, "", "USD", "Travel", false},
+		{"checking", date, "COSTCO / Food", -100, "s1", "USD", "Groceries", false},
+		{"checking", date, "COSTCO", -50, "s2", "USD", "Household", false},
+		{"checking", date.AddDate(0, 0, 1), "SALARY", 2000, "", "USD", "income", false},
+	}
+	investments := []*Investment{
+		{12345, date, "BUY", "VTI", 2.5, 200, "", "USD"},
+		{12345, date, "SELL", "VTI", -1, 210, "", "USD"},
+		{12345, date, "INCOME:DIV", "VTI", 0, 3.21, "", "USD"},
+	}
+	accounts := []*Account{{"card", "Visa", "", AccountCredit, "", "", "USD", false}}
+
+	var buffer bytes.Buffer
+	fail(t, WriteQIF(&buffer, txs, investments, accounts))
+	qif := buffer.String()
+	if !strings.Contains(qif, "!Type:CCard\nD03/05/2021\nT-300.00\nPAIRLINE\nLTravel\n^") {
+		t.Fatalf("expected a credit card account in\n%s", qif)
+	}
+	if !strings.Contains(qif, "T-150.00\nPCOSTCO\nSGroceries\nEFood\n$-100.00\nSHousehold\n$-50.00\n^") {
+		t.Fatalf("expected the splits to be joined in\n%s", qif)
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, QIFImporter{}.Import("", buffer.Bytes(), importer))
+
+	imported := make(map[string]*Transaction)
+	for _, tx := range importer.All() {
+		imported[tx.Id()] = tx
+	}
+	for _, tx := range txs {
+		if match, ok := imported[tx.Id()]; !ok || match.Category != tx.Category || match.Source != tx.Source {
+			t.Fatalf("transaction %v didn't survive the round trip: %v", tx, importer.All())
+		}
+	}
+
+	importedInvestments := make(map[string]bool)
+	for _, investment := range importer.AllInvestments() {
+		importedInvestments[investment.Id()] = true
+	}
+	for _, investment := range investments {
+		if !importedInvestments[investment.Id()] {
+			t.Fatalf("investment %v didn't survive the round trip: %v", investment, importer.AllInvestments())
+		}
+	}
+}
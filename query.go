@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A Query is a boolean expression over transaction fields, for example:
+//
+//	amount < -100 and source in (chase, dcu) and not category:payoff and ignored=false
+//
+// Comparisons are written as `field op value` where op is one of
+// = != < <= > >= or ~ (regular expression).  `field in (a, b)` matches any of
+// the listed values and `field:value` is shorthand for category, tag, source
+// and kind equality or a case-insensitive substring match on memo and payee.
+// Expressions are combined with and, or, not and parentheses.
+type Query interface {
+	Match(tx *Transaction, db *PennyDb) bool
+	String() string
+}
+
+type queryAnd struct{ left, right Query }
+type queryOr struct{ left, right Query }
+type queryNot struct{ operand Query }
+
+func (q queryAnd) Match(tx *Transaction, db *PennyDb) bool {
+	return q.left.Match(tx, db) && q.right.Match(tx, db)
+}
+
+func (q queryOr) Match(tx *Transaction, db *PennyDb) bool {
+	return q.left.Match(tx, db) || q.right.Match(tx, db)
+}
+
+func (q queryNot) Match(tx *Transaction, db *PennyDb) bool {
+	return !q.operand.Match(tx, db)
+}
+
+func (q queryAnd) String() string { return fmt.Sprintf("(%s and %s)", q.left, q.right) }
+func (q queryOr) String() string  { return fmt.Sprintf("(%s or %s)", q.left, q.right) }
+func (q queryNot) String() string { return fmt.Sprintf("not %s", q.operand) }
+
+type queryPredicate struct {
+	field  string
+	op     string
+	values []string
+	match  func(tx *Transaction, db *PennyDb) bool
+}
+
+func (q *queryPredicate) Match(tx *Transaction, db *PennyDb) bool {
+	return q.match(tx, db)
+}
+
+func (q *queryPredicate) String() string {
+	if q.op == "in" {
+		return fmt.Sprintf("%s in (%s)", q.field, strings.Join(q.values, ", "))
+	}
+	return fmt.Sprintf("%s%s%s", q.field, q.op, q.values[0])
+}
+
+var queryStringFields = map[string]func(tx *Transaction, db *PennyDb) []string{
+	"source":   func(tx *Transaction, db *PennyDb) []string { return []string{tx.Source} },
+	"category": func(tx *Transaction, db *PennyDb) []string { return []string{categoryName(tx.Category)} },
+	"memo":     func(tx *Transaction, db *PennyDb) []string { return []string{tx.Memo} },
+	"payee":    func(tx *Transaction, db *PennyDb) []string { return []string{tx.Payee()} },
+	"currency": func(tx *Transaction, db *PennyDb) []string { return []string{tx.Currency} },
+	"id":       func(tx *Transaction, db *PennyDb) []string { return []string{tx.Id()} },
+	"account": func(tx *Transaction, db *PennyDb) []string {
+		if db == nil {
+			return []string{tx.Source}
+		}
+		return []string{tx.Source, db.AccountName(tx.Source)}
+	},
+	"kind": func(tx *Transaction, db *PennyDb) []string {
+		if db == nil {
+			return []string{string(NewClassifier(DefaultClassificationRules()).Classify(tx))}
+		}
+		return []string{string(db.Classifier().Classify(tx))}
+	},
+	"tag": func(tx *Transaction, db *PennyDb) []string {
+		if db == nil {
+			return nil
+		}
+		return db.Tags(tx.Id())
+	},
+}
+
+func categoryName(category string) string {
+	if len(category) == 0 {
+		return "uncategorized"
+	}
+	return category
+}
+
+func newQueryPredicate(field, op string, values []string) (*queryPredicate, error) {
+	field = strings.ToLower(field)
+	q := &queryPredicate{field: field, op: op, values: values}
+
+	if op == ":" {
+		switch field {
+		case "memo", "payee":
+			needle := strings.ToLower(values[0])
+			get := queryStringFields[field]
+			q.match = func(tx *Transaction, db *PennyDb) bool {
+				return strings.Contains(strings.ToLower(get(tx, db)[0]), needle)
+			}
+			return q, nil
+		case "category", "tag", "source", "kind", "account", "currency":
+			op = "="
+		default:
+			return nil, fmt.Errorf("%s:%s is not supported (expecting category, tag, payee, memo, source, account, kind or currency)", field, values[0])
+		}
+	}
+
+	switch field {
+	case "amount":
+		var numbers []float64
+		for _, value := range values {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount %s", value)
+			}
+			numbers = append(numbers, number)
+		}
+		compare, err := queryCompare(op, len(numbers))
+		if err != nil {
+			return nil, err
+		}
+		q.match = func(tx *Transaction, db *PennyDb) bool {
+			for _, number := range numbers {
+				if compare(floatCompare(tx.Amount, number)) {
+					return true
+				}
+			}
+			return false
+		}
+	case "date":
+		// Values are date expressions, so date = 2021-03 matches all of March
+		// and date <= 2021-03 includes it
+		var dates []DateRange
+		for _, value := range values {
+			date, err := ParseDateRange(value)
+			if err != nil {
+				return nil, err
+			}
+			dates = append(dates, date)
+		}
+		compare, err := queryCompare(op, len(dates))
+		if err != nil {
+			return nil, err
+		}
+		q.match = func(tx *Transaction, db *PennyDb) bool {
+			for _, date := range dates {
+				if compare(dateRangeCompare(tx.Date, date)) {
+					return true
+				}
+			}
+			return false
+		}
+	case "ignored":
+		if op != "=" && op != "!=" {
+			return nil, fmt.Errorf("ignored only supports = and !=")
+		}
+		ignored, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %s", values[0])
+		}
+		q.match = func(tx *Transaction, db *PennyDb) bool {
+			return (tx.Ignored == ignored) == (op == "=")
+		}
+	default:
+		get, ok := queryStringFields[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s", field)
+		}
+		switch op {
+		case "=", "in", "!=":
+			q.match = func(tx *Transaction, db *PennyDb) bool {
+				for _, actual := range get(tx, db) {
+					for _, value := range values {
+						if strings.EqualFold(actual, value) {
+							return op != "!="
+						}
+					}
+				}
+				return op == "!="
+			}
+		case "~":
+			regex, err := regexp.Compile("(?i)" + values[0])
+			if err != nil {
+				return nil, err
+			}
+			q.match = func(tx *Transaction, db *PennyDb) bool {
+				for _, actual := range get(tx, db) {
+					if regex.MatchString(actual) {
+						return true
+					}
+				}
+				return false
+			}
+		default:
+			return nil, fmt.Errorf("%s does not support %s", field, op)
+		}
+	}
+
+	return q, nil
+}
+
+func floatCompare(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func dateRangeCompare(a time.Time, b DateRange) int {
+	if a.Before(b.Start) {
+		return -1
+	} else if a.After(b.End) {
+		return 1
+	}
+	return 0
+}
+
+func queryCompare(op string, count int) (func(int) bool, error) {
+	if op != "in" && count != 1 {
+		return nil, fmt.Errorf("%s expects a single value", op)
+	}
+	switch op {
+	case "=", "in":
+		return func(c int) bool { return c == 0 }, nil
+	case "!=":
+		return func(c int) bool { return c != 0 }, nil
+	case "<":
+		return func(c int) bool { return c < 0 }, nil
+	case "<=":
+		return func(c int) bool { return c <= 0 }, nil
+	case ">":
+		return func(c int) bool { return c > 0 }, nil
+	case ">=":
+		return func(c int) bool { return c >= 0 }, nil
+	}
+	return nil, fmt.Errorf("operator %s is not supported for this field", op)
+}
+
+type queryToken struct {
+	kind  string // "word", "string", "op", "eof"
+	value string
+	pos   int
+}
+
+func lexQuery(input string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(input)
+	isWord := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-./*$#@&+'", r)
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			tokens = append(tokens, queryToken{"string", string(runes[i+1 : j]), i})
+			i = j + 1
+		case strings.ContainsRune("(),:~", r):
+			tokens = append(tokens, queryToken{"op", string(r), i})
+			i++
+		case strings.ContainsRune("<>=!", r):
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected ! at position %d (use not or !=)", i+1)
+			}
+			tokens = append(tokens, queryToken{"op", op, i})
+			i += len(op)
+		case isWord(r):
+			j := i
+			for j < len(runes) && isWord(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, queryToken{"word", string(runes[i:j]), i})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i+1)
+		}
+	}
+	return append(tokens, queryToken{"eof", "", len(runes)}), nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	index  int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.index]
+}
+
+func (p *queryParser) next() queryToken {
+	token := p.tokens[p.index]
+	if token.kind != "eof" {
+		p.index++
+	}
+	return token
+}
+
+func (p *queryParser) keyword(word string) bool {
+	token := p.peek()
+	if token.kind == "word" && strings.EqualFold(token.value, word) {
+		p.index++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) errorf(token queryToken, format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), token.pos+1)
+}
+
+func (p *queryParser) parseOr() (Query, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = queryOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (Query, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = queryAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (Query, error) {
+	if p.keyword("not") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parseValue() (string, error) {
+	token := p.next()
+	if token.kind != "word" && token.kind != "string" {
+		return "", p.errorf(token, "expecting a value")
+	}
+	return token.value, nil
+}
+
+func (p *queryParser) parsePrimary() (Query, error) {
+	token := p.next()
+
+	if token.kind == "op" && token.value == "(" {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.value != ")" {
+			return nil, p.errorf(closing, "expecting )")
+		}
+		return expr, nil
+	}
+
+	if token.kind != "word" {
+		return nil, p.errorf(token, "expecting a field name")
+	}
+	field := token.value
+
+	if p.keyword("in") {
+		if open := p.next(); open.value != "(" {
+			return nil, p.errorf(open, "expecting (")
+		}
+		var values []string
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			separator := p.next()
+			if separator.value == ")" {
+				break
+			}
+			if separator.value != "," {
+				return nil, p.errorf(separator, "expecting , or )")
+			}
+		}
+		predicate, err := newQueryPredicate(field, "in", values)
+		if err != nil {
+			return nil, p.errorf(token, "%v", err)
+		}
+		return predicate, nil
+	}
+
+	op := p.next()
+	if op.kind != "op" || op.value == "(" || op.value == ")" || op.value == "," {
+		return nil, p.errorf(op, "expecting an operator after %s", field)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	predicate, err := newQueryPredicate(field, op.value, []string{value})
+	if err != nil {
+		return nil, p.errorf(token, "%v", err)
+	}
+	return predicate, nil
+}
+
+// Returns nil if the query is empty
+func ParseQuery(input string) (Query, error) {
+	if len(strings.TrimSpace(input)) == 0 {
+		return nil, nil
+	}
+
+	tokens, err := lexQuery(input)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &queryParser{tokens, 0}
+	query, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.kind != "eof" {
+		return nil, parser.errorf(token, "unexpected %s", token.value)
+	}
+	return query, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	tx := Transaction{"chase", date("Mar 5 2021"), "POS WHOLE FOODS 123", -150.25, "", "USD", "groceries", false}
+
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{"amount < -100", true},
+		{"amount >= -100", false},
+		{"amount < -100 and source in (chase, dcu)", true},
+		{"source in (dcu, amex)", false},
+		{"not category:payoff", true},
+		{"category:groceries and ignored=false", true},
+		{"ignored = true", false},
+		{"payee:whole", true},
+		{"payee:\"whole foods\"", true},
+		{"memo ~ foods$", false},
+		{"memo ~ \"^pos whole\"", true},
+		{"category = payoff or (amount > -200 and date >= 2021-03-01)", true},
+		{"date < 03/01/2021", false},
+		{"not (source = chase or source = dcu)", false},
+		{"kind = expense", true},
+		{"category != groceries", false},
+		{"category:uncategorized", false},
+	}
+
+	for _, tc := range tests {
+		query, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if query.Match(&tx, nil) != tc.match {
+			t.Errorf("expecting %s to match=%v (parsed as %s)", tc.query, tc.match, query)
+		}
+	}
+
+	for _, invalid := range []string{"amount <", "amount < abc", "bogus = 1", "(amount < 1", "source < chase", "amount < 1 junk", "date > 2021-13-01"} {
+		if _, err := ParseQuery(invalid); err == nil {
+			t.Errorf("expecting %q to be rejected", invalid)
+		}
+	}
+
+	if query, err := ParseQuery("  "); query != nil || err != nil {
+		t.Errorf("expecting empty query to parse to nil")
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type BalanceKind string
+
+const (
+	BalanceOpening   BalanceKind = "opening"
+	BalanceAssertion BalanceKind = "assertion"
+)
+
+// An opening balance is the balance of a source at the start of Date, before
+// any of that day's transactions.  An assertion is the balance at the end of
+// Date as printed on a statement.
+type Balance struct {
+	Source  string
+	Date    time.Time
+	Balance float64
+	Kind    BalanceKind
+}
+
+type Suspect struct {
+	Transaction *Transaction
+	Reason      string
+}
+
+type Reconciliation struct {
+	Source    string
+	Date      time.Time
+	Statement float64
+	Opening   *Balance
+	Computed  float64
+	Suspects  []Suspect
+	TxCount   int
+}
+
+func (r *Reconciliation) Discrepancy() float64 {
+	return r.Computed - r.Statement
+}
+
+func (r *Reconciliation) Balanced() bool {
+	return math.Abs(r.Discrepancy()) < 0.005
+}
+
+// Computes the running balance of source through the end of date and, if it
+// doesn't match the statement balance, lists the transactions most likely
+// responsible for the difference
+func Reconcile(source string, date time.Time, statement float64, transactions []*Transaction, balances []*Balance) *Reconciliation {
+	r := &Reconciliation{Source: source, Date: date, Statement: statement}
+
+	for _, balance := range balances {
+		if balance.Source != source || balance.Kind != BalanceOpening || balance.Date.After(date) {
+			continue
+		}
+		if r.Opening == nil || balance.Date.After(r.Opening.Date) {
+			r.Opening = balance
+		}
+	}
+
+	if r.Opening != nil {
+		r.Computed = r.Opening.Balance
+	}
+
+	var included []*Transaction
+	for _, tx := range transactions {
+		if tx.Source != source || tx.Date.After(date) {
+			continue
+		}
+		if r.Opening != nil && tx.Date.Before(r.Opening.Date) {
+			continue
+		}
+		included = append(included, tx)
+		r.Computed += tx.Amount
+	}
+	r.TxCount = len(included)
+
+	if r.Balanced() {
+		return r
+	}
+
+	// The last earlier assertion that balanced narrows down where the
+	// discrepancy was introduced
+	var lastGood time.Time
+	for _, balance := range balances {
+		if balance.Source != source || balance.Kind != BalanceAssertion || !balance.Date.Before(date) || balance.Date.Before(lastGood) {
+			continue
+		}
+		if r.Opening != nil && balance.Date.Before(r.Opening.Date) {
+			continue
+		}
+		running := 0.0
+		if r.Opening != nil {
+			running = r.Opening.Balance
+		}
+		for _, tx := range included {
+			if !tx.Date.After(balance.Date) {
+				running += tx.Amount
+			}
+		}
+		if math.Abs(running-balance.Balance) < 0.005 {
+			lastGood = balance.Date
+		}
+	}
+
+	discrepancy := r.Discrepancy()
+	seen := make(map[string]bool)
+	add := func(tx *Transaction, reason string) {
+		if !seen[tx.Id()] {
+			seen[tx.Id()] = true
+			r.Suspects = append(r.Suspects, Suspect{tx, reason})
+		}
+	}
+
+	for _, tx := range included {
+		if math.Abs(tx.Amount-discrepancy) < 0.005 {
+			add(tx, "amount equals the discrepancy")
+		}
+	}
+
+	for _, tx := range included {
+		if math.Abs(2*tx.Amount-discrepancy) < 0.005 {
+			add(tx, "sign may be reversed")
+		}
+	}
+
+	for i, tx := range included {
+		for _, other := range included[:i] {
+			if tx.Date.Equal(other.Date) && tx.Amount == other.Amount && tx.Memo == other.Memo {
+				add(tx, fmt.Sprintf("possible duplicate of %s", other.Id()))
+			}
+		}
+	}
+
+	for _, tx := range included {
+		if !lastGood.IsZero() && tx.Date.After(lastGood) {
+			add(tx, fmt.Sprintf("after the last balanced assertion on %s", lastGood.Format("01/02/2006")))
+		}
+	}
+
+	return r
+}
+
+// One summary row per statement, plus every suspect transaction labelled with
+// the statement it was found for.  Balances are in the currency of the account.
+func ReconciliationTables(pdb *PennyDb, reconciliations []*Reconciliation) []*Table {
+	summary := &Table{Name: "reconciliations", Columns: []Column{
+		{"Source", "source"},
+		{"Date", "date"},
+		{"Opening Balance", "opening_balance"},
+		{"Opening Date", "opening_date"},
+		{"Transactions", "transactions"},
+		{"Statement Balance", "statement_balance"},
+		{"Computed Balance", "computed_balance"},
+		{"Discrepancy", "discrepancy"},
+		{"Status", "status"},
+	}}
+	suspects := &Table{Name: "suspects", Title: "suspects", Columns: []Column{
+		{"Statement", "statement_date"},
+		{"ID", "id"},
+		{"Date", "date"},
+		{"Amount", "amount"},
+		{"Memo", "memo"},
+		{"Reason", "reason"},
+	}}
+
+	for _, r := range reconciliations {
+		currency := pdb.AccountCurrency(r.Source)
+		var opening, openingDate interface{}
+		if r.Opening != nil {
+			opening, openingDate = Money{r.Opening.Balance, currency}, Date(r.Opening.Date)
+		}
+
+		status := green("balanced")
+		if !r.Balanced() {
+			status = red("discrepancy")
+		}
+
+		summary.Append(
+			r.Source,
+			Date(r.Date),
+			opening,
+			openingDate,
+			r.TxCount,
+			Money{r.Statement, currency},
+			Money{r.Computed, currency},
+			Money{r.Discrepancy(), currency},
+			status,
+		)
+
+		for _, suspect := range r.Suspects {
+			tx := suspect.Transaction
+			suspects.Append(Date(r.Date), tx.Id(), Date(tx.Date), Money{tx.Amount, tx.Currency}, tx.Memo, suspect.Reason)
+		}
+	}
+
+	return []*Table{summary, suspects}
+}
+
+func (pdb *PennyDb) Balances() ([]*Balance, error) {
+	handle, err := pdb.OpenReadOnly()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+	return handle.AllBalances()
+}
+
+func (pdb *PennyDb) SaveBalance(balance *Balance) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	return handle.saveBalance(balance, 0)
+}
+
+// Replaces any balance of the same kind for the source on that date.  Balances
+// read from imported statements belong to the import batch.
+func (handle *PennyDbHandle) saveBalance(balance *Balance, batchId int64) error {
+	_, err := handle.Exec(
+		`DELETE FROM balance WHERE source=? AND date=? AND kind=?`,
+		balance.Source,
+		balance.Date.Format("2006-01-02"),
+		string(balance.Kind))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = handle.Exec(
+		`INSERT INTO balance (source, date, balance, kind, batch_id) VALUES (?, ?, ?, ?, ?)`,
+		balance.Source,
+		balance.Date.Format("2006-01-02"),
+		balance.Balance,
+		string(balance.Kind),
+		batchId)
+
+	return err
+}
+
+func (handle *PennyDbHandle) AllBalances() ([]*Balance, error) {
+	rows, err := handle.Query("SELECT source, date, balance, kind FROM balance ORDER BY source, date, kind DESC;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []*Balance
+	for rows.Next() {
+		var balance Balance
+		var date, kind string
+		err = rows.Scan(&balance.Source, &date, &balance.Balance, &kind)
+		if err != nil {
+			return nil, err
+		}
+		balance.Date, err = time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, err
+		}
+		balance.Kind = BalanceKind(kind)
+		balances = append(balances, &balance)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	tx1 := Transaction{"dcu", date("Jan 2 2018"), "PAYROLL", 1000, "", "USD", "income", false}
+	tx2 := Transaction{"dcu", date("Jan 3 2018"), "GROCERY", -50, "", "USD", "food", false}
+	tx3 := Transaction{"dcu", date("Jan 3 2018"), "GROCERY", -50, "0", "USD", "food", false}
+	tx4 := Transaction{"chase", date("Jan 3 2018"), "GROCERY", -75, "", "USD", "food", false}
+	tx5 := Transaction{"dcu", date("Jan 9 2018"), "RENT", -500, "", "USD", "rent", false}
+	transactions := []*Transaction{&tx1, &tx2, &tx3, &tx4, &tx5}
+
+	balances := []*Balance{
+		{"dcu", date("Jan 1 2018"), 100, BalanceOpening},
+		{"dcu", date("Jan 2 2018"), 1100, BalanceAssertion},
+	}
+
+	r := Reconcile("dcu", date("Jan 9 2018"), 500, transactions, balances)
+	if !r.Balanced() || r.TxCount != 4 {
+		t.Fatalf("expecting balanced reconciliation over 4 transactions, got computed %.2f over %d", r.Computed, r.TxCount)
+	}
+
+	r = Reconcile("dcu", date("Jan 9 2018"), 550, transactions, balances)
+	if r.Balanced() || r.Discrepancy() != -50 {
+		t.Fatalf("expecting discrepancy of -50, got %.2f", r.Discrepancy())
+	}
+
+	if len(r.Suspects) == 0 || r.Suspects[0].Transaction.Id() != tx2.Id() {
+		t.Fatalf("expecting %s to be the first suspect, got %v", tx2.Id(), r.Suspects)
+	}
+
+	for _, suspect := range r.Suspects {
+		if suspect.Transaction == &tx1 {
+			t.Fatalf("expecting transactions before the last balanced assertion to not be suspects")
+		}
+	}
+}
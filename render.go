@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Whether ANSI colors are written.  Turned off by main() when stdout isn't a
+// terminal, when NO_COLOR is set or when the output format isn't a table.
+var colorEnabled = true
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+var OutputFormats = []string{"table", "json", "csv", "tsv", "markdown"}
+
+// Typed cell values.  Renderers format these for humans (table, markdown) or
+// write the raw value (json, csv, tsv).
+type Money struct {
+	Amount   float64
+	Currency string
+}
+
+type Percent float64
+
+type Date time.Time
+
+// Cells that display differently from their machine-readable value
+type rawValuer interface {
+	Raw() interface{}
+}
+
+type Column struct {
+	Header string
+	Key    string // JSON field name
+}
+
+// A Table is the unit of output for every command.  Rows contain strings,
+// ints, float64, bool, Money, Percent or Date values.
+type Table struct {
+	Name    string // JSON field name of the table
+	Title   string
+	Columns []Column
+	Rows    [][]interface{}
+	Footer  []interface{}
+
+	// Key-value tables have a label in the first column and a value in the
+	// second and render as a single JSON object
+	KeyValue bool
+}
+
+func NewKeyValueTable(name, title string) *Table {
+	return &Table{Name: name, Title: title, Columns: []Column{{"", "key"}, {"", "value"}}, KeyValue: true}
+}
+
+func (table *Table) Append(row ...interface{}) {
+	table.Rows = append(table.Rows, row)
+}
+
+var nonAlphanumeric = regexp.MustCompile("[^a-z0-9]+")
+
+func jsonKey(label string) string {
+	return strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(label), "_"), "_")
+}
+
+type Renderer struct {
+	format string
+	writer io.Writer
+	tables []*Table
+}
+
+func NewRenderer(format string, writer io.Writer) (*Renderer, error) {
+	if !contains(format, OutputFormats) {
+		return nil, fmt.Errorf("invalid output format %s (expecting one of %s)", format, strings.Join(OutputFormats, ", "))
+	}
+	return &Renderer{format, writer, nil}, nil
+}
+
+func (r *Renderer) Format() string {
+	return r.format
+}
+
+// Human formats are written immediately, JSON is written by Flush() as a
+// single object containing every table
+func (r *Renderer) Render(table *Table) {
+	switch r.format {
+	case "table":
+		r.renderTable(table)
+	case "markdown":
+		r.renderMarkdown(table)
+	case "csv", "tsv":
+		r.renderCsv(table)
+	case "json":
+		r.tables = append(r.tables, table)
+	}
+}
+
+func (r *Renderer) Flush() error {
+	if r.format != "json" {
+		return nil
+	}
+
+	output := make(map[string]interface{})
+	for _, table := range r.tables {
+		output[table.Name] = tableJSON(table)
+	}
+
+	encoder := json.NewEncoder(r.writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+func (r *Renderer) humanCell(value interface{}, color bool) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case Money:
+		return moneyIn(v.Amount, v.Currency, color)
+	case Percent:
+		return fmt.Sprintf("%.1f%%", float64(v))
+	case Date:
+		return time.Time(v).Format("01/02/2006")
+	case float64:
+		return fmt.Sprintf("%.2f", v)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func (r *Renderer) humanRow(row []interface{}, color bool) []string {
+	cells := make([]string, len(row))
+	for index, value := range row {
+		cells[index] = r.humanCell(value, color)
+	}
+	return cells
+}
+
+func (r *Renderer) headers(table *Table) []string {
+	var headers []string
+	for _, column := range table.Columns {
+		headers = append(headers, column.Header)
+	}
+	return headers
+}
+
+func (r *Renderer) renderTable(table *Table) {
+	if len(table.Title) > 0 {
+		fmt.Fprintf(r.writer, "\n\n%s\n\n", strings.ToUpper(table.Title))
+	} else if len(r.tables) > 0 {
+		io.WriteString(r.writer, "\n")
+	}
+	r.tables = append(r.tables, table)
+
+	writer := tablewriter.NewWriter(r.writer)
+	if !table.KeyValue {
+		writer.SetHeader(r.headers(table))
+	}
+	for _, row := range table.Rows {
+		writer.Append(r.humanRow(row, true))
+	}
+	if table.Footer != nil {
+		writer.SetFooter(r.humanRow(table.Footer, false))
+	}
+	writer.Render()
+}
+
+func (r *Renderer) renderMarkdown(table *Table) {
+	escape := func(cells []string) string {
+		for index, cell := range cells {
+			cells[index] = strings.ReplaceAll(cell, "|", "\\|")
+		}
+		return "| " + strings.Join(cells, " | ") + " |\n"
+	}
+
+	if len(table.Title) > 0 {
+		fmt.Fprintf(r.writer, "## %s\n\n", table.Title)
+	}
+
+	headers := r.headers(table)
+	if table.KeyValue {
+		headers = []string{"", ""}
+	}
+	io.WriteString(r.writer, escape(headers))
+	separators := make([]string, len(headers))
+	for index := range separators {
+		separators[index] = "---"
+	}
+	io.WriteString(r.writer, "|"+strings.Join(separators, "|")+"|\n")
+
+	for _, row := range table.Rows {
+		io.WriteString(r.writer, escape(r.humanRow(row, false)))
+	}
+	if table.Footer != nil {
+		cells := r.humanRow(table.Footer, false)
+		for index, cell := range cells {
+			if len(cell) > 0 {
+				cells[index] = "**" + cell + "**"
+			}
+		}
+		io.WriteString(r.writer, escape(cells))
+	}
+	io.WriteString(r.writer, "\n")
+}
+
+func rawCell(value interface{}) interface{} {
+	switch v := value.(type) {
+	case Money:
+		return v.Amount
+	case Percent:
+		return float64(v)
+	case Date:
+		return time.Time(v).Format("2006-01-02")
+	case rawValuer:
+		return v.Raw()
+	}
+	return value
+}
+
+func (r *Renderer) renderCsv(table *Table) {
+	if len(r.tables) > 0 {
+		io.WriteString(r.writer, "\n")
+	}
+	r.tables = append(r.tables, table)
+
+	writer := csv.NewWriter(r.writer)
+	if r.format == "tsv" {
+		writer.Comma = '\t'
+	}
+
+	var keys []string
+	for _, column := range table.Columns {
+		keys = append(keys, column.Key)
+	}
+	writer.Write(keys)
+
+	rows := table.Rows
+	if table.Footer != nil {
+		rows = append(rows, table.Footer)
+	}
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for index, value := range row {
+			switch v := rawCell(value).(type) {
+			case nil:
+				record[index] = ""
+			case float64:
+				record[index] = fmt.Sprintf("%.2f", v)
+			default:
+				record[index] = fmt.Sprintf("%v", v)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+}
+
+func rowJSON(table *Table, row []interface{}) map[string]interface{} {
+	object := make(map[string]interface{})
+	for index, value := range row {
+		if index < len(table.Columns) {
+			object[table.Columns[index].Key] = rawCell(value)
+		}
+	}
+	return object
+}
+
+func tableJSON(table *Table) interface{} {
+	if table.KeyValue {
+		object := make(map[string]interface{})
+		for _, row := range table.Rows {
+			object[jsonKey(fmt.Sprintf("%v", row[0]))] = rawCell(row[1])
+		}
+		return object
+	}
+
+	rows := make([]map[string]interface{}, 0, len(table.Rows))
+	for _, row := range table.Rows {
+		rows = append(rows, rowJSON(table, row))
+	}
+
+	output := map[string]interface{}{"rows": rows}
+	if table.Footer != nil {
+		output["footer"] = rowJSON(table, table.Footer)
+	}
+	return output
+}
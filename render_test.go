@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func renderTestTables() []*Table {
+	summary := NewKeyValueTable("totals", "")
+	summary.Append("Transaction Count", 2)
+	summary.Append("Savings Rate", Percent(12.5))
+
+	table := &Table{Name: "transactions", Columns: []Column{{"Date", "date"}, {"Amount", "amount"}, {"Memo", "memo"}}}
+	table.Append(Date(date("Jan 2 2018")), Money{-12.5, "EUR"}, "COFFEE, LARGE")
+	table.Footer = []interface{}{"TOTAL", Money{-12.5, "EUR"}, nil}
+	return []*Table{summary, table}
+}
+
+func TestRenderJSON(t *testing.T) {
+	var buf bytes.Buffer
+	renderer, err := NewRenderer("json", &buf)
+	fail(t, err)
+	for _, table := range renderTestTables() {
+		renderer.Render(table)
+	}
+	fail(t, renderer.Flush())
+
+	var output struct {
+		Totals       map[string]float64 `json:"totals"`
+		Transactions struct {
+			Rows []struct {
+				Date   string  `json:"date"`
+				Amount float64 `json:"amount"`
+				Memo   string  `json:"memo"`
+			} `json:"rows"`
+			Footer map[string]interface{} `json:"footer"`
+		} `json:"transactions"`
+	}
+	fail(t, json.Unmarshal(buf.Bytes(), &output))
+
+	if output.Totals["transaction_count"] != 2 || output.Totals["savings_rate"] != 12.5 {
+		t.Fatalf("unexpected totals %v", output.Totals)
+	}
+	rows := output.Transactions.Rows
+	if len(rows) != 1 || rows[0].Date != "2018-01-02" || rows[0].Amount != -12.5 || rows[0].Memo != "COFFEE, LARGE" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	if output.Transactions.Footer["amount"] != -12.5 {
+		t.Fatalf("unexpected footer %v", output.Transactions.Footer)
+	}
+}
+
+func TestRenderCsvAndMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	renderer, err := NewRenderer("csv", &buf)
+	fail(t, err)
+	renderer.Render(renderTestTables()[1])
+	expected := "date,amount,memo\n2018-01-02,-12.50,\"COFFEE, LARGE\"\nTOTAL,-12.50,\n"
+	if buf.String() != expected {
+		t.Fatalf("expecting:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	renderer, err = NewRenderer("markdown", &buf)
+	fail(t, err)
+	renderer.Render(renderTestTables()[1])
+	if !strings.Contains(buf.String(), "| 01/02/2018 | -€12.50 | COFFEE, LARGE |") {
+		t.Fatalf("unexpected markdown:\n%s", buf.String())
+	}
+
+	if _, err := NewRenderer("xml", &buf); err == nil {
+		t.Fatalf("expecting xml to be rejected")
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	defer func(enabled bool) { colorEnabled = enabled }(colorEnabled)
+
+	colorEnabled = false
+	if money(-5, true) != "-$5.00" || green("ok") != "ok" {
+		t.Fatalf("expecting no escape codes, got %q", money(-5, true))
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -16,13 +15,16 @@ import (
 type RawFilter struct {
 	Category string `json:"category"`
 	Regex    string `json:"regex"`
+	Query    string `json:"query"`
 	Start    string `json:"start"`
 	End      string `json:"end"`
+	Period   string `json:"period"`
 }
 
 type Filter struct {
 	Categories []string
 	Regex      *regexp.Regexp
+	Query      Query
 	Start      time.Time
 	End        time.Time
 }
@@ -42,42 +44,18 @@ func ParseFilter(raw RawFilter) (*Filter, map[string]string) {
 	}
 	filter.Regex = regex
 
-	quarterRegex, err := regexp.Compile("Q(\\d)(\\d{4})")
+	query, err := ParseQuery(raw.Query)
 	if err != nil {
-		errors["regex"] = err.Error()
+		errors["query"] = err.Error()
 	}
+	filter.Query = query
 
-	quarterRegexMatch := quarterRegex.FindStringSubmatch(raw.Start)
-	if quarterRegexMatch != nil {
-		quarter, _ := strconv.ParseInt(quarterRegexMatch[1], 10, 16)
-		year, _ := strconv.ParseInt(quarterRegexMatch[2], 10, 16)
-		start, end, err := quarterToDateRange(int(quarter), int(year))
-		if err != nil {
-			errors["start"] = err.Error()
-		}
-		filter.Start = start
-		filter.End = end
-	} else {
-		if len(raw.Start) == 0 {
-			filter.Start = time.Now().Add(-365 * 24 * time.Hour)
-		} else {
-			parsed, err := time.Parse("01/02/2006", raw.Start)
-			if err != nil {
-				errors["start"] = fmt.Sprintf("Invalid date: %s (expecting format MM/DD/YYYY)", raw.Start)
-			}
-			filter.Start = parsed
-		}
-
-		if len(raw.End) == 0 {
-			filter.End = time.Now()
-		} else {
-			parsed, err := time.Parse("01/02/2006", raw.End)
-			if err != nil {
-				errors["end"] = fmt.Sprintf("Invalid date: %s (expecting format MM/DD/YYYY)", raw.End)
-			}
-			filter.End = parsed
-		}
+	dates, dateErrors := resolveDateRange(raw.Period, raw.Start, raw.End, today())
+	for field, message := range dateErrors {
+		errors[field] = message
 	}
+	filter.Start = dates.Start
+	filter.End = dates.End
 
 	if len(errors) != 0 {
 		return nil, errors
@@ -86,6 +64,41 @@ func ParseFilter(raw RawFilter) (*Filter, map[string]string) {
 	}
 }
 
+// Reads a RawFilter from the request body and applies the ?view= parameter.
+// On error the response has been written and nil is returned.
+func httpFilter(pdb *PennyDb, w http.ResponseWriter, req *http.Request) *Filter {
+	body, err := ioutil.ReadAll(req.Body)
+	check(err)
+
+	var rawFilter RawFilter
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &rawFilter)
+		check(err)
+	}
+
+	if name := req.URL.Query().Get("view"); len(name) > 0 {
+		view, err := pdb.View(name)
+		if err != nil {
+			w.WriteHeader(404)
+			body, err := json.Marshal(map[string]string{"view": err.Error()})
+			check(err)
+			fmt.Fprintf(w, "%s", string(body))
+			return nil
+		}
+		rawFilter = view.Apply(rawFilter)
+	}
+
+	filter, errors := ParseFilter(rawFilter)
+	if len(errors) > 0 {
+		w.WriteHeader(400)
+		body, err := json.Marshal(errors)
+		check(err)
+		fmt.Fprintf(w, "%s", string(body))
+		return nil
+	}
+	return filter
+}
+
 func HttpGetTransactions(pdb *PennyDb) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Add("Access-Control-Allow-Origin", "*")
@@ -95,24 +108,10 @@ func HttpGetTransactions(pdb *PennyDb) func(w http.ResponseWriter, r *http.Reque
 			return
 		}
 
-		body, err := ioutil.ReadAll(req.Body)
-		check(err)
-
-		var rawFilter RawFilter
-		if len(body) > 0 {
-			err = json.Unmarshal(body, &rawFilter)
-			check(err)
-		}
-
-		filter, errors := ParseFilter(rawFilter)
-		if len(errors) > 0 {
-			w.WriteHeader(400)
-			body, err := json.Marshal(errors)
-			check(err)
-			fmt.Fprintf(w, "%s", string(body))
+		filter := httpFilter(pdb, w, req)
+		if filter == nil {
 			return
 		}
-		fmt.Printf("%v\n", filter)
 
 		slice := pdb.Slice(filter)
 
@@ -126,6 +125,7 @@ func HttpGetTransactions(pdb *PennyDb) func(w http.ResponseWriter, r *http.Reque
 				tx.Disambiguation,
 				tx.Category,
 				fmt.Sprintf("%v", tx.Ignored),
+				tx.Currency,
 			})
 		}
 		csvWriter.Flush()
@@ -144,24 +144,10 @@ func HttpGetSummary(pdb *PennyDb) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		body, err := ioutil.ReadAll(req.Body)
-		check(err)
-
-		var rawFilter RawFilter
-		if len(body) > 0 {
-			err = json.Unmarshal(body, &rawFilter)
-			check(err)
-		}
-
-		filter, errors := ParseFilter(rawFilter)
-		if len(errors) > 0 {
-			w.WriteHeader(400)
-			body, err := json.Marshal(errors)
-			check(err)
-			fmt.Fprintf(w, "%s", string(body))
+		filter := httpFilter(pdb, w, req)
+		if filter == nil {
 			return
 		}
-		fmt.Printf("%v\n", filter)
 
 		slice := pdb.Slice(filter)
 
@@ -201,9 +187,64 @@ func HttpGetSummary(pdb *PennyDb) func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Category by period totals.  ?by= selects week, month (default), quarter or
+// year columns, ?fiscal-year-start= the first month of the year (default
+// january) and ?format= selects json (default) or csv.
+func HttpGetPivot(pdb *PennyDb) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+		w.Header().Add("Access-Control-Allow-Headers", "*")
+
+		if req.Method == "OPTIONS" {
+			return
+		}
+
+		filter := httpFilter(pdb, w, req)
+		if filter == nil {
+			return
+		}
+
+		by, format := req.URL.Query().Get("by"), req.URL.Query().Get("format")
+		if len(by) == 0 {
+			by = "month"
+		}
+		fiscal := req.URL.Query().Get("fiscal-year-start")
+		if len(fiscal) == 0 {
+			fiscal = "january"
+		}
+		if format != "csv" {
+			format = "json"
+		}
+
+		granularity, err := ParseGranularity(by)
+		if err != nil {
+			w.WriteHeader(400)
+			body, err := json.Marshal(map[string]string{"by": err.Error()})
+			check(err)
+			fmt.Fprintf(w, "%s", string(body))
+			return
+		}
+
+		fiscalYearStart, err := ParseFiscalYearStart(fiscal)
+		if err != nil {
+			w.WriteHeader(400)
+			body, err := json.Marshal(map[string]string{"fiscal-year-start": err.Error()})
+			check(err)
+			fmt.Fprintf(w, "%s", string(body))
+			return
+		}
+
+		renderer, err := NewRenderer(format, w)
+		check(err)
+		renderer.Render(pdb.Slice(filter).Pivot(granularity, fiscalYearStart).Table(pdb.BaseCurrency()))
+		check(renderer.Flush())
+	}
+}
+
 func PennyHTTPServer(port string, pdb *PennyDb) {
 	http.HandleFunc("/txs", HttpGetTransactions(pdb))
 	http.HandleFunc("/summary", HttpGetSummary(pdb))
+	http.HandleFunc("/pivot", HttpGetPivot(pdb))
 	fmt.Println("listening on :8090")
 	http.ListenAndServe(":8090", nil)
 }
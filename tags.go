@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+)
+
+// Tags of a transaction, sorted alphabetically
+func (pdb *PennyDb) Tags(id string) []string {
+	pdb.mutex.RLock()
+	defer pdb.mutex.RUnlock()
+	return pdb.tagCache[id]
+}
+
+func (pdb *PennyDb) SaveTags(id string, add []string, remove []string) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	for _, tag := range add {
+		_, err = handle.Exec(`INSERT OR IGNORE INTO tx_tag (tx_id, tag) VALUES (?, ?)`, id, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range remove {
+		_, err = handle.Exec(`DELETE FROM tx_tag WHERE tx_id=? AND tag=?`, id, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	pdb.tagCache, err = handle.AllTags()
+	return err
+}
+
+func (handle *PennyDbHandle) AllTags() (map[string][]string, error) {
+	rows, err := handle.Query("SELECT tx_id, tag FROM tx_tag;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var id, tag string
+		err = rows.Scan(&id, &tag)
+		if err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, list := range tags {
+		sort.Strings(list)
+	}
+
+	return tags, nil
+}
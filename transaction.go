@@ -11,8 +11,6 @@ import (
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/olekukonko/tablewriter"
 )
 
 type Transaction struct {
@@ -22,6 +20,7 @@ type Transaction struct {
 	Memo           string
 	Amount         float64
 	Disambiguation string
+	Currency       string
 
 	// The following fields are set by users
 	Category string
@@ -55,6 +54,7 @@ func (tx *Transaction) Copy() *Transaction {
 		tx.Memo,
 		tx.Amount,
 		tx.Disambiguation,
+		tx.Currency,
 		tx.Category,
 		tx.Ignored,
 	}
@@ -74,6 +74,26 @@ func (tx *Transaction) Id() string {
 	return hex.EncodeToString(hasher.Sum(nil))[:10]
 }
 
+// Payee is a normalized form of the memo with card prefixes and reference
+// numbers removed, so that transactions with the same merchant compare equal
+func (tx *Transaction) Payee() string {
+	var words []string
+	for _, word := range strings.Fields(strings.ToUpper(tx.Memo)) {
+		if contains(word, []string{"POS", "DEBIT", "CREDIT", "PURCHASE", "ACH", "CHECKCARD", "SQ", "TST"}) {
+			continue
+		}
+		word = strings.Trim(word, "*#-.,:")
+		if len(word) == 0 || strings.ContainsAny(word, "0123456789") {
+			continue
+		}
+		words = append(words, word)
+		if len(words) == 2 {
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
 func (tx *Transaction) String() string {
 	return fmt.Sprintf(
 		"%s %s %.2f %s %s %s %v", tx.Id(), tx.Date.Format("01/02/2006"), tx.Amount, tx.Memo, tx.Source, tx.Category, tx.Ignored,
@@ -85,7 +105,7 @@ func (tx *Transaction) TableRow() []string {
 	if tx.Ignored {
 		ignored = "✘"
 	}
-	return []string{ignored, tx.Source, tx.Date.Format("01/02/2006"), money(tx.Amount, false), tx.Category, tx.Memo}
+	return []string{ignored, tx.Source, tx.Date.Format("01/02/2006"), moneyIn(tx.Amount, tx.Currency, false), tx.Category, tx.Memo}
 }
 
 func (tx *Transaction) CsvRow() []string {
@@ -126,7 +146,7 @@ func (slice *TxSlice) End() time.Time {
 
 func (slice *TxSlice) Total() float64 {
 	var total float64 = 0
-	for _, tx := range slice.transactions {
+	for _, tx := range slice.effectiveTransactions() {
 		if !tx.Ignored {
 			total += tx.Amount
 		}
@@ -134,6 +154,55 @@ func (slice *TxSlice) Total() float64 {
 	return total
 }
 
+func (slice *TxSlice) Classifier() *Classifier {
+	if slice.db == nil {
+		return NewClassifier(DefaultClassificationRules())
+	}
+	return slice.db.Classifier()
+}
+
+func (slice *TxSlice) Totals() Totals {
+	return slice.Classifier().Totals(slice.effectiveTransactions())
+}
+
+// Refunds and reimbursements that are linked to another transaction count
+// against the category of that transaction, transactions that funded a
+// PayPal or Venmo payment are ignored in favor of the payment, and amounts in
+// other currencies are converted to the base currency on the date of the
+// transaction.  Amounts that can't be converted are left out.
+func (slice *TxSlice) effectiveTransactions() []*Transaction {
+	if slice.db == nil {
+		return slice.transactions
+	}
+
+	originals := slice.db.LinkedOriginals()
+	funding := slice.db.FundingTransactions()
+	base := slice.db.BaseCurrency()
+
+	var transactions []*Transaction
+	for _, tx := range slice.transactions {
+		if original, ok := originals[tx.Id()]; ok {
+			tx = tx.Copy()
+			tx.Category = original.Category
+		}
+		if funding[tx.Id()] {
+			tx = tx.Copy()
+			tx.Ignored = true
+		}
+		if len(tx.Currency) > 0 && tx.Currency != base {
+			amount, ok := slice.db.InBaseCurrency(tx.Amount, tx.Currency, tx.Date)
+			if !ok {
+				continue
+			}
+			tx = tx.Copy()
+			tx.Amount = amount
+			tx.Currency = base
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions
+}
+
 func (slice *TxSlice) MarkPayoffs() *TxSlice {
 	priceToTxs := make(map[float64][]*Transaction)
 	for _, tx := range slice.transactions {
@@ -309,16 +378,11 @@ func (arr CategorySummarySortAmountDescending) Less(i, j int) bool {
 }
 
 func (slice *TxSlice) CategorySummaries() []CategorySummary {
-	income := 0.0
-	for _, tx := range slice.transactions {
-		if tx.Category == "income" {
-			income += tx.Amount
-		}
-	}
+	income := slice.Totals().Income
 
 	totalByCategory := make(map[string]float64)
 	transactionCountByCategory := make(map[string]int)
-	for _, tx := range slice.transactions {
+	for _, tx := range slice.effectiveTransactions() {
 		if tx.Ignored {
 			continue
 		}
@@ -341,211 +405,72 @@ func (slice *TxSlice) CategorySummaries() []CategorySummary {
 	return result
 }
 
-func (slice *TxSlice) TableRows(color bool) []string {
-	maxColumnWidth := make(map[int]int)
-	for _, tx := range slice.transactions {
-		for col, cell := range tx.TableRow() {
-			currentMax, ok := maxColumnWidth[col]
-			if !ok || len(cell) > currentMax {
-				maxColumnWidth[col] = len(cell)
-			}
-		}
-	}
-
-	var table []string
-	for _, tx := range slice.transactions {
-		var colors = map[int]func(s string) string{
-			0: nocolor,
-			1: nocolor,
-			2: nocolor,
-			3: nocolor,
-			4: nocolor,
-			5: nocolor,
-		}
-
-		if color {
-			if tx.Amount > 0 {
-				colors[3] = green
-			} else {
-				colors[3] = red
-			}
-		}
-
-		var rowString = ""
-		for i, cell := range tx.TableRow() {
-			rowString += colors[i](PadRight(cell, " ", maxColumnWidth[i]+1))
-		}
-
-		table = append(table, rowString)
-	}
-	return table
-}
-
-func (slice *TxSlice) WriteHumanReadableTable(writer io.Writer) {
-	for _, row := range slice.TableRows(true) {
-		io.WriteString(writer, row)
-		io.WriteString(writer, "\n")
+// Same as tx.TableRow() but with the source replaced by its account name
+func (slice *TxSlice) tableRow(tx *Transaction) []string {
+	row := tx.TableRow()
+	if slice.db != nil {
+		row[1] = slice.db.AccountName(tx.Source)
 	}
+	return row
 }
 
-func (slice *TxSlice) WriteHumanReadableTotals(writer io.Writer) {
+// Summary of the slice and a breakdown by category
+func (slice *TxSlice) TotalsTables() []*Table {
 	elapsedDays := slice.ElapsedDays()
-	var income float64
-	var expenses float64
-	var investment float64
-
-	for _, tx := range slice.transactions {
-		if tx.Ignored && strings.Contains(tx.Memo, "VANGUARD BUY") {
-			investment += -tx.Amount
-			continue
-		}
-
-		if tx.Category == "payoff" || tx.Ignored == true {
-			continue
-		}
-
-		if tx.Category == "income" {
-			income += tx.Amount
-		} else {
-			expenses += tx.Amount
-		}
-	}
-
-	expensesMonthly := (expenses / elapsedDays) * 30.5
-	rate := (1 - (-(expenses / income))) * 100
-
-	table := tablewriter.NewWriter(writer)
-	table.Append([]string{"First Transaction", slice.transactions[0].Date.Format("01/02/2006")})
-	table.Append([]string{"Last Transaction", slice.transactions[len(slice.transactions)-1].Date.Format("01/02/2006")})
-	table.Append([]string{"Elapsed Days", fmt.Sprintf("%d", int(elapsedDays))})
-	table.Append([]string{"Transaction Count", fmt.Sprintf("%d", len(slice.transactions))})
-	table.Append([]string{"Income", money(income, true)})
-	table.Append([]string{"Expenses", money(expenses, true)})
-	table.Append([]string{"Monthly Expenses", money(expensesMonthly, true)})
-	table.Append([]string{"Post-Tax Buy Investment", money(investment, true)})
-	table.Append([]string{"Savings Rate", fmt.Sprintf("%.1f%%", rate)})
-	table.Render()
-
-	io.WriteString(writer, "\n")
+	totals := slice.Totals()
+	base := DefaultCurrency
+	if slice.db != nil {
+		base = slice.db.BaseCurrency()
+	}
+	expensesMonthly := (totals.Expenses / elapsedDays) * 30.5
+
+	summary := NewKeyValueTable("totals", "")
+	summary.Append("First Transaction", Date(slice.transactions[0].Date))
+	summary.Append("Last Transaction", Date(slice.transactions[len(slice.transactions)-1].Date))
+	summary.Append("Elapsed Days", int(elapsedDays))
+	summary.Append("Transaction Count", len(slice.transactions))
+	summary.Append("Income", Money{totals.Income, base})
+	summary.Append("Expenses", Money{totals.Expenses, base})
+	summary.Append("Monthly Expenses", Money{expensesMonthly, base})
+	summary.Append("Post-Tax Buy Investment", Money{totals.Investments, base})
+	summary.Append("Savings Rate", Percent(totals.SavingsRate()))
 
 	netTransactions := 0
 	netAmount := 0.0
 
-	table = tablewriter.NewWriter(writer)
-	table.SetHeader([]string{"Category", "#", "Total", "Per Day", "Per Week", "Per Month", "% Income"})
-	table.SetBorder(false)
+	categories := &Table{Name: "categories", Columns: []Column{
+		{"Category", "category"},
+		{"#", "transactions"},
+		{"Total", "total"},
+		{"Per Day", "per_day"},
+		{"Per Week", "per_week"},
+		{"Per Month", "per_month"},
+		{"% Income", "percent_of_income"},
+	}}
 
 	for _, summary := range slice.CategorySummaries() {
 		netAmount += summary.Total
 		netTransactions += summary.TransactionCount
 		perDay := summary.Total / elapsedDays
-		table.Append([]string{
+		categories.Append(
 			summary.Category,
-			fmt.Sprintf("%d", summary.TransactionCount),
-			money(summary.Total, true),
-			money(perDay, true),
-			money(perDay*7, true),
-			money(perDay*30, true),
-			fmt.Sprintf("%.2f%%", summary.PercentageOfIncome)})
+			summary.TransactionCount,
+			Money{summary.Total, base},
+			Money{perDay, base},
+			Money{perDay * 7, base},
+			Money{perDay * 30, base},
+			Percent(summary.PercentageOfIncome))
 	}
 
 	netAmountPerDay := netAmount / elapsedDays
-	footer := []string{
+	categories.Footer = []interface{}{
 		"TOTAL",
-		fmt.Sprintf("%d", netTransactions),
-		money(netAmount, true),
-		money(netAmountPerDay, true),
-		money(netAmountPerDay*7, true),
-		money(netAmountPerDay*30, true),
-		""}
-	table.Append(footer)
-
-	table.Render()
-}
-
-type Quarter struct {
-	quarter int
-	year    int
-	slice   *TxSlice
-}
-
-func (quarter Quarter) Income() float64 {
-	total := 0.0
-	for _, tx := range quarter.slice.transactions {
-		if tx.Category == "payoff" || tx.Ignored {
-			continue
-		}
-		if tx.Category == "income" {
-			total += tx.Amount
-		}
-	}
-	return total
-}
-
-func (quarter Quarter) Investments() float64 {
-	total := 0.0
-	for _, tx := range quarter.slice.transactions {
-		if strings.Contains(tx.Memo, "VANGUARD BUY") {
-			total += -tx.Amount
-		}
-	}
-	return total
-}
-
-func (quarter Quarter) Expenses() float64 {
-	total := 0.0
-	for _, tx := range quarter.slice.transactions {
-		if strings.Contains(tx.Memo, "VANGUARD BUY") {
-			continue
-		}
-		if tx.Category == "payoff" || tx.Ignored {
-			continue
-		}
-		if tx.Category != "income" {
-			total += tx.Amount
-		}
-	}
-	return total
-}
-
-func (quarter Quarter) SavingsRate() float64 {
-	return (1 - (-(quarter.Expenses() / quarter.Income()))) * 100
-}
-
-func (q Quarter) Slice() *TxSlice {
-	return q.slice
-}
-
-type Quarters []Quarter
-
-func (quarters Quarters) AvgIncome() float64 {
-	total := 0.0
-	for _, quarter := range quarters {
-		total += quarter.Income()
-	}
-	return total / float64(len(quarters))
-}
-
-func (quarters Quarters) AvgExpenses() float64 {
-	total := 0.0
-	for _, quarter := range quarters {
-		total += quarter.Expenses()
-	}
-	return total / float64(len(quarters))
-}
-
-func (quarters Quarters) AvgInvestments() float64 {
-	total := 0.0
-	for _, quarter := range quarters {
-		total += quarter.Investments()
-	}
-	return total / float64(len(quarters))
-}
-
-func (quarters Quarters) AvgSavingsRate() float64 {
-	total := 0.0
-	for _, quarter := range quarters {
-		total += quarter.SavingsRate()
-	}
-	return total / float64(len(quarters))
+		netTransactions,
+		Money{netAmount, base},
+		Money{netAmountPerDay, base},
+		Money{netAmountPerDay * 7, base},
+		Money{netAmountPerDay * 30, base},
+		nil}
+
+	return []*Table{summary, categories}
 }
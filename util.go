@@ -56,10 +56,16 @@ func money(amount float64, color bool) string {
 }
 
 func red(s string) string {
+	if !colorEnabled {
+		return s
+	}
 	return fmt.Sprintf("\033[38;5;1m%s\033[0m", s)
 }
 
 func green(s string) string {
+	if !colorEnabled {
+		return s
+	}
 	return fmt.Sprintf("\033[38;5;2m%s\033[0m", s)
 }
 
@@ -104,55 +110,6 @@ func abs(x time.Duration) time.Duration {
 	return x
 }
 
-func monthToQuarter(month int) int {
-	if month >= 1 && month <= 3 {
-		return 1
-	} else if month >= 4 && month <= 6 {
-		return 2
-	} else if month >= 7 && month <= 9 {
-		return 3
-	} else if month >= 10 && month <= 12 {
-		return 4
-	}
-	return 0
-}
-
-func quarterToDateRange(quarter, year int) (time.Time, time.Time, error) {
-	var startMonth, endMonth, endDay int
-	switch quarter {
-	case 1:
-		startMonth = 1
-		endMonth = 3
-		endDay = 31
-	case 2:
-		startMonth = 4
-		endMonth = 6
-		endDay = 30
-	case 3:
-		startMonth = 7
-		endMonth = 9
-		endDay = 30
-	case 4:
-		startMonth = 10
-		endMonth = 12
-		endDay = 31
-	default:
-		return time.Time{}, time.Time{}, fmt.Errorf("Invalid quarter")
-	}
-
-	start, err := time.Parse("01/02/2006", fmt.Sprintf("%02d/01/%d", startMonth, year))
-	if err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("Invalid quarter")
-	}
-
-	end, err := time.Parse("01/02/2006", fmt.Sprintf("%02d/%02d/%d", endMonth, endDay, year))
-	if err != nil {
-		return time.Time{}, time.Time{}, fmt.Errorf("Invalid quarter")
-	}
-
-	return start, end, nil
-}
-
 func FV(rate, nper, pmt, pv float64, beginning bool) float64 {
 	return (-pv * math.Pow(1+rate, nper)) + (-pmt * (math.Pow(1+rate, nper) - 1) / rate)
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A View is a named filter plus the settings used to display it
+type View struct {
+	Name    string
+	Query   string
+	Period  string // "start..end" or a single date expression like Q12021 or ytd
+	GroupBy string
+	Columns string
+	Sort    string
+}
+
+// Combines the view with an ad-hoc filter.  Queries from both are required
+// to match and the view's period replaces the filter's dates.
+func (view *View) Apply(raw RawFilter) RawFilter {
+	if len(view.Query) > 0 {
+		if len(raw.Query) > 0 {
+			raw.Query = fmt.Sprintf("(%s) and (%s)", view.Query, raw.Query)
+		} else {
+			raw.Query = view.Query
+		}
+	}
+	if len(view.Period) > 0 {
+		raw.Period = view.Period
+		raw.Start, raw.End = "", ""
+	}
+	return raw
+}
+
+func (pdb *PennyDb) Views() ([]*View, error) {
+	handle, err := pdb.OpenReadOnly()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	rows, err := handle.Query("SELECT name, query, period, group_by, columns, sort FROM view ORDER BY name;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []*View
+	for rows.Next() {
+		var view View
+		err = rows.Scan(&view.Name, &view.Query, &view.Period, &view.GroupBy, &view.Columns, &view.Sort)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, &view)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return views, nil
+}
+
+func (pdb *PennyDb) View(name string) (*View, error) {
+	handle, err := pdb.OpenReadOnly()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	var view View
+	row := handle.db.QueryRow("SELECT name, query, period, group_by, columns, sort FROM view WHERE name=?", name)
+	err = row.Scan(&view.Name, &view.Query, &view.Period, &view.GroupBy, &view.Columns, &view.Sort)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no view named %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+func (pdb *PennyDb) SaveView(view *View) error {
+	if _, err := ParseQuery(view.Query); err != nil {
+		return fmt.Errorf("invalid query: %v", err)
+	}
+	if _, err := ParseListOptions(view.GroupBy, view.Sort, view.Columns); err != nil {
+		return err
+	}
+
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	_, err = handle.Exec(
+		`REPLACE INTO view (name, query, period, group_by, columns, sort) VALUES (?, ?, ?, ?, ?, ?)`,
+		view.Name,
+		view.Query,
+		view.Period,
+		view.GroupBy,
+		view.Columns,
+		view.Sort)
+
+	return err
+}
+
+func (pdb *PennyDb) DeleteView(name string) error {
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	res, err := handle.Exec(`DELETE FROM view WHERE name=?`, name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows != 1 {
+		return fmt.Errorf("no view named %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestViews(t *testing.T) {
+	dbPath := tempFilePath()
+	defer os.Remove(dbPath)
+
+	pdb, err := NewPennyDb(dbPath, NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	err = pdb.LoadCaches()
+	fail(t, err)
+
+	err = pdb.SaveView(&View{"monthly-dining", "category:dining", "01/01/2021..01/31/2021", "week", "", "amount"})
+	fail(t, err)
+
+	if err := pdb.SaveView(&View{"broken", "amount <", "", "", "", ""}); err == nil {
+		t.Fatalf("expecting invalid query to be rejected")
+	}
+	if err := pdb.SaveView(&View{"broken", "", "", "fortnight", "", ""}); err == nil {
+		t.Fatalf("expecting invalid grouping to be rejected")
+	}
+
+	view, err := pdb.View("monthly-dining")
+	fail(t, err)
+
+	raw := view.Apply(RawFilter{"", "", "amount < -10", "01/01/2020", "01/01/2022", "ytd"})
+	if raw.Query != "(category:dining) and (amount < -10)" || raw.Start != "" || raw.End != "" || raw.Period != "01/01/2021..01/31/2021" {
+		t.Fatalf("unexpected filter %+v", raw)
+	}
+
+	// A single day stays a single day
+	yesterday := &View{"yesterday", "", "yesterday", "", "", ""}
+	filter, errors := ParseFilter(yesterday.Apply(RawFilter{}))
+	if len(errors) > 0 || !filter.Start.Equal(filter.End) {
+		t.Fatalf("expecting a single day, got %v %v", filter, errors)
+	}
+
+	fail(t, pdb.DeleteView("monthly-dining"))
+
+	if _, err := pdb.View("monthly-dining"); err == nil {
+		t.Fatalf("expecting deleted view to be gone")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Payments made through PayPal and Venmo show up twice: in the wallet's own
+// export with the real counterparty, and in the bank or card account that
+// funded them as an opaque transfer.  The funding transaction is linked to
+// the payment (funded-by) so that only the payment counts.
+
+// Wallets by the prefix of the external IDs of their payments, with the memo
+// of the transactions that fund them
+var walletFundingMemos = map[string]*regexp.Regexp{
+	"paypal": regexp.MustCompile(`(?i)paypal`),
+	"venmo":  regexp.MustCompile(`(?i)venmo`),
+}
+
+// Days between a payment and the bank or card transaction that funded it
+const fundingWindow = 5
+
+// Which wallet a transaction was paid through, from its external ID
+func walletOf(externalId string) string {
+	prefix := strings.SplitN(externalId, ":", 2)[0]
+	if _, ok := walletFundingMemos[prefix]; ok {
+		return prefix
+	}
+	return ""
+}
+
+// Proposes funded-by links from bank and card transactions to the wallet
+// payments they paid for, by amount and memo.  Payments and funding
+// transactions that are already linked are left alone.
+func FindFundingLinks(transactions []*Transaction, wallets map[string]string, links []*Link, window int) []*Link {
+	linked := make(map[string]bool)
+	for _, link := range links {
+		linked[link.From] = true
+		linked[link.To] = true
+	}
+
+	var payments, funding []*Transaction
+	for _, tx := range transactions {
+		if linked[tx.Id()] || tx.Amount == 0 {
+			continue
+		}
+		if len(wallets[tx.Id()]) > 0 {
+			payments = append(payments, tx)
+		} else {
+			funding = append(funding, tx)
+		}
+	}
+	sort.SliceStable(payments, func(i, j int) bool { return payments[i].Date.Before(payments[j].Date) })
+
+	var found []*Link
+	for _, payment := range payments {
+		memo := walletFundingMemos[wallets[payment.Id()]]
+		var best *Transaction
+		var bestGap time.Duration
+		for _, tx := range funding {
+			if linked[tx.Id()] || !memo.MatchString(tx.Memo) || math.Abs(tx.Amount-payment.Amount) > 0.005 {
+				continue
+			}
+			if tx.Date.Before(payment.Date.AddDate(0, 0, -1)) || tx.Date.After(payment.Date.AddDate(0, 0, window)) {
+				continue
+			}
+			if gap := abs(tx.Date.Sub(payment.Date)); best == nil || gap < bestGap {
+				best, bestGap = tx, gap
+			}
+		}
+
+		if best != nil {
+			linked[best.Id()] = true
+			found = append(found, &Link{best.Id(), payment.Id(), LinkFundedBy})
+		}
+	}
+	return found
+}
+
+// Links the wallet payments to their funding transactions, whichever of the
+// two was imported first.  The database is only opened for writing when
+// there are links to write.
+func (pdb *PennyDb) LinkFundingSources() ([]*Link, error) {
+	wallets, err := pdb.walletPayments()
+	if err != nil {
+		return nil, err
+	}
+	if len(wallets) == 0 {
+		return nil, nil
+	}
+
+	pdb.mutex.Lock()
+	defer pdb.mutex.Unlock()
+
+	links := FindFundingLinks(pdb.txCache, wallets, pdb.linkCache, fundingWindow)
+	if len(links) == 0 {
+		return nil, nil
+	}
+
+	handle, err := pdb.OpenReadWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	for _, link := range links {
+		_, err = handle.Exec(`REPLACE INTO tx_link (from_id, to_id, kind) VALUES (?, ?, ?)`, link.From, link.To, string(link.Kind))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pdb.linkCache, err = handle.AllLinks()
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// The wallet of every transaction paid through PayPal or Venmo, by ID
+func (pdb *PennyDb) walletPayments() (map[string]string, error) {
+	handle, err := pdb.OpenReadOnly()
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	rows, err := handle.Query("SELECT date, amount, memo, disambiguation, external_id FROM tx WHERE external_id != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wallets := make(map[string]string)
+	for rows.Next() {
+		var tx Transaction
+		var date, externalId string
+		err = rows.Scan(&date, &tx.Amount, &tx.Memo, &tx.Disambiguation, &externalId)
+		if err != nil {
+			return nil, err
+		}
+		tx.Date, err = time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, err
+		}
+		if wallet := walletOf(externalId); len(wallet) > 0 {
+			wallets[tx.Id()] = wallet
+		}
+	}
+
+	return wallets, rows.Err()
+}
+
+// PayPal rows that move money between PayPal and a bank or card, or that
+// only record an authorization, aren't payments
+var paypalSkippedTypes = regexp.MustCompile(`(?i)deposit|withdrawal|transfer|authorization|currency conversion`)
+
+// PayPalImporter reads the activity CSV downloaded from PayPal.  Each
+// completed payment is imported with the counterparty as memo and the PayPal
+// note as note.  Funding legs and authorizations are left out.
+type PayPalImporter struct{}
+
+func (PayPalImporter) Name() string {
+	return "paypal"
+}
+
+func (PayPalImporter) Detect(filename string, contents []byte) int {
+	return detectCsvColumns(filename, contents, "Name", "Type", "Gross", "Transaction ID")
+}
+
+func (paypal PayPalImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	_, rows, err := readCsvRows(contents, "Name", "Type", "Gross", "Transaction ID")
+	if err != nil {
+		return err
+	}
+
+	return importCsvRows(paypal.Name(), rows, importer, func(row map[string]string) error {
+		if !strings.EqualFold(row["Status"], "Completed") || strings.EqualFold(row["Balance Impact"], "Memo") || paypalSkippedTypes.MatchString(row["Type"]) {
+			return nil
+		}
+
+		date, err := parseDateFormats(row["Date"], "1/2/2006", "2006-01-02")
+		if err != nil {
+			return err
+		}
+		amount, err := parseMoney(defaultString(row["Net"], row["Gross"]))
+		if err != nil {
+			return err
+		}
+
+		memo := defaultString(row["Name"], defaultString(row["Item Title"], row["Type"]))
+		tx := &Transaction{defaultString(source, "paypal"), date, memo, amount, "", defaultString(row["Currency"], DefaultCurrency), "", false}
+		importer.AddExternal(tx, "paypal:"+row["Transaction ID"])
+		importer.AddNote(tx, defaultString(row["Note"], defaultString(row["Subject"], row["Item Title"])))
+		return nil
+	})
+}
+
+// VenmoImporter reads the monthly statement CSV downloaded from Venmo.  The
+// memo is the other party of a payment or charge, and the note is the Venmo
+// note.  Transfers between Venmo and the bank are left out.
+type VenmoImporter struct{}
+
+func (VenmoImporter) Name() string {
+	return "venmo"
+}
+
+func (VenmoImporter) Detect(filename string, contents []byte) int {
+	return detectCsvColumns(filename, contents, "ID", "Datetime", "Amount (total)", "Funding Source")
+}
+
+func (venmo VenmoImporter) Import(source string, contents []byte, importer *TransactionImporter) error {
+	_, rows, err := readCsvRows(contents, "ID", "Datetime", "Amount (total)")
+	if err != nil {
+		return err
+	}
+
+	return importCsvRows(venmo.Name(), rows, importer, func(row map[string]string) error {
+		// Balance rows at the start and end of the statement have no ID
+		if len(row["ID"]) == 0 || !strings.EqualFold(row["Status"], "Complete") || strings.Contains(row["Type"], "Transfer") {
+			return nil
+		}
+
+		datetime := row["Datetime"]
+		if len(datetime) > 10 {
+			datetime = datetime[:10]
+		}
+		date, err := time.Parse("2006-01-02", datetime)
+		if err != nil {
+			return err
+		}
+		amount, err := parseMoney(strings.ReplaceAll(row["Amount (total)"], " ", ""))
+		if err != nil {
+			return err
+		}
+
+		counterparty := row["From"]
+		if amount < 0 {
+			counterparty = row["To"]
+		}
+
+		tx := &Transaction{defaultString(source, "venmo"), date, defaultString(counterparty, row["Type"]), amount, "", DefaultCurrency, "", false}
+		importer.AddExternal(tx, "venmo:"+row["ID"])
+		importer.AddNote(tx, row["Note"])
+		return nil
+	})
+}
+
+func init() {
+	RegisterImporter(PayPalImporter{})
+	RegisterImporter(VenmoImporter{})
+}
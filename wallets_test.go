@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const paypalSample = `"Date","Time","TimeZone","Name","Type","Status","Currency","Gross","Fee","Net","From Email Address","To Email Address","Transaction ID","Item Title","Subject","Note","Balance Impact"
+"01/04/2021","10:00:00","PST","Jane's Bakery","General Payment","Completed","USD","-25.00","0.00","-25.00","me@example.com","jane@example.com","9AB","","","birthday cake","Debit"
+"01/04/2021","10:00:00","PST","","Bank Deposit to PP Account ","Completed","USD","25.00","0.00","25.00","","","9AC","","","","Credit"
+"01/06/2021","09:00:00","PST","Gadget Shop","Express Checkout Payment","Pending","USD","-99.00","0.00","-99.00","","","9AD","Widget","","","Debit"
+"01/07/2021","09:00:00","PST","Gadget Shop","General Authorization","Completed","USD","-10.00","0.00","-10.00","","","9AE","","","","Memo"
+`
+
+const venmoSample = `Account Statement - (@me) ,,,,,,,,,,,,,,,,,,,,,
+Account Activity,,,,,,,,,,,,,,,,,,,,,
+,ID,Datetime,Type,Status,Note,From,To,Amount (total),Amount (tip),Amount (tax),Amount (fee),Tax Rate,Tax Exempt,Funding Source,Destination,Beginning Balance,Ending Balance,Statement Period Venmo Fees,Terminal Location,Year to Date Venmo Fees,Disclaimer
+,,,,,,,,,,,,,,,,$0.00,,,,,
+,3211,2021-01-08T19:30:00,Payment,Complete,pizza 🍕,Me,Alex,- $18.50,,0,,0,,Chase Visa *1234,,,,,Venmo,,
+,3212,2021-01-09T08:00:00,Payment,Complete,rent share,Sam,Me,+ $600.00,,0,,0,,,Venmo balance,,,,Venmo,,
+,3213,2021-01-10T08:00:00,Standard Transfer,Issued,,,,- $600.00,,0,,0,,,Bank *5678,,,,Venmo,,
+,,,,,,,,,,,,,,,,,$0.00,,,,
+`
+
+func TestPayPalImporter(t *testing.T) {
+	if (PayPalImporter{}).Detect("Download.CSV", []byte(paypalSample)) == 0 {
+		t.Fatalf("expected PayPal activity to be detected")
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, PayPalImporter{}.Import("", []byte(paypalSample), importer))
+
+	txs := importer.All()
+	if len(txs) != 1 {
+		t.Fatalf("expected only the completed payment, got %v", txs)
+	}
+	tx := txs[0]
+	if tx.Source != "paypal" || tx.Memo != "Jane's Bakery" || tx.Amount != -25 || importer.notes[tx.Id()] != "birthday cake" {
+		t.Fatalf("unexpected payment %v", tx)
+	}
+	if id := importer.txExternalIds[tx.Id()]; id != "paypal:9AB" {
+		t.Fatalf("unexpected external ID %q", id)
+	}
+}
+
+func TestVenmoImporter(t *testing.T) {
+	if (VenmoImporter{}).Detect("venmo_statement.csv", []byte(venmoSample)) == 0 {
+		t.Fatalf("expected a Venmo statement to be detected")
+	}
+
+	importer := NewTransactionImporter()
+	fail(t, VenmoImporter{}.Import("", []byte(venmoSample), importer))
+
+	txs := importer.All()
+	if len(txs) != 2 {
+		t.Fatalf("expected the two payments, got %v", txs)
+	}
+	for _, tx := range txs {
+		switch tx.Amount {
+		case -18.50:
+			if tx.Memo != "Alex" || importer.notes[tx.Id()] != "pizza 🍕" || tx.Date.Format("2006-01-02") != "2021-01-08" {
+				t.Fatalf("unexpected payment %v", tx)
+			}
+		case 600:
+			if tx.Memo != "Sam" || tx.Source != "venmo" {
+				t.Fatalf("unexpected payment %v", tx)
+			}
+		default:
+			t.Fatalf("unexpected transaction %v", tx)
+		}
+	}
+}
+
+func TestFindFundingLinks(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	payment := &Transaction{"paypal", date("2021-01-04"), "Jane's Bakery", -25, "", "USD", "", false}
+	funding := &Transaction{"dcu", date("2021-01-05"), "PAYPAL *INST XFER", -25, "", "USD", "", false}
+	other := &Transaction{"dcu", date("2021-01-05"), "BAKERY", -25, "", "USD", "", false}
+	late := &Transaction{"dcu", date("2021-01-20"), "PAYPAL *INST XFER", -25, "", "USD", "", false}
+	txs := []*Transaction{payment, funding, other, late}
+	wallets := map[string]string{payment.Id(): "paypal"}
+
+	links := FindFundingLinks(txs, wallets, nil, fundingWindow)
+	if len(links) != 1 || links[0].From != funding.Id() || links[0].To != payment.Id() || links[0].Kind != LinkFundedBy {
+		t.Fatalf("expected the PayPal transfer to fund the payment, got %v", links)
+	}
+	if links := FindFundingLinks(txs, wallets, links, fundingWindow); len(links) != 0 {
+		t.Fatalf("expected linked payments not to be linked again, got %v", links)
+	}
+}
+
+func TestWalletFundingCountsOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wallets")
+	fail(t, err)
+	defer os.RemoveAll(dir)
+
+	pdb, err := NewPennyDb(filepath.Join(dir, "db"), NewLogger(), []byte("01234567890123456789012345678901"))
+	fail(t, err)
+	fail(t, pdb.LoadCaches())
+
+	// The bank side is imported first, the funding link is made when the
+	// PayPal activity follows
+	bank := filepath.Join(dir, "bank.csv")
+	fail(t, ioutil.WriteFile(bank, []byte("DATE,DESCRIPTION,AMOUNT\n01/05/2021,PAYPAL *INST XFER,-25.00\n01/05/2021,GROCER,-40.00\n"), 0600))
+	_, err = ImportFiles(pdb, []string{bank}, "dcu", "")
+	fail(t, err)
+
+	// Without links to make the database isn't written
+	before, err := ioutil.ReadFile(filepath.Join(dir, "db"))
+	fail(t, err)
+	links, err := pdb.LinkFundingSources()
+	fail(t, err)
+	after, err := ioutil.ReadFile(filepath.Join(dir, "db"))
+	fail(t, err)
+	if len(links) != 0 || !bytes.Equal(before, after) {
+		t.Fatalf("expected no links and no write, got %v", links)
+	}
+
+	paypal := filepath.Join(dir, "Download.csv")
+	fail(t, ioutil.WriteFile(paypal, []byte(paypalSample), 0600))
+	plan, err := PlanImport(pdb, []string{paypal}, "", "")
+	fail(t, err)
+	fail(t, plan.Apply(pdb))
+	if plan.Summaries[0].Importer != "paypal" || plan.Linked != 1 {
+		t.Fatalf("expected the payment to be linked, got %+v (%d linked)", plan.Summaries[0], plan.Linked)
+	}
+	fail(t, pdb.LoadCaches())
+
+	// The funding link isn't an edit, so rolling back the PayPal import
+	// removes the payment and its link, and importing it again links it again
+	rollback, err := pdb.RollbackBatch(plan.Summaries[0].Batch.Id)
+	fail(t, err)
+	if rollback.Transactions != 1 || len(pdb.Links()) != 0 {
+		t.Fatalf("unexpected rollback %+v with links %v", rollback, pdb.Links())
+	}
+	plan, err = PlanImport(pdb, []string{paypal}, "", "")
+	fail(t, err)
+	fail(t, plan.Apply(pdb))
+	if plan.Linked != 1 {
+		t.Fatalf("expected the payment to be linked again")
+	}
+	fail(t, pdb.LoadCaches())
+
+	var payment *Transaction
+	for _, tx := range pdb.AllTransactions() {
+		if tx.Memo == "Jane's Bakery" {
+			payment = tx
+		}
+	}
+	categorized := *payment
+	categorized.Category = "food"
+	fail(t, pdb.Update([]*Transaction{&categorized}))
+
+	slice := pdb.DefaultSlice()
+	totals := map[string]float64{}
+	for _, summary := range slice.CategorySummaries() {
+		totals[summary.Category] = summary.Total
+	}
+	if totals["food"] != -25 || totals[""] != -40 {
+		t.Fatalf("expected only the PayPal side of the payment to count, got %v", totals)
+	}
+	if expenses := slice.Totals().Expenses; expenses != -65 {
+		t.Fatalf("expected expenses of -65, got %.2f", expenses)
+	}
+}